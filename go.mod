@@ -8,9 +8,13 @@ require (
 	github.com/go-chi/cors v1.2.1 // CORS (chi-compatible)
 	github.com/google/uuid v1.6.0 // UUID generation
 	github.com/gorilla/websocket v1.5.3 // WebSocket
+	github.com/jackc/pgx/v5 v5.7.5 // PostgreSQL driver
+	github.com/quic-go/quic-go v0.59.1 // HTTP/3 (QUIC)
 
 	// Utilities
 	github.com/robfig/cron/v3 v3.0.1 // Scheduler
+	golang.org/x/crypto v0.46.0 // Password hashing (Argon2)
+	golang.org/x/sys v0.39.0 // Socket options (TCP_INFO)
 
 	// Core
 	gopkg.in/yaml.v3 v3.0.1 // YAML config
@@ -20,14 +24,17 @@ require (
 
 require (
 	github.com/dustin/go-humanize v1.0.1 // indirect
+	github.com/jackc/pgpassfile v1.0.0 // indirect
+	github.com/jackc/pgservicefile v0.0.0-20240606120523-5a60cdf6a761 // indirect
+	github.com/jackc/puddle/v2 v2.2.2 // indirect
+	github.com/kr/text v0.2.0 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
 	github.com/ncruces/go-strftime v0.1.9 // indirect
+	github.com/quic-go/qpack v0.6.0 // indirect
 	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
-	golang.org/x/crypto v0.46.0 // indirect
-	golang.org/x/mod v0.17.0 // indirect
-	golang.org/x/sync v0.10.0 // indirect
-	golang.org/x/sys v0.39.0 // indirect
-	golang.org/x/tools v0.21.1-0.20240508182429-e35e4ccd0d2d // indirect
+	golang.org/x/net v0.47.0 // indirect
+	golang.org/x/sync v0.19.0 // indirect
+	golang.org/x/text v0.32.0 // indirect
 	modernc.org/libc v1.55.3 // indirect
 	modernc.org/mathutil v1.6.0 // indirect
 	modernc.org/memory v1.8.0 // indirect
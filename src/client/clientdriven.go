@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+// clientResult mirrors the server's accepted submission format.
+type clientResult struct {
+	DownloadMbps       float64 `json:"download_mbps"`
+	UploadMbps         float64 `json:"upload_mbps"`
+	PingMs             float64 `json:"ping_ms"`
+	JitterMs           float64 `json:"jitter_ms"`
+	PacketLoss         float64 `json:"packet_loss"`
+	DownloadBytes      int64   `json:"download_bytes"`
+	UploadBytes        int64   `json:"upload_bytes"`
+	DownloadDurationMs float64 `json:"download_duration_ms"`
+	UploadDurationMs   float64 `json:"upload_duration_ms"`
+
+	Setup *setupStats `json:"setup,omitempty"`
+}
+
+// runClientDrivenTest measures from the client's side: it opens a test
+// session, runs every stage itself against the data endpoints and then
+// submits its figures for the server to cross-check and store.
+func runClientDrivenTest(serverURL, token string, enableShare bool, params testParams) error {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return fmt.Errorf("invalid server URL: %w", err)
+	}
+	baseURL := fmt.Sprintf("%s://%s", u.Scheme, u.Host)
+
+	session, err := startSession(baseURL, enableShare, params)
+	if err != nil {
+		return err
+	}
+
+	summary, err := runClientSession(u, baseURL, session)
+	if err != nil {
+		return err
+	}
+	summary.print(baseURL)
+	return nil
+}
+
+// runClientSession runs one client-driven test session and submits it.
+func runClientSession(u *url.URL, baseURL string, session testSession) (runSummary, error) {
+	var result clientResult
+	if err := startTransport(session); err != nil {
+		return runSummary{}, err
+	}
+	var err error
+
+	if session.runs("ping") {
+		fmt.Println("🏓 Testing ping...")
+		result.PingMs, result.JitterMs, result.PacketLoss = measurePing(baseURL, session.TestID)
+		fmt.Println()
+	}
+
+	if session.runs("setup") {
+		fmt.Println("🔌 Testing connection setup...")
+		result.Setup, err = measureSetup(baseURL, session.TestID)
+		fmt.Println()
+		if err != nil {
+			return runSummary{}, err
+		}
+		printSetup(result.Setup)
+	}
+
+	// The server records the UDP stage itself; its figures come back with
+	// the stored result
+	var echo udpEcho
+	if session.runs("udp") && session.UDP != nil {
+		fmt.Println("📶 Testing UDP loss and jitter...")
+		plan := *session.UDP
+		echo, err = runUDP(u.Hostname(), session.TestID, plan, func(sent int, e udpEcho) {
+			fmt.Printf("\r%s %.0f%%  %.1f%% lost", makeProgressBar(sent*50/plan.Count), float64(sent)/float64(plan.Count)*100, e.LossPct)
+		})
+		fmt.Println()
+		if err != nil {
+			return runSummary{}, err
+		}
+	}
+
+	if session.runs("download") {
+		fmt.Println("⬇️  Testing download...")
+		result.DownloadMbps, result.DownloadBytes, result.DownloadDurationMs = measureStage(baseURL, "download", session)
+		fmt.Println()
+	}
+
+	if session.runs("upload") {
+		fmt.Println("⬆️  Testing upload...")
+		result.UploadMbps, result.UploadBytes, result.UploadDurationMs = measureStage(baseURL, "upload", session)
+		fmt.Println()
+	}
+
+	body, _ := json.Marshal(result)
+	resp, err := httpClient.Post(fmt.Sprintf("%s/api/v1/speedtest/result/%s", baseURL, url.PathEscape(session.TestID)), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return runSummary{}, fmt.Errorf("submitting result: %w", err)
+	}
+	var stored struct {
+		ID        string `json:"id"`
+		ShareCode string `json:"share_code"`
+		IPFamily  string `json:"ip_family"`
+		UDP       *struct {
+			LossPct float64 `json:"loss_pct"`
+		} `json:"udp"`
+	}
+	if err := decodeResponse(resp, &stored); err != nil {
+		return runSummary{}, fmt.Errorf("submitting result: %w", err)
+	}
+
+	if stored.UDP != nil {
+		printUDP(stored.UDP.LossPct, echo)
+	}
+	return runSummary{
+		DownloadMbps: result.DownloadMbps,
+		UploadMbps:   result.UploadMbps,
+		PingMs:       result.PingMs,
+		IPFamily:     stored.IPFamily,
+		ShareCode:    stored.ShareCode,
+	}, nil
+}
+
+// measurePing times HTTP round trips to the ping endpoint over a warm
+// keep-alive connection. Jitter is the mean difference between
+// consecutive RTTs; failed or slow probes count as lost.
+func measurePing(baseURL, testID string) (avgMs, jitterMs, packetLoss float64) {
+	const samples = 10
+	client := &http.Client{Timeout: time.Second, Transport: dataClient.Transport}
+	pingURL := fmt.Sprintf("%s/api/v1/speedtest/ping?test_id=%s", baseURL, url.QueryEscape(testID))
+
+	probe := func() (float64, bool) {
+		start := time.Now()
+		resp, err := client.Get(pingURL)
+		if err != nil {
+			return 0, false
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return float64(time.Since(start).Microseconds()) / 1000, true
+	}
+
+	// The first request pays for connection setup
+	probe()
+
+	var trace, pings []float64
+	var diffSum float64
+	var diffs int
+	last := -1.0
+	for i := 0; i < samples; i++ {
+		ms, ok := probe()
+		if !ok {
+			trace = append(trace, -1)
+			last = -1
+		} else {
+			trace = append(trace, ms)
+			pings = append(pings, ms)
+			if last >= 0 {
+				diffSum += math.Abs(ms - last)
+				diffs++
+			}
+			last = ms
+		}
+		fmt.Printf("\r%s %.0f%%  %s", makeProgressBar((i+1)*50/samples), float64(i+1)/samples*100, makeSparkline(trace))
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	packetLoss = float64(samples-len(pings)) / samples * 100
+	if len(pings) == 0 {
+		return 0, 0, packetLoss
+	}
+	var sum float64
+	for _, p := range pings {
+		sum += p
+	}
+	if diffs > 0 {
+		jitterMs = diffSum / float64(diffs)
+	}
+	return sum / float64(len(pings)), jitterMs, packetLoss
+}
+
+// measureStage runs parallel transfers for the session's duration and
+// returns the client-observed rate along with the bytes and time behind it.
+func measureStage(baseURL, stage string, session testSession) (mbps float64, totalBytes int64, durationMs float64) {
+	var counter atomic.Int64
+	duration := time.Duration(session.Duration) * time.Second
+	start := time.Now()
+	stop := startStreams(baseURL, stage, session, session.Streams, &counter)
+
+	ticker := time.NewTicker(200 * time.Millisecond)
+	for now := range ticker.C {
+		elapsed := now.Sub(start)
+		if elapsed >= duration {
+			break
+		}
+		speed := float64(counter.Load()) * 8 / elapsed.Seconds() / 1_000_000
+		fmt.Printf("\r%s %.0f%%  %.1f Mbps", makeProgressBar(int(elapsed*50/duration)), float64(elapsed)/float64(duration)*100, speed)
+	}
+	ticker.Stop()
+	stop()
+
+	elapsed := time.Since(start)
+	totalBytes = counter.Load()
+	mbps = float64(totalBytes) * 8 / elapsed.Seconds() / 1_000_000
+	fmt.Printf("\r%s 100%%  %.1f Mbps", makeProgressBar(50), mbps)
+
+	return mbps, totalBytes, float64(elapsed.Milliseconds())
+}
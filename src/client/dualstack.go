@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+)
+
+// runDualStackTest runs the test once over IPv4 and once over IPv6 under
+// one parent test and prints the two results side by side. A family that
+// can't reach the server is reported and skipped.
+func runDualStackTest(serverURL string, enableShare bool, params testParams, clientDriven bool) error {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return fmt.Errorf("invalid server URL: %w", err)
+	}
+	baseURL := fmt.Sprintf("%s://%s", u.Scheme, u.Host)
+
+	params.DualStack = true
+	parent, err := startSession(baseURL, enableShare, params)
+	if err != nil {
+		return err
+	}
+	if !parent.DualStack || len(parent.Runs) == 0 {
+		return errors.New("server does not support dual-stack tests")
+	}
+	fmt.Printf("🌐 Dual-stack test, started over %s\n\n", familyName(parent.ClientFamily))
+
+	run := runServerSession
+	if clientDriven {
+		run = runClientSession
+	}
+
+	summaries := make(map[string]runSummary)
+	defer pinFamily("", "")
+	for _, familyRun := range parent.Runs {
+		name := familyName(familyRun.IPFamily)
+		fmt.Printf("── %s ──\n", name)
+
+		pinFamily(familyRun.IPFamily, familyRun.Address)
+		if err := checkReachable(baseURL); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %s: server not reachable: %v\n\n", name, err)
+			continue
+		}
+		session := parent
+		session.TestID = familyRun.TestID
+		summary, err := run(u, baseURL, session)
+		if errors.Is(err, errTestCancelled) {
+			return err
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %s: %v\n\n", name, err)
+			continue
+		}
+		if summary.IPFamily != "" && summary.IPFamily != familyRun.IPFamily {
+			fmt.Printf("⚠️  Server saw this run over %s\n", familyName(summary.IPFamily))
+		}
+		summaries[familyRun.IPFamily] = summary
+		fmt.Println()
+	}
+
+	v4, ok4 := summaries["ipv4"]
+	v6, ok6 := summaries["ipv6"]
+	row := func(label, unit string, value func(runSummary) float64) {
+		fmt.Printf("│  %-9s %14s %14s %-4s   │\n", label, cell(ok4, value(v4)), cell(ok6, value(v6)), unit)
+	}
+	fmt.Println("╭─────────────────────────────────────────────────╮")
+	fmt.Println("│  ✅ Results                                     │")
+	fmt.Println("├─────────────────────────────────────────────────┤")
+	fmt.Printf("│  %-9s %14s %14s %-4s   │\n", "", "IPv4", "IPv6", "")
+	row("Download:", "Mbps", func(r runSummary) float64 { return r.DownloadMbps })
+	row("Upload:", "Mbps", func(r runSummary) float64 { return r.UploadMbps })
+	row("Ping:", "ms", func(r runSummary) float64 { return r.PingMs })
+	fmt.Println("╰─────────────────────────────────────────────────╯")
+
+	for _, familyRun := range parent.Runs {
+		if code := summaries[familyRun.IPFamily].ShareCode; code != "" {
+			fmt.Printf("🔗 %s share: %s/s/%s\n", familyName(familyRun.IPFamily), baseURL, code)
+		}
+	}
+	fmt.Printf("📋 Result: %s/api/v1/speedtest/result/%s\n", baseURL, parent.TestID)
+
+	if len(summaries) == 0 {
+		return errors.New("no family could reach the server")
+	}
+	return nil
+}
+
+// checkReachable makes one request over the pinned family, so a family
+// without a route fails up front rather than part-way through a run.
+func checkReachable(baseURL string) error {
+	resp, err := httpClient.Get(baseURL + "/api/v1/speedtest/ping")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// cell formats one table value, or a dash for a family that didn't run.
+func cell(ok bool, v float64) string {
+	if !ok {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f", v)
+}
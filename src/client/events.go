@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// progressStream delivers the updates of a server-driven run.
+type progressStream interface {
+	// next returns the next update, or an error once the stream ends
+	next() (map[string]interface{}, error)
+	// cancel asks the server to stop the run
+	cancel()
+	close()
+}
+
+// openProgress attaches to the session over a WebSocket, falling back to
+// Server-Sent Events when the upgrade fails, as behind proxies that
+// block WebSockets.
+func openProgress(u *url.URL, baseURL string, session testSession) (progressStream, error) {
+	wsScheme := "ws"
+	if u.Scheme == "https" {
+		wsScheme = "wss"
+	}
+	wsURL := fmt.Sprintf("%s://%s/api/v1/speedtest/ws?test_id=%s", wsScheme, u.Host, url.QueryEscape(session.TestID))
+
+	dialer := websocket.Dialer{
+		HandshakeTimeout: 10 * time.Second,
+		NetDialContext:   dialContext,
+	}
+	conn, _, err := dialer.Dial(wsURL, nil)
+	if err == nil {
+		return &wsProgress{conn: conn}, nil
+	}
+
+	fmt.Printf("⚠️  WebSocket unavailable (%v), using Server-Sent Events\n", err)
+	return openEvents(baseURL, session)
+}
+
+// wsProgress reads updates from the WebSocket.
+type wsProgress struct {
+	conn *websocket.Conn
+}
+
+func (p *wsProgress) next() (map[string]interface{}, error) {
+	var update map[string]interface{}
+	err := p.conn.ReadJSON(&update)
+	return update, err
+}
+
+func (p *wsProgress) cancel() {
+	p.conn.WriteJSON(map[string]string{"type": "cancel"})
+}
+
+func (p *wsProgress) close() {
+	p.conn.Close()
+}
+
+// sseProgress reads updates from the event stream, answering the
+// server's "ping" events as they come.
+type sseProgress struct {
+	baseURL   string
+	testID    string
+	resp      *http.Response
+	reader    *bufio.Reader
+	cancelled atomic.Bool
+}
+
+func openEvents(baseURL string, session testSession) (*sseProgress, error) {
+	req, err := http.NewRequest("GET", baseURL+"/api/v1/speedtest/events?test_id="+url.QueryEscape(session.TestID), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("event stream returned status %d", resp.StatusCode)
+	}
+
+	return &sseProgress{
+		baseURL: baseURL,
+		testID:  session.TestID,
+		resp:    resp,
+		reader:  bufio.NewReader(resp.Body),
+	}, nil
+}
+
+func (p *sseProgress) next() (map[string]interface{}, error) {
+	var event, data string
+	for {
+		line, err := p.reader.ReadString('\n')
+		if err != nil {
+			// Hanging up is how an event stream is cancelled, so the
+			// server's "aborted" update never arrives
+			if p.cancelled.Load() {
+				return map[string]interface{}{"stage": "aborted"}, nil
+			}
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		switch {
+		case line == "":
+			if data == "" {
+				event = ""
+				continue
+			}
+			if event == "ping" {
+				go p.pong(data)
+				event, data = "", ""
+				continue
+			}
+			var update map[string]interface{}
+			if err := json.Unmarshal([]byte(data), &update); err != nil {
+				return nil, err
+			}
+			return update, nil
+		case strings.HasPrefix(line, ":"):
+			// Keepalive comment
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			if data != "" {
+				data += "\n"
+			}
+			data += strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " ")
+		}
+	}
+}
+
+// pong echoes a latency probe back to the server.
+func (p *sseProgress) pong(data string) {
+	var probe struct {
+		Seq int `json:"seq"`
+	}
+	if json.Unmarshal([]byte(data), &probe) != nil {
+		return
+	}
+
+	pongURL := fmt.Sprintf("%s/api/v1/speedtest/pong?test_id=%s&seq=%d", p.baseURL, url.QueryEscape(p.testID), probe.Seq)
+	resp, err := httpClient.Post(pongURL, "", nil)
+	if err == nil {
+		resp.Body.Close()
+	}
+}
+
+func (p *sseProgress) cancel() {
+	p.cancelled.Store(true)
+	p.resp.Body.Close()
+}
+
+func (p *sseProgress) close() {
+	p.resp.Body.Close()
+}
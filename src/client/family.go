@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Dual-stack runs pin every connection to one IP family. They are only
+// changed between runs, while no transfers are in flight.
+var (
+	pinnedFamily  string // "", "ipv4" or "ipv6"
+	pinnedAddress string // Server address to fall back to for the family
+)
+
+var transport = newTransport()
+
+// httpClient is used for every API and data request.
+var httpClient = &http.Client{Transport: transport}
+
+func newTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DialContext = dialContext
+	return t
+}
+
+// dialContext dials over the pinned family, if any. When the server's
+// hostname has no address in that family it falls back to the address
+// the server advertised for it; the Host header and TLS name stay the
+// hostname's.
+func dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	d := net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	network = familyNetwork(network)
+
+	conn, err := d.DialContext(ctx, network, addr)
+	if err != nil && pinnedAddress != "" {
+		if _, port, splitErr := net.SplitHostPort(addr); splitErr == nil {
+			return d.DialContext(ctx, network, net.JoinHostPort(pinnedAddress, port))
+		}
+	}
+	return conn, err
+}
+
+// familyNetwork narrows "tcp" or "udp" to the pinned family.
+func familyNetwork(network string) string {
+	switch pinnedFamily {
+	case "ipv4":
+		return network + "4"
+	case "ipv6":
+		return network + "6"
+	}
+	return network
+}
+
+// pinFamily restricts new connections to family ("" for either) and
+// drops idle ones made under the previous setting.
+func pinFamily(family, address string) {
+	pinnedFamily, pinnedAddress = family, address
+	transport.CloseIdleConnections()
+}
+
+// familyName is how an IP family is shown to the user.
+func familyName(family string) string {
+	switch family {
+	case "ipv4":
+		return "IPv4"
+	case "ipv6":
+		return "IPv6"
+	}
+	return "unknown"
+}
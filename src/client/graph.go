@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// statsBucket is one bucket of /api/v1/stats.
+type statsBucket struct {
+	Start    time.Time   `json:"start"`
+	Tests    int         `json:"tests"`
+	Download metricStats `json:"download_mbps"`
+	Upload   metricStats `json:"upload_mbps"`
+	Ping     metricStats `json:"ping_ms"`
+}
+
+type metricStats struct {
+	Min    float64  `json:"min"`
+	Avg    float64  `json:"avg"`
+	Median *float64 `json:"median"`
+	P95    *float64 `json:"p95"`
+}
+
+// graphWidth is the length of the longest download bar.
+const graphWidth = 30
+
+// showGraph prints the server's statistics for dateRange
+// (YYYY-MM-DD:YYYY-MM-DD, both days included) with a bar per day, or per
+// week for ranges over two months. With serverID, only results from that
+// server of the registry are counted.
+func showGraph(serverURL, dateRange, serverID string) error {
+	from, to, ok := strings.Cut(dateRange, ":")
+	start, err1 := time.Parse(time.DateOnly, from)
+	end, err2 := time.Parse(time.DateOnly, to)
+	if !ok || err1 != nil || err2 != nil {
+		return fmt.Errorf("invalid date range %q: use YYYY-MM-DD:YYYY-MM-DD", dateRange)
+	}
+	bucket := "day"
+	if end.Sub(start) > 60*24*time.Hour {
+		bucket = "week"
+	}
+
+	baseURL, err := registryBase(serverURL)
+	if err != nil {
+		return err
+	}
+	q := url.Values{"bucket": {bucket}, "from": {from}, "to": {to}}
+	if serverID != serverAuto {
+		q.Set("server_id", serverID)
+	}
+	resp, err := httpClient.Get(baseURL + "/api/v1/stats?" + q.Encode())
+	if err != nil {
+		return err
+	}
+	var stats struct {
+		Buckets []statsBucket `json:"buckets"`
+	}
+	if err := decodeResponse(resp, &stats); err != nil {
+		return fmt.Errorf("fetching statistics: %w", err)
+	}
+
+	fmt.Printf("📊 Results %s to %s, by %s\n\n", from, to, bucket)
+	if len(stats.Buckets) == 0 {
+		fmt.Println("No results in this range")
+		return nil
+	}
+
+	var top float64
+	for _, b := range stats.Buckets {
+		top = max(top, b.Download.Avg)
+	}
+	// The download column holds the bar and, after it, the figure
+	fmt.Printf("  %-10s %5s  %-*s %12s %10s\n", strings.ToUpper(bucket[:1])+bucket[1:], "Tests", graphWidth+15, "Download (avg)", "Upload (avg)", "Ping (med)")
+	for _, b := range stats.Buckets {
+		bar := 0
+		if top > 0 {
+			bar = int(b.Download.Avg/top*graphWidth + 0.5)
+		}
+		ping := b.Ping.Avg
+		if b.Ping.Median != nil {
+			ping = *b.Ping.Median
+		}
+		fmt.Printf("  %-10s %5d  %-*s %9.1f Mbps %7.1f Mbps %7.1f ms\n",
+			b.Start.Format(time.DateOnly), b.Tests, graphWidth, strings.Repeat("█", bar), b.Download.Avg, b.Upload.Avg, ping)
+	}
+	return nil
+}
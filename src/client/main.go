@@ -1,15 +1,15 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
-	"time"
-
-	"github.com/gorilla/websocket"
 )
 
 var (
@@ -31,17 +31,32 @@ func main() {
 		showHelp    bool
 		showVersion bool
 		serverURL   string
+		serverID    string
+		listPeers   bool
 		token       string
 		share       string
 		graph       string
+		clientSide  bool
+		dualStack   bool
+		params      testParams
 	)
 
 	flag.BoolVar(&showHelp, "help", false, "Show help")
 	flag.BoolVar(&showVersion, "version", false, "Show version")
 	flag.StringVar(&serverURL, "server", "", "Server URL")
+	flag.StringVar(&serverID, "server-id", serverAuto, "Registry server to test against (auto: lowest latency)")
+	flag.BoolVar(&listPeers, "list-servers", false, "List the server's registry with latencies")
 	flag.StringVar(&token, "token", "", "API token")
 	flag.StringVar(&share, "share", "true", "Enable share link (true/false)")
 	flag.StringVar(&graph, "graph", "", "Show graph for date range (YYYY-MM-DD:YYYY-MM-DD)")
+	flag.BoolVar(&clientSide, "client-driven", false, "Measure on the client and submit the result")
+	flag.BoolVar(&dualStack, "dual-stack", false, "Run once over IPv4 and once over IPv6")
+	flag.IntVar(&params.Duration, "duration", 0, "Seconds per download/upload stage")
+	flag.IntVar(&params.Streams, "streams", 0, "Parallel transfers per stage")
+	flag.StringVar(&params.Stages, "stages", "", "Stages to run (ping,setup,udp,download,upload)")
+	flag.IntVar(&params.PayloadSize, "payload-size", 0, "Bytes per download response and upload request")
+	flag.StringVar(&params.Adaptive, "adaptive", "", "End stages once throughput is stable (true/false)")
+	flag.StringVar(&params.Transport, "transport", "", "Transport for data transfers (http1, h2, h3)")
 
 	flag.Usage = func() {
 		fmt.Printf(`%s - casspeed CLI Client
@@ -52,17 +67,32 @@ Options:
   --help              Show this help
   --version           Show version
   --server URL        Server URL (default: http://localhost:64580)
+  --server-id ID      Test against this server from its registry (default: auto, lowest latency)
+  --list-servers      List the server and its peers with their latencies
   --token TOKEN       API token for authenticated tests
   --share BOOL        Enable share link (default: true)
   --graph DATERANGE   Show historical graph (format: 2025-01-01:2025-01-31)
+  --client-driven     Measure on the client and submit the result to the server
+  --dual-stack        Run once over IPv4 and once over IPv6 and compare
+  --duration SECONDS  Seconds per download/upload stage (default: server's)
+  --streams N         Parallel transfers per stage (default: server's maximum)
+  --stages LIST       Stages to run: ping,setup,udp,download,upload (default: ping,download,upload)
+  --payload-size N    Bytes per download response and upload request
+  --adaptive BOOL     Ramp streams and end stages once throughput is stable
+  --transport NAME    Run transfers over http1, h2 or h3 (h2 and h3 need https)
 
 Examples:
   %s
   %s --server https://speed.example.com
   %s --token abc123 --share false
   %s --graph 2025-12-01:2025-12-31
+  %s --client-driven
+  %s --stages download --duration 5 --streams 1
+  %s --dual-stack
+  %s --server https://speed.example.com --transport h3
+  %s --server https://speed.example.com --server-id us-east
 
-`, binaryName, binaryName, binaryName, binaryName, binaryName, binaryName)
+`, binaryName, binaryName, binaryName, binaryName, binaryName, binaryName, binaryName, binaryName, binaryName, binaryName, binaryName)
 	}
 
 	flag.Parse()
@@ -86,7 +116,10 @@ Examples:
 	}
 
 	if graph != "" {
-		fmt.Println("📊 Historical graph not yet implemented")
+		if err := showGraph(serverURL, graph, serverID); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ Error: %v\n", err)
+			os.Exit(1)
+		}
 		os.Exit(0)
 	}
 
@@ -97,44 +130,104 @@ Examples:
 	fmt.Println("╰─────────────────────────────────────────────────╯")
 	fmt.Println()
 
-	if err := runTest(serverURL, token, share == "true"); err != nil {
+	if listPeers {
+		if err := listServers(serverURL); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ Error: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	serverURL, err := chooseServer(serverURL, serverID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	run := runTest
+	if clientSide {
+		run = runClientDrivenTest
+	}
+	if dualStack {
+		run = func(serverURL, token string, enableShare bool, params testParams) error {
+			return runDualStackTest(serverURL, enableShare, params, clientSide)
+		}
+	}
+
+	if err := run(serverURL, token, share == "true", params); err != nil {
 		fmt.Fprintf(os.Stderr, "❌ Error: %v\n", err)
 		os.Exit(1)
 	}
 }
 
-func runTest(serverURL, token string, enableShare bool) error {
+func runTest(serverURL, token string, enableShare bool, params testParams) error {
 	u, err := url.Parse(serverURL)
 	if err != nil {
 		return fmt.Errorf("invalid server URL: %w", err)
 	}
 
-	wsScheme := "ws"
-	if u.Scheme == "https" {
-		wsScheme = "wss"
+	baseURL := fmt.Sprintf("%s://%s", u.Scheme, u.Host)
+	session, err := startSession(baseURL, enableShare, params)
+	if err != nil {
+		return err
 	}
 
-	wsURL := fmt.Sprintf("%s://%s/api/v1/speedtest/ws", wsScheme, u.Host)
-	if !enableShare {
-		wsURL += "?share=false"
+	summary, err := runServerSession(u, baseURL, session)
+	if err != nil {
+		return err
 	}
+	summary.print(baseURL)
+	return nil
+}
 
-	dialer := websocket.Dialer{
-		HandshakeTimeout: 10 * time.Second,
+// runServerSession runs one server-driven test session, following its
+// progress over a WebSocket or, failing that, Server-Sent Events.
+func runServerSession(u *url.URL, baseURL string, session testSession) (runSummary, error) {
+	if err := startTransport(session); err != nil {
+		return runSummary{}, err
 	}
 
-	conn, _, err := dialer.Dial(wsURL, nil)
+	stream, err := openProgress(u, baseURL, session)
 	if err != nil {
-		return fmt.Errorf("connecting to server: %w", err)
+		return runSummary{}, fmt.Errorf("connecting to server: %w", err)
 	}
-	defer conn.Close()
+	defer stream.close()
+
+	// Ctrl-C asks the server to cancel; it answers with an "aborted" update
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-interrupt:
+			stream.cancel()
+		case <-done:
+		}
+	}()
 
-	var downloadSpeed, uploadSpeed, pingMs float64
+	var summary runSummary
+	var latencyTrace []float64
+	var running int
 	lastStage := ""
 
+	stopStreams := func() {}
+	defer func() { stopStreams() }()
+
+	// The UDP stage sends in the background while the server reports
+	type udpOutcome struct {
+		echo udpEcho
+		err  error
+	}
+	var udpDone chan udpOutcome
+
+	// So does the setup stage, reporting its figures when done
+	var setupDone chan error
+
 	for {
-		var update map[string]interface{}
-		if err := conn.ReadJSON(&update); err != nil {
+		update, err := stream.next()
+		if err != nil {
 			break
 		}
 
@@ -142,12 +235,68 @@ func runTest(serverURL, token string, enableShare bool) error {
 		progress, _ := update["progress"].(float64)
 		speed, _ := update["speed"].(float64)
 		message, _ := update["message"].(string)
+		streams, _ := update["streams"].(float64)
+
+		if stage == "ping" {
+			if seq, _ := update["seq"].(float64); seq > 0 {
+				rtt, _ := update["rtt_ms"].(float64)
+				if lost, _ := update["lost"].(bool); lost {
+					rtt = -1
+				}
+				latencyTrace = append(latencyTrace, rtt)
+			}
+		}
+
+		if stage == "udp" && progress == 0 && udpDone == nil {
+			var plan udpPlan
+			if raw, err := json.Marshal(update["udp"]); err == nil && json.Unmarshal(raw, &plan) == nil && plan.Count > 0 {
+				udpDone = make(chan udpOutcome, 1)
+				go func() {
+					echo, err := runUDP(u.Hostname(), session.TestID, plan, nil)
+					udpDone <- udpOutcome{echo, err}
+				}()
+			}
+		}
+
+		if stage == "setup" && progress == 0 && setupDone == nil {
+			setupDone = make(chan error, 1)
+			go func() {
+				// An empty report keeps the server from waiting on a failure
+				stats, err := measureSetup(baseURL, session.TestID)
+				if err != nil {
+					reportSetup(baseURL, session.TestID, &setupStats{})
+				} else {
+					err = reportSetup(baseURL, session.TestID, stats)
+				}
+				setupDone <- err
+			}()
+		}
+
+		// Adaptive tests raise the stream count mid-stage
+		if (stage == "download" || stage == "upload") && progress == 0 && streams > 0 {
+			stopStreams()
+			stopStreams = startStreams(baseURL, stage, session, int(streams), nil)
+			running = int(streams)
+		} else if (stage == "download" || stage == "upload") && int(streams) > running {
+			stopMore, stopRunning := startStreams(baseURL, stage, session, int(streams)-running, nil), stopStreams
+			stopStreams = func() { stopRunning(); stopMore() }
+			running = int(streams)
+		}
+		if progress >= 1.0 {
+			stopStreams()
+			stopStreams = func() {}
+			running = 0
+		}
 
 		if stage != lastStage {
 			lastStage = stage
 			switch stage {
 			case "ping":
 				fmt.Println("🏓 Testing ping...")
+			case "setup":
+				fmt.Println("🔌 Testing connection setup...")
+			case "udp":
+				fmt.Println("📶 Testing UDP loss and jitter...")
 			case "download":
 				fmt.Println("⬇️  Testing download...")
 			case "upload":
@@ -155,28 +304,76 @@ func runTest(serverURL, token string, enableShare bool) error {
 			}
 		}
 
-		if progress > 0 {
+		// The setup stage's progress is the client's own
+		if progress > 0 && stage != "setup" {
 			bar := makeProgressBar(int(progress * 50))
 			fmt.Printf("\r%s %.0f%%  %s", bar, progress*100, message)
 		}
 
 		if stage == "ping" && progress >= 1.0 {
-			pingMs = speed
+			summary.PingMs = speed
+			fmt.Println()
+			fmt.Printf("   Latency trace: %s\n", makeSparkline(latencyTrace))
+		} else if stage == "setup" && progress >= 1.0 {
+			fmt.Println()
+			if setupDone != nil {
+				if err := <-setupDone; err != nil {
+					fmt.Printf("⚠️  Connection setup test failed: %v\n", err)
+				}
+			}
+			var stats setupStats
+			if raw, err := json.Marshal(update["setup"]); err == nil && json.Unmarshal(raw, &stats) == nil && stats.Samples > 0 {
+				printSetup(&stats)
+			}
+		} else if stage == "udp" && progress >= 1.0 {
 			fmt.Println()
+			if udpDone != nil {
+				if outcome := <-udpDone; outcome.err == nil {
+					printUDP(speed, outcome.echo)
+				}
+			}
 		} else if stage == "download" && progress >= 1.0 {
-			downloadSpeed = speed
+			summary.DownloadMbps = speed
 			fmt.Println()
 		} else if stage == "upload" && progress >= 1.0 {
-			uploadSpeed = speed
+			summary.UploadMbps = speed
 			fmt.Println()
 		}
 
+		if stage == "aborted" {
+			fmt.Println()
+			return summary, errTestCancelled
+		}
+
 		if stage == "complete" {
+			summary.ShareCode, _ = update["share_code"].(string)
 			fmt.Println()
 			break
 		}
 	}
 
+	return summary, nil
+}
+
+var errTestCancelled = errors.New("test cancelled")
+
+// runSummary is what one finished run reports for printing.
+type runSummary struct {
+	DownloadMbps float64
+	UploadMbps   float64
+	PingMs       float64
+	IPFamily     string // Only known for client-driven runs
+	ShareCode    string
+}
+
+func (r runSummary) print(baseURL string) {
+	printResults(r.DownloadMbps, r.UploadMbps, r.PingMs)
+	if r.ShareCode != "" {
+		fmt.Printf("🔗 Share: %s/s/%s\n", baseURL, r.ShareCode)
+	}
+}
+
+func printResults(downloadSpeed, uploadSpeed, pingMs float64) {
 	fmt.Println()
 	fmt.Println("╭─────────────────────────────────────────────────╮")
 	fmt.Println("│  ✅ Results                                     │")
@@ -185,8 +382,31 @@ func runTest(serverURL, token string, enableShare bool) error {
 	fmt.Printf("│  Upload:   %-37.1f Mbps │\n", uploadSpeed)
 	fmt.Printf("│  Ping:     %-37.1f ms   │\n", pingMs)
 	fmt.Println("╰─────────────────────────────────────────────────╯")
+}
 
-	return nil
+// makeSparkline renders RTT samples as a one-line trace; lost probes
+// (negative values) are shown as a cross.
+func makeSparkline(samples []float64) string {
+	levels := []rune("▁▂▃▄▅▆▇█")
+	maxRTT := 0.0
+	for _, v := range samples {
+		if v > maxRTT {
+			maxRTT = v
+		}
+	}
+
+	line := make([]rune, 0, len(samples))
+	for _, v := range samples {
+		switch {
+		case v < 0:
+			line = append(line, '✗')
+		case maxRTT == 0:
+			line = append(line, levels[0])
+		default:
+			line = append(line, levels[int(v/maxRTT*float64(len(levels)-1))])
+		}
+	}
+	return string(line)
 }
 
 func makeProgressBar(width int) string {
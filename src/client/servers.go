@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// serverInfo is one entry of a server's registry.
+type serverInfo struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Location string `json:"location"`
+	URL      string `json:"url"`
+	Self     bool   `json:"self"`
+}
+
+// Server IDs with a special meaning for --server-id
+const (
+	serverAuto = "auto" // Lowest latency
+)
+
+// fetchRegistry returns the servers baseURL lists, itself first, with
+// empty URLs filled in with baseURL. Servers without a registry return
+// nothing.
+func fetchRegistry(baseURL string) ([]serverInfo, error) {
+	resp, err := httpClient.Get(baseURL + "/api/v1/servers")
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, nil
+	}
+	var registry struct {
+		Servers []serverInfo `json:"servers"`
+	}
+	if err := decodeResponse(resp, &registry); err != nil {
+		return nil, err
+	}
+
+	for i := range registry.Servers {
+		if registry.Servers[i].URL == "" {
+			registry.Servers[i].URL = baseURL
+		}
+	}
+	return registry.Servers, nil
+}
+
+// probeLatency returns the lowest of a few round trips to a server's ping
+// endpoint, after one that pays for connection setup.
+func probeLatency(serverURL string) (time.Duration, error) {
+	const samples = 3
+	client := &http.Client{Timeout: 2 * time.Second, Transport: transport}
+
+	best := time.Duration(-1)
+	var lastErr error
+	for i := 0; i <= samples; i++ {
+		start := time.Now()
+		resp, err := client.Get(serverURL + "/api/v1/speedtest/ping")
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		rtt := time.Since(start)
+		if i > 0 && (best < 0 || rtt < best) {
+			best = rtt
+		}
+	}
+	if best < 0 {
+		if lastErr == nil {
+			lastErr = errors.New("no answer")
+		}
+		return 0, lastErr
+	}
+	return best, nil
+}
+
+// chooseServer picks the server to test against from the registry of
+// serverURL: the one with serverID, or with serverAuto the one with the
+// lowest latency. Servers without peers are used as they are.
+func chooseServer(serverURL, serverID string) (string, error) {
+	baseURL, err := registryBase(serverURL)
+	if err != nil {
+		return "", err
+	}
+	servers, err := fetchRegistry(baseURL)
+	if err != nil {
+		return "", fmt.Errorf("listing servers: %w", err)
+	}
+
+	if serverID != serverAuto {
+		for _, s := range servers {
+			if s.ID == serverID {
+				fmt.Printf("🌍 Server: %s\n\n", describeServer(s))
+				return s.URL, nil
+			}
+		}
+		return "", fmt.Errorf("server %q is not in the registry; see --list-servers", serverID)
+	}
+	if len(servers) < 2 {
+		return serverURL, nil
+	}
+
+	var chosen serverInfo
+	best := time.Duration(-1)
+	for _, s := range servers {
+		rtt, err := probeLatency(s.URL)
+		if err != nil {
+			continue
+		}
+		if best < 0 || rtt < best {
+			chosen, best = s, rtt
+		}
+	}
+	if best < 0 {
+		return "", errors.New("no server in the registry answered")
+	}
+	fmt.Printf("🌍 Server: %s, %.1f ms (lowest latency)\n\n", describeServer(chosen), float64(best.Microseconds())/1000)
+	return chosen.URL, nil
+}
+
+// listServers prints the registry of serverURL with each server's
+// latency.
+func listServers(serverURL string) error {
+	baseURL, err := registryBase(serverURL)
+	if err != nil {
+		return err
+	}
+	servers, err := fetchRegistry(baseURL)
+	if err != nil {
+		return fmt.Errorf("listing servers: %w", err)
+	}
+	if servers == nil {
+		fmt.Println("This server has no registry")
+		return nil
+	}
+
+	for _, s := range servers {
+		latency := "unreachable"
+		if rtt, err := probeLatency(s.URL); err == nil {
+			latency = fmt.Sprintf("%.1f ms", float64(rtt.Microseconds())/1000)
+		}
+		fmt.Printf("  %-16s %-40s %s\n", s.ID, describeServer(s), latency)
+	}
+	return nil
+}
+
+// registryBase is the scheme and host of a server URL.
+func registryBase(serverURL string) (string, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid server URL: %w", err)
+	}
+	return fmt.Sprintf("%s://%s", u.Scheme, u.Host), nil
+}
+
+// describeServer is how a registry entry is shown to the user.
+func describeServer(s serverInfo) string {
+	if s.Location == "" {
+		return s.Name
+	}
+	return fmt.Sprintf("%s (%s)", s.Name, s.Location)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// testParams are the run parameters requested from the server; zero
+// values leave the server defaults in place.
+type testParams struct {
+	Duration    int
+	Streams     int
+	Stages      string
+	PayloadSize int
+	Adaptive    string
+	DualStack   bool
+	Transport   string
+}
+
+// testSession is the server's answer to POST /api/v1/speedtest/start.
+type testSession struct {
+	TestID      string   `json:"test_id"`
+	Streams     int      `json:"streams"`
+	Duration    int      `json:"duration"`
+	Stages      []string `json:"stages"`
+	PayloadSize int      `json:"payload_size"`
+	UDP         *udpPlan `json:"udp"`
+	Transport   string   `json:"transport"`
+	HTTP3Port   int      `json:"http3_port"`
+
+	// Dual-stack parents list one run per IP family
+	DualStack    bool        `json:"dual_stack"`
+	ClientFamily string      `json:"client_family"`
+	Runs         []familyRun `json:"runs"`
+}
+
+// familyRun is one run of a dual-stack test. Address, if set, is the
+// server's address in that family.
+type familyRun struct {
+	IPFamily string `json:"ip_family"`
+	TestID   string `json:"test_id"`
+	Address  string `json:"address"`
+}
+
+// runs reports whether the server scheduled the given stage.
+func (s testSession) runs(stage string) bool {
+	return slices.Contains(s.Stages, stage)
+}
+
+// startSession opens a test session on the server. Both test modes run
+// under its ID, which is also the ID the result is stored as.
+func startSession(baseURL string, enableShare bool, params testParams) (testSession, error) {
+	var session testSession
+
+	query := url.Values{}
+	if !enableShare {
+		query.Set("share", "false")
+	}
+	if params.Duration > 0 {
+		query.Set("duration", strconv.Itoa(params.Duration))
+	}
+	if params.Streams > 0 {
+		query.Set("streams", strconv.Itoa(params.Streams))
+	}
+	if params.Stages != "" {
+		query.Set("stages", params.Stages)
+	}
+	if params.PayloadSize > 0 {
+		query.Set("payload_size", strconv.Itoa(params.PayloadSize))
+	}
+	if params.Adaptive != "" {
+		query.Set("adaptive", params.Adaptive)
+	}
+	if params.DualStack {
+		query.Set("dual_stack", "true")
+	}
+	if params.Transport != "" {
+		query.Set("transport", params.Transport)
+	}
+
+	startURL := baseURL + "/api/v1/speedtest/start"
+	if len(query) > 0 {
+		startURL += "?" + query.Encode()
+	}
+	resp, err := httpClient.Post(startURL, "application/json", nil)
+	if err != nil {
+		return session, fmt.Errorf("starting test: %w", err)
+	}
+	if err := decodeResponse(resp, &session); err != nil {
+		return session, fmt.Errorf("starting test: %w", err)
+	}
+	return session, nil
+}
+
+// decodeResponse decodes a JSON API response, turning non-2xx statuses
+// into errors carrying the server's message.
+func decodeResponse(resp *http.Response, v interface{}) error {
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("server returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
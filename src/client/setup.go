@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	setupSamples = 5
+	setupTimeout = 3 * time.Second // Per connection
+)
+
+// setupStats mirrors the server's setup stage figures.
+type setupStats struct {
+	DNSMs     float64 `json:"dns_ms,omitempty"`
+	ConnectMs float64 `json:"connect_ms"`
+	TLSMs     float64 `json:"tls_ms,omitempty"`
+	TTFBMs    float64 `json:"ttfb_ms"`
+	Samples   int     `json:"samples"`
+}
+
+// setupTiming is what one fresh connection cost.
+type setupTiming struct {
+	dns, connect, tls, ttfb time.Duration
+}
+
+// measureSetup opens setupSamples fresh connections to the ping endpoint
+// and returns the median of each setup step. DNS is only timed when the
+// server URL has a hostname, TLS only over HTTPS.
+func measureSetup(baseURL, testID string) (*setupStats, error) {
+	pingURL := fmt.Sprintf("%s/api/v1/speedtest/ping?test_id=%s", baseURL, url.QueryEscape(testID))
+
+	var timings []setupTiming
+	var lastErr error
+	for i := 0; i < setupSamples; i++ {
+		timing, err := timeSetup(pingURL)
+		if err != nil {
+			lastErr = err
+		} else {
+			timings = append(timings, timing)
+		}
+		fmt.Printf("\r%s %.0f%%  %d/%d connections", makeProgressBar((i+1)*50/setupSamples), float64(i+1)/setupSamples*100, len(timings), setupSamples)
+	}
+	if len(timings) == 0 {
+		return nil, fmt.Errorf("setup: %w", lastErr)
+	}
+
+	median := func(step func(setupTiming) time.Duration) float64 {
+		values := make([]time.Duration, len(timings))
+		for i, t := range timings {
+			values[i] = step(t)
+		}
+		slices.Sort(values)
+		return float64(values[len(values)/2].Microseconds()) / 1000
+	}
+	return &setupStats{
+		DNSMs:     median(func(t setupTiming) time.Duration { return t.dns }),
+		ConnectMs: median(func(t setupTiming) time.Duration { return t.connect }),
+		TLSMs:     median(func(t setupTiming) time.Duration { return t.tls }),
+		TTFBMs:    median(func(t setupTiming) time.Duration { return t.ttfb }),
+		Samples:   len(timings),
+	}, nil
+}
+
+// timeSetup makes one request over a connection of its own. TTFB runs
+// from the request being written to the first response byte, so it leaves
+// out the setup steps timed before it.
+func timeSetup(pingURL string) (setupTiming, error) {
+	var timing setupTiming
+	var dnsStart, tlsStart, wrote time.Time
+	// Addresses of both families may be dialed at once
+	var mu sync.Mutex
+	connectStarts := make(map[string]time.Time)
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+		DNSDone:  func(httptrace.DNSDoneInfo) { timing.dns = time.Since(dnsStart) },
+		ConnectStart: func(_, addr string) {
+			mu.Lock()
+			connectStarts[addr] = time.Now()
+			mu.Unlock()
+		},
+		ConnectDone: func(_, addr string, err error) {
+			// With several addresses, the one that connected counts
+			if err == nil {
+				mu.Lock()
+				timing.connect = time.Since(connectStarts[addr])
+				mu.Unlock()
+			}
+		},
+		TLSHandshakeStart: func() { tlsStart = time.Now() },
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			timing.tls = time.Since(tlsStart)
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) { wrote = time.Now() },
+		GotFirstResponseByte: func() {
+			timing.ttfb = time.Since(wrote)
+		},
+	}
+
+	t := newTransport()
+	t.DisableKeepAlives = true
+	defer t.CloseIdleConnections()
+	client := &http.Client{Timeout: setupTimeout, Transport: t}
+
+	req, err := http.NewRequest("GET", pingURL, nil)
+	if err != nil {
+		return timing, err
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	resp, err := client.Do(req)
+	if err != nil {
+		return timing, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return timing, fmt.Errorf("ping returned %s", resp.Status)
+	}
+	if timing.connect == 0 {
+		return timing, errors.New("no new connection was made")
+	}
+	return timing, nil
+}
+
+// reportSetup sends the setup figures to a server-driven run.
+func reportSetup(baseURL, testID string, stats *setupStats) error {
+	body, _ := json.Marshal(stats)
+	resp, err := httpClient.Post(fmt.Sprintf("%s/api/v1/speedtest/setup?test_id=%s", baseURL, url.QueryEscape(testID)), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("server returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// printSetup shows the setup stage's figures.
+func printSetup(stats *setupStats) {
+	if stats.DNSMs > 0 {
+		fmt.Printf("   DNS lookup:    %.1f ms\n", stats.DNSMs)
+	}
+	fmt.Printf("   TCP connect:   %.1f ms\n", stats.ConnectMs)
+	if stats.TLSMs > 0 {
+		fmt.Printf("   TLS handshake: %.1f ms\n", stats.TLSMs)
+	}
+	fmt.Printf("   First byte:    %.1f ms (median of %d)\n", stats.TTFBMs, stats.Samples)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+)
+
+// startStreams opens parallel transfers against the server's data
+// endpoints for the given stage and returns a function that stops them.
+// If counter is non-nil it accumulates the bytes the client moved.
+func startStreams(baseURL, stage string, session testSession, streams int, counter *atomic.Int64) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+
+	// Random so that compressing middleboxes cannot inflate the result
+	var payload []byte
+	if stage == "upload" {
+		payload = make([]byte, session.PayloadSize)
+		rand.Read(payload)
+	}
+
+	for i := 0; i < streams; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				if stage == "download" {
+					downloadOnce(ctx, baseURL, session.TestID, counter)
+				} else {
+					uploadOnce(ctx, baseURL, session.TestID, payload, counter)
+				}
+			}
+		}()
+	}
+
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+// countingWriter discards data while adding its length to a counter.
+type countingWriter struct {
+	counter *atomic.Int64
+}
+
+func (c countingWriter) Write(p []byte) (int, error) {
+	if c.counter != nil {
+		c.counter.Add(int64(len(p)))
+	}
+	return len(p), nil
+}
+
+func downloadOnce(ctx context.Context, baseURL, testID string, counter *atomic.Int64) {
+	reqURL := fmt.Sprintf("%s/api/v1/speedtest/download?test_id=%s", baseURL, url.QueryEscape(testID))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return
+	}
+	resp, err := dataClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	io.Copy(countingWriter{counter}, resp.Body)
+}
+
+// uploadOnce posts one payload. Only uploads the server acknowledged are
+// counted, so bytes still sitting in socket buffers when a stage ends are
+// never claimed.
+func uploadOnce(ctx context.Context, baseURL, testID string, payload []byte, counter *atomic.Int64) {
+	reqURL := fmt.Sprintf("%s/api/v1/speedtest/upload?test_id=%s", baseURL, url.QueryEscape(testID))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, reqURL, bytes.NewReader(payload))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := dataClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode == http.StatusOK && counter != nil {
+		counter.Add(int64(len(payload)))
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// dataClient carries a test's pings and transfers, over the transport the
+// test is pinned to. API calls stay on httpClient.
+var dataClient = httpClient
+
+// useTransport points dataClient at the session's transport: "http1",
+// "h2" or "h3", or "" for whatever the server negotiates.
+func useTransport(session testSession) error {
+	if dataClient != httpClient {
+		dataClient.CloseIdleConnections()
+	}
+
+	var protocols http.Protocols
+	switch session.Transport {
+	case "":
+		dataClient = httpClient
+		return nil
+	case "http1":
+		protocols.SetHTTP1(true)
+	case "h2":
+		protocols.SetHTTP2(true)
+	case "h3":
+		if session.HTTP3Port == 0 {
+			return errors.New("server did not advertise an HTTP/3 port")
+		}
+		dataClient = &http.Client{Transport: &http3.Transport{Dial: dialQUIC(session.HTTP3Port)}}
+		return nil
+	default:
+		return errors.New("unknown transport " + session.Transport)
+	}
+
+	// A fresh TLS config, so ALPN offers only the chosen protocol
+	t := newTransport()
+	t.TLSClientConfig = nil
+	t.Protocols = &protocols
+	dataClient = &http.Client{Transport: t}
+	return nil
+}
+
+// startTransport switches to the session's transport and says which one
+// the run uses.
+func startTransport(session testSession) error {
+	if err := useTransport(session); err != nil {
+		return err
+	}
+	if session.Transport != "" {
+		fmt.Printf("🚚 Transport: %s\n", transportName(session.Transport))
+	}
+	return nil
+}
+
+// dialQUIC connects to the server's HTTP/3 port, over the pinned family
+// like dialContext.
+func dialQUIC(port int) func(context.Context, string, *tls.Config, *quic.Config) (*quic.Conn, error) {
+	return func(ctx context.Context, addr string, tlsConfig *tls.Config, quicConfig *quic.Config) (*quic.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		network := familyNetwork("udp")
+		udpAddr, err := net.ResolveUDPAddr(network, net.JoinHostPort(host, strconv.Itoa(port)))
+		if err != nil && pinnedAddress != "" {
+			udpAddr, err = net.ResolveUDPAddr(network, net.JoinHostPort(pinnedAddress, strconv.Itoa(port)))
+		}
+		if err != nil {
+			return nil, err
+		}
+		return quic.DialAddrEarly(ctx, udpAddr.String(), tlsConfig, quicConfig)
+	}
+}
+
+// transportName is how a transport is shown to the user.
+func transportName(transport string) string {
+	switch transport {
+	case "http1":
+		return "HTTP/1.1"
+	case "h2":
+		return "HTTP/2"
+	case "h3":
+		return "HTTP/3"
+	}
+	return "any"
+}
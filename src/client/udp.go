@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// udpPlan is the server's instruction for the UDP stage.
+type udpPlan struct {
+	Port       int `json:"port"`
+	Rate       int `json:"rate"`
+	PacketSize int `json:"packet_size"`
+	Count      int `json:"count"`
+}
+
+// udpEcho is what the client saw of its datagrams coming back: loss and
+// jitter over the round trip, where the server's stored figures cover the
+// way out only.
+type udpEcho struct {
+	Sent     int
+	Echoed   int
+	LossPct  float64
+	RTTMs    float64
+	JitterMs float64
+}
+
+// runUDP sends the planned datagrams to host and collects the echoes,
+// waiting a second after the last one for stragglers. progress, if set,
+// is called after every datagram sent.
+func runUDP(host, testID string, plan udpPlan, progress func(sent int, echo udpEcho)) (udpEcho, error) {
+	id, err := uuid.Parse(testID)
+	if err != nil {
+		return udpEcho{}, fmt.Errorf("invalid test ID: %w", err)
+	}
+	conn, err := dialContext(context.Background(), "udp", net.JoinHostPort(host, strconv.Itoa(plan.Port)))
+	if err != nil {
+		return udpEcho{}, fmt.Errorf("udp: %w", err)
+	}
+	defer conn.Close()
+
+	var mu sync.Mutex
+	var echo udpEcho
+	seen := make([]bool, plan.Count)
+	var rttSum, lastRTT float64
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		buf := make([]byte, plan.PacketSize)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			now := time.Now().UnixNano()
+			if n < 32 {
+				continue
+			}
+			seq := binary.BigEndian.Uint32(buf[20:24])
+			if int(seq) >= plan.Count || seen[seq] {
+				continue
+			}
+			rtt := float64(now-int64(binary.BigEndian.Uint64(buf[24:32]))) / 1e6
+
+			mu.Lock()
+			seen[seq] = true
+			if echo.Echoed > 0 {
+				echo.JitterMs += (math.Abs(rtt-lastRTT) - echo.JitterMs) / 16
+			}
+			echo.Echoed++
+			rttSum += rtt
+			lastRTT = rtt
+			mu.Unlock()
+		}
+	}()
+
+	snapshot := func() udpEcho {
+		mu.Lock()
+		defer mu.Unlock()
+		e := echo
+		if e.Sent > 0 {
+			e.LossPct = float64(e.Sent-e.Echoed) / float64(e.Sent) * 100
+		}
+		if e.Echoed > 0 {
+			e.RTTMs = rttSum / float64(e.Echoed)
+		}
+		return e
+	}
+
+	packet := make([]byte, plan.PacketSize)
+	copy(packet, "CSUP")
+	copy(packet[4:20], id[:])
+	interval := time.Second / time.Duration(plan.Rate)
+	start := time.Now()
+	for i := 0; i < plan.Count; i++ {
+		// Pace against the start time so scheduling delays don't add up
+		time.Sleep(time.Until(start.Add(time.Duration(i) * interval)))
+		binary.BigEndian.PutUint32(packet[20:24], uint32(i))
+		binary.BigEndian.PutUint64(packet[24:32], uint64(time.Now().UnixNano()))
+		conn.Write(packet)
+
+		mu.Lock()
+		echo.Sent++
+		mu.Unlock()
+		if progress != nil {
+			progress(i+1, snapshot())
+		}
+	}
+
+	time.Sleep(time.Second)
+	conn.Close()
+	<-done
+	return snapshot(), nil
+}
+
+// printUDP shows the UDP stage outcome: the server's view of the way out
+// (lossPct, pass a negative value if unknown) and the round trip.
+func printUDP(upLossPct float64, echo udpEcho) {
+	if upLossPct >= 0 {
+		fmt.Printf("   UDP loss: %.1f%% upstream, %.1f%% round trip  RTT %.1f ms  jitter %.1f ms\n", upLossPct, echo.LossPct, echo.RTTMs, echo.JitterMs)
+		return
+	}
+	fmt.Printf("   UDP loss: %.1f%% round trip  RTT %.1f ms  jitter %.1f ms\n", echo.LossPct, echo.RTTMs, echo.JitterMs)
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -34,6 +35,7 @@ type ServerConfig struct {
 	Scheduler Scheduler   `yaml:"scheduler"`
 	RateLimit RateLimit   `yaml:"rate_limit"`
 	Database  Database    `yaml:"database"`
+	Registry  Registry    `yaml:"registry"`
 }
 
 // Branding contains branding information
@@ -59,6 +61,8 @@ type SSLConfig struct {
 	Cert       string            `yaml:"cert"`
 	Key        string            `yaml:"key"`
 	MinVersion string            `yaml:"min_version"`
+	HTTP3      bool              `yaml:"http3"`      // Also serve HTTP/3 over QUIC
+	HTTP3Port  int               `yaml:"http3_port"` // UDP port for HTTP/3 (0=same as HTTPS port)
 	LetsEncrypt LetsEncryptConfig `yaml:"letsencrypt"`
 }
 
@@ -97,7 +101,7 @@ type RateLimit struct {
 
 // Database contains database configuration
 type Database struct {
-	Driver   string `yaml:"driver"`   // file, sqlite, postgres, mysql, etc.
+	Driver   string `yaml:"driver"`   // file (SQLite) or postgres
 	Host     string `yaml:"host"`
 	Port     int    `yaml:"port"`
 	Name     string `yaml:"name"`
@@ -106,6 +110,24 @@ type Database struct {
 	SSLMode  string `yaml:"sslmode"`
 }
 
+// Registry describes this server and the peers offered alongside it, for
+// deployments that run casspeed in several places
+type Registry struct {
+	ID       string `yaml:"id"`       // Recorded on every result this server stores
+	Name     string `yaml:"name"`
+	Location string `yaml:"location"`
+	URL      string `yaml:"url"`      // Public URL (empty: the URL clients reached it at)
+	Peers    []Peer `yaml:"peers"`
+}
+
+// Peer is another casspeed server clients may test against
+type Peer struct {
+	ID       string `yaml:"id"`
+	Name     string `yaml:"name"`
+	Location string `yaml:"location"`
+	URL      string `yaml:"url"`
+}
+
 // WebConfig contains web UI settings
 type WebConfig struct {
 	UI   UIConfig `yaml:"ui"`
@@ -119,13 +141,31 @@ type UIConfig struct {
 
 // TestConfig contains speedtest-specific settings
 type TestConfig struct {
-	MaxConcurrent    int    `yaml:"max_concurrent"`     // Max concurrent tests per IP
-	MinInterval      int    `yaml:"min_interval"`       // Minimum seconds between tests
-	DefaultDuration  int    `yaml:"default_duration"`   // Default test duration in seconds
-	MaxThreads       int    `yaml:"max_threads"`        // Max threads for multi-threaded tests
-	ResultsRetention int    `yaml:"results_retention"`  // Days to keep test results (0=unlimited)
-	ChunkSize        int    `yaml:"chunk_size"`         // Data chunk size in bytes
-	Timeout          int    `yaml:"timeout"`            // Test timeout in seconds
+	MaxConcurrent       int     `yaml:"max_concurrent"`        // Max concurrent tests per IP
+	MinInterval         int     `yaml:"min_interval"`          // Minimum seconds between tests
+	DefaultDuration     int     `yaml:"default_duration"`      // Default test duration in seconds
+	MaxThreads          int     `yaml:"max_threads"`           // Max threads for multi-threaded tests
+	ResultsRetention    int     `yaml:"results_retention"`     // Days to keep test results (0=unlimited)
+	RetentionKeepShared bool    `yaml:"retention_keep_shared"` // Keep results with a share code past retention
+	RetentionRollup     bool    `yaml:"retention_rollup"`      // Sum up pruned results into daily aggregates first
+	ChunkSize           int     `yaml:"chunk_size"`            // Bytes per write when serving download data
+	Timeout             int     `yaml:"timeout"`               // Test timeout in seconds
+	LoadedLatency       bool    `yaml:"loaded_latency"`        // Probe latency during download/upload (bufferbloat)
+	Adaptive            bool    `yaml:"adaptive"`              // End stages once throughput stabilizes
+	StableTolerance     float64 `yaml:"stable_tolerance"`      // Percent change still counted as stable
+	StableIntervals     int     `yaml:"stable_intervals"`      // Consecutive stable intervals to end a stage
+	WarmupMs            int     `yaml:"warmup_ms"`             // Start of each stage left out of the result
+	SaveAborted         bool    `yaml:"save_aborted"`          // Store cancelled tests, marked aborted
+	UDP                 bool    `yaml:"udp"`                   // Run the UDP responder for the udp stage
+	UDPPort             int     `yaml:"udp_port"`              // UDP responder port (0=same as HTTP port)
+	UDPRate             int     `yaml:"udp_rate"`              // Datagrams per second the client sends
+	UDPPacketSize       int     `yaml:"udp_packet_size"`       // Bytes per datagram
+	Payload             string  `yaml:"payload"`               // Download data: random, crypto, zero or pattern
+	PayloadPool         int     `yaml:"payload_pool"`          // Bytes of pre-generated random data
+	StreamLimit         int64   `yaml:"stream_limit"`          // Most bytes one streaming download sends (0=unlimited)
+	Iperf3              bool    `yaml:"iperf3"`                // Accept stock iperf3 clients
+	Iperf3Port          int     `yaml:"iperf3_port"`           // TCP port for iperf3 clients
+	UnverifiedStats     bool    `yaml:"unverified_stats"`      // Count client-reported (LibreSpeed) results in statistics
 }
 
 // Default returns a config with sane defaults
@@ -180,6 +220,8 @@ func Default() *Config {
 				Cert:       "",
 				Key:        "",
 				MinVersion: "TLS1.2",
+				HTTP3:      false,
+				HTTP3Port:  0,
 				LetsEncrypt: LetsEncryptConfig{
 					Enabled:   false,
 					Email:     fmt.Sprintf("admin@%s", hostname),
@@ -214,6 +256,10 @@ func Default() *Config {
 						Enabled:  true,
 						Schedule: "*/5 * * * *",
 					},
+					"results_retention": {
+						Enabled:  true,
+						Schedule: "0 4 * * *",
+					},
 				},
 			},
 			RateLimit: RateLimit{
@@ -224,6 +270,11 @@ func Default() *Config {
 			Database: Database{
 				Driver: "file",
 			},
+			Registry: Registry{
+				ID:    hostname,
+				Name:  hostname,
+				Peers: []Peer{},
+			},
 		},
 		Web: WebConfig{
 			UI: UIConfig{
@@ -232,13 +283,31 @@ func Default() *Config {
 			CORS: "*",
 		},
 		Test: TestConfig{
-			MaxConcurrent:    3,
-			MinInterval:      5,
-			DefaultDuration:  10,
-			MaxThreads:       maxThreads,
-			ResultsRetention: 90,
-			ChunkSize:        chunkSize,
-			Timeout:          60,
+			MaxConcurrent:       3,
+			MinInterval:         5,
+			DefaultDuration:     10,
+			MaxThreads:          maxThreads,
+			ResultsRetention:    90,
+			RetentionKeepShared: false,
+			RetentionRollup:     true,
+			ChunkSize:           chunkSize,
+			Timeout:             60,
+			LoadedLatency:       true,
+			Adaptive:            false,
+			StableTolerance:     5,
+			StableIntervals:     4,
+			WarmupMs:            1000,
+			SaveAborted:         true,
+			UDP:                 false,
+			UDPPort:             0,
+			UDPRate:             50,
+			UDPPacketSize:       200,
+			Payload:             "random",
+			PayloadPool:         8388608,
+			StreamLimit:         0,
+			Iperf3:              false,
+			Iperf3Port:          5201,
+			UnverifiedStats:     false,
 		},
 	}
 }
@@ -325,10 +394,84 @@ func (c *Config) Validate() error {
 	if c.Test.Timeout < 10 {
 		return fmt.Errorf("test.timeout must be >= 10 seconds")
 	}
+	if c.Test.StableTolerance <= 0 || c.Test.StableTolerance >= 100 {
+		return fmt.Errorf("test.stable_tolerance must be between 0 and 100 percent")
+	}
+	if c.Test.StableIntervals < 1 {
+		return fmt.Errorf("test.stable_intervals must be >= 1")
+	}
+	if c.Test.WarmupMs < 0 {
+		return fmt.Errorf("test.warmup_ms must be >= 0")
+	}
+	if c.Test.UDPPort < 0 || c.Test.UDPPort > 65535 {
+		return fmt.Errorf("test.udp_port must be between 0 and 65535")
+	}
+	if c.Server.SSL.HTTP3Port < 0 || c.Server.SSL.HTTP3Port > 65535 {
+		return fmt.Errorf("ssl.http3_port must be between 0 and 65535")
+	}
+	if c.Server.SSL.Enabled && c.Server.SSL.HTTP3 && c.Test.UDP && c.Server.SSL.HTTP3Port == c.Test.UDPPort {
+		return fmt.Errorf("ssl.http3 and test.udp need different UDP ports: set ssl.http3_port or test.udp_port")
+	}
+	if c.Test.UDPRate < 1 || c.Test.UDPRate > 1000 {
+		return fmt.Errorf("test.udp_rate must be between 1 and 1000")
+	}
+	if c.Test.UDPPacketSize < 32 || c.Test.UDPPacketSize > 1400 {
+		return fmt.Errorf("test.udp_packet_size must be between 32 and 1400 bytes")
+	}
+	switch c.Server.Database.Driver {
+	case "", "file", "sqlite":
+	case "postgres":
+		if c.Server.Database.Host == "" || c.Server.Database.Name == "" {
+			return fmt.Errorf("database.host and database.name are required for postgres")
+		}
+	default:
+		return fmt.Errorf("database.driver must be file, sqlite or postgres")
+	}
+	switch c.Test.Payload {
+	case "random", "crypto", "zero", "pattern":
+	default:
+		return fmt.Errorf("test.payload must be random, crypto, zero or pattern")
+	}
+	if c.Test.PayloadPool < 1048576 || c.Test.PayloadPool > 268435456 {
+		return fmt.Errorf("test.payload_pool must be between 1MB (1048576) and 256MB (268435456)")
+	}
+	if c.Test.StreamLimit < 0 {
+		return fmt.Errorf("test.stream_limit must be >= 0")
+	}
+	if c.Test.Iperf3Port < 1 || c.Test.Iperf3Port > 65535 {
+		return fmt.Errorf("test.iperf3_port must be between 1 and 65535")
+	}
+
+	// Validate registry
+	if c.Server.Registry.ID == "" {
+		return fmt.Errorf("registry.id must not be empty")
+	}
+	if c.Server.Registry.URL != "" && !isHTTPURL(c.Server.Registry.URL) {
+		return fmt.Errorf("registry.url must be an absolute http or https URL")
+	}
+	ids := map[string]bool{c.Server.Registry.ID: true}
+	for i, peer := range c.Server.Registry.Peers {
+		if peer.ID == "" {
+			return fmt.Errorf("registry.peers[%d].id must not be empty", i)
+		}
+		if ids[peer.ID] {
+			return fmt.Errorf("registry.peers[%d].id %q is already in use", i, peer.ID)
+		}
+		ids[peer.ID] = true
+		if !isHTTPURL(peer.URL) {
+			return fmt.Errorf("registry.peers[%d].url must be an absolute http or https URL", i)
+		}
+	}
 
 	return nil
 }
 
+// isHTTPURL reports whether s is an absolute http or https URL
+func isHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
 // ParseDuration parses duration strings like "30d", "2h", "15m"
 func ParseDuration(s string) (time.Duration, error) {
 	if len(s) < 2 {
@@ -1,7 +1,30 @@
 package graphql
 
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/casapps/casspeed/src/server/model"
+	"github.com/casapps/casspeed/src/server/service"
+	"github.com/casapps/casspeed/src/server/store"
+)
+
 // Resolvers contains the GraphQL resolvers
-type Resolvers struct{}
+type Resolvers struct {
+	Store store.Store
+	// Unverified counts client-reported results in stats, as
+	// test.unverified_stats does for /api/v1/stats
+	Unverified bool
+}
+
+// StatsArgs are the arguments of the stats query
+type StatsArgs struct {
+	Bucket   string
+	From     string
+	To       string
+	ServerID string
+}
 
 // Query resolvers
 func (r *Resolvers) Health() (string, error) {
@@ -18,6 +41,38 @@ func (r *Resolvers) SpeedTest(id string) (interface{}, error) {
 	return nil, nil
 }
 
+// Stats returns the same buckets as /api/v1/stats
+func (r *Resolvers) Stats(ctx context.Context, args StatsArgs) ([]*model.StatsBucket, error) {
+	if args.Bucket == "" {
+		args.Bucket = service.StatsDay
+	}
+	if !service.ValidStatsBucket(args.Bucket) {
+		return nil, errors.New("invalid bucket: use hour, day or week")
+	}
+	from, to, err := service.StatsRange(args.From, args.To, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	filter := store.StatsFilter{ServerID: args.ServerID, From: from, To: to, Unverified: r.Unverified}
+	splits, err := service.StatsSplits(from, to, args.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := r.Store.GetSpeedTestTotals(ctx, filter, splits)
+	if err != nil {
+		return nil, err
+	}
+	var rolled []*model.SpeedTestTotals
+	if args.Bucket != service.StatsHour {
+		rolled, err = r.Store.GetSpeedTestDayTotals(ctx, filter, splits)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return service.StatsBuckets(stored, rolled, args.Bucket), nil
+}
+
 // Mutation resolvers
 func (r *Resolvers) StartSpeedTest() (map[string]string, error) {
 	// Placeholder - would start actual test
@@ -6,22 +6,113 @@ type Query {
 	health: String!
 	speedTests: [SpeedTest!]!
 	speedTest(id: ID!): SpeedTest
+	stats(bucket: String, from: String, to: String, serverId: ID): [StatsBucket!]!
 }
 
 type SpeedTest {
 	id: ID!
+	status: String!
+	parentId: ID
+	ipFamily: String
 	timestamp: String!
 	downloadMbps: Float!
 	uploadMbps: Float!
 	pingMs: Float!
 	jitterMs: Float!
 	packetLoss: Float!
+	pingDownloadMs: Float!
+	pingUploadMs: Float!
+	duration: Int!
+	streams: Int!
+	stages: String!
+	payloadSize: Int!
+	adaptive: Boolean!
+	transport: String
+	downloadStopReason: String
+	uploadStopReason: String
+	downloadStats: ThroughputStats
+	uploadStats: ThroughputStats
+	downloadTcp: TCPStats
+	uploadTcp: TCPStats
+	udp: UDPStats
+	setup: SetupStats
 	userAgent: String!
+	serverId: String
 	shareCode: String
 	shareViews: Int!
 	createdAt: String!
 }
 
+type ThroughputStats {
+	p10Mbps: Float!
+	p50Mbps: Float!
+	p90Mbps: Float!
+	maxMbps: Float!
+	intervalMs: Int!
+	warmupIntervals: Int!
+	seriesMbps: [Float!]!
+}
+
+type TCPStats {
+	limit: String!
+	retransmits: Int!
+	retransmitPct: Float!
+	outOfOrder: Int!
+	rttMs: Float!
+	deliveryRateMbps: Float!
+	rwndLimitedPct: Float!
+	streams: [TCPStreamStats!]!
+}
+
+type TCPStreamStats {
+	retransmits: Int!
+	retransmitPct: Float!
+	outOfOrder: Int!
+	rttMs: Float!
+	minRttMs: Float!
+	cwnd: Int!
+	deliveryRateMbps: Float!
+	rwndLimitedPct: Float!
+	sndbufLimitedPct: Float!
+	samples: Int!
+}
+
+type UDPStats {
+	sent: Int!
+	received: Int!
+	lossPct: Float!
+	reordered: Int!
+	duplicates: Int!
+	jitterMs: Float!
+	rate: Int!
+	packetSize: Int!
+}
+
+type SetupStats {
+	dnsMs: Float
+	connectMs: Float!
+	tlsMs: Float
+	ttfbMs: Float!
+	samples: Int!
+}
+
+type StatsBucket {
+	start: String!
+	tests: Int!
+	rolledUp: Int!
+	downloadMbps: MetricStats!
+	uploadMbps: MetricStats!
+	pingMs: MetricStats!
+	jitterMs: MetricStats!
+}
+
+type MetricStats {
+	min: Float!
+	avg: Float!
+	median: Float
+	p95: Float
+}
+
 type Mutation {
 	startSpeedTest: SpeedTestStart!
 }
@@ -1,16 +1,21 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"time"
 
 	"github.com/casapps/casspeed/src/config"
 	"github.com/casapps/casspeed/src/mode"
 	"github.com/casapps/casspeed/src/paths"
 	"github.com/casapps/casspeed/src/server"
+	"github.com/casapps/casspeed/src/server/store"
+	"github.com/casapps/casspeed/src/ssl"
 )
 
 // Version information (set by linker flags during build)
@@ -58,7 +63,7 @@ func main() {
 	flag.StringVar(&address, "address", "", "Listen address")
 	flag.StringVar(&portFlag, "port", "", "Listen port")
 	flag.StringVar(&serviceCmd, "service", "", "Service management (start|stop|restart|reload|install|uninstall|help)")
-	flag.StringVar(&maintCmd, "maintenance", "", "Maintenance operations (backup|restore|update|mode|setup)")
+	flag.StringVar(&maintCmd, "maintenance", "", "Maintenance operations (backup|restore|update|mode|setup|migrate|prune)")
 	flag.StringVar(&updateCmd, "update", "", "Update operations (check|yes|branch stable|beta|daily)")
 
 	flag.Usage = func() {
@@ -93,7 +98,7 @@ func main() {
 
 	// Handle --maintenance
 	if maintCmd != "" {
-		handleMaintenance(binaryName, maintCmd, flag.Args())
+		handleMaintenance(binaryName, maintCmd, flag.Args(), configDir, dataDir)
 		os.Exit(0)
 	}
 
@@ -167,6 +172,16 @@ func main() {
 		}
 	}
 
+	// Without an explicit certificate, use the first one found for the FQDN
+	if cfg.Server.SSL.Enabled && (cfg.Server.SSL.Cert == "" || cfg.Server.SSL.Key == "") {
+		cert, err := ssl.NewManager(appPaths.Config, cfg.Server.FQDN).FindCertificate()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "SSL error: %v\n", err)
+			os.Exit(1)
+		}
+		cfg.Server.SSL.Cert, cfg.Server.SSL.Key = cert.CertPath, cert.KeyPath
+	}
+
 	// Print startup banner
 	printBanner(appMode, cfg)
 
@@ -220,7 +235,7 @@ OPTIONS:
   --address ADDR          Listen address (default: [::])
   --port PORT             Listen port (default: random 64xxx)
   --service CMD           Service management (start|stop|restart|reload|install|uninstall|help)
-  --maintenance CMD       Maintenance operations (backup|restore|update|mode|setup)
+  --maintenance CMD       Maintenance operations (backup|restore|update|mode|setup|migrate|prune)
   --update CMD            Update operations (check|yes|branch stable|beta|daily)
 
 EXAMPLES:
@@ -245,8 +260,14 @@ EXAMPLES:
   %s --maintenance backup
     Backup database
 
+  %s --config /etc/casspeed --data /var/lib/casspeed --maintenance migrate status
+    Show database schema migrations
+
+  %s --maintenance prune 30
+    Delete results older than 30 days (default: test.results_retention)
+
 For more information, visit: https://github.com/casapps/casspeed
-`, binaryName, binaryName, binaryName, binaryName, binaryName, binaryName, binaryName, binaryName)
+`, binaryName, binaryName, binaryName, binaryName, binaryName, binaryName, binaryName, binaryName, binaryName, binaryName)
 }
 
 func showVersionInfo(binaryName string) {
@@ -362,7 +383,7 @@ Manual service management is for advanced users only.
 	}
 }
 
-func handleMaintenance(binaryName string, cmd string, args []string) {
+func handleMaintenance(binaryName string, cmd string, args []string, configDir, dataDir string) {
 	fmt.Printf("%s: Maintenance Operations\n", binaryName)
 	fmt.Println("─────────────────────────────────────")
 	
@@ -394,7 +415,7 @@ func handleMaintenance(binaryName string, cmd string, args []string) {
 			mode := args[0]
 			if mode == "production" || mode == "development" {
 				fmt.Printf("Set mode in config: server.mode: %s\n", mode)
-				fmt.Println("Or use environment: MODE=%s\n", mode)
+				fmt.Printf("Or use environment: MODE=%s\n", mode)
 				fmt.Printf("Or use CLI flag: %s --mode %s\n", binaryName, mode)
 			} else {
 				fmt.Println("Invalid mode. Use: production or development")
@@ -410,13 +431,156 @@ func handleMaintenance(binaryName string, cmd string, args []string) {
 		fmt.Println("2. Edit server.yml for custom configuration")
 		fmt.Println("3. Database auto-created on first run")
 		fmt.Println("4. No additional setup required")
+	case "migrate":
+		if len(args) == 0 {
+			fmt.Println("Usage: --maintenance migrate <status|up|down>")
+			os.Exit(1)
+		}
+		if err := handleMigrate(args[0], configDir, dataDir); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	case "prune":
+		if err := handlePrune(args, configDir, dataDir); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
 	default:
 		fmt.Printf("Unknown maintenance command: %s\n", cmd)
-		fmt.Printf("Available: backup, restore, update, mode, setup\n")
+		fmt.Printf("Available: backup, restore, update, mode, setup, migrate, prune\n")
 		os.Exit(1)
 	}
 }
 
+// openMaintenanceStore loads the server configuration and opens its
+// existing database, without migrating it.
+func openMaintenanceStore(configDir, dataDir string) (*config.Config, store.VersionedStore, error) {
+	appPaths, err := paths.Detect(configDir, dataDir, "")
+	if err != nil {
+		return nil, nil, fmt.Errorf("detecting paths: %w", err)
+	}
+	cfg, err := config.Load(filepath.Join(appPaths.Config, "server.yml"))
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	db := cfg.Server.Database
+	if db.Driver == "postgres" {
+		fmt.Printf("Database: postgres %s/%s\n", db.Host, db.Name)
+	} else {
+		dbPath := filepath.Join(appPaths.DB, "speedtest.db")
+		if _, err := os.Stat(dbPath); err != nil {
+			return nil, nil, fmt.Errorf("database not found: %w", err)
+		}
+		fmt.Printf("Database: %s\n", dbPath)
+	}
+
+	dbStore, err := store.Open(db, appPaths.Data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cfg, dbStore, nil
+}
+
+// handleMigrate shows, applies or reverts the database's schema
+// migrations. The server applies pending ones itself at startup, so a
+// reverted migration only stays reverted while it is stopped.
+func handleMigrate(cmd string, configDir, dataDir string) error {
+	_, dbStore, err := openMaintenanceStore(configDir, dataDir)
+	if err != nil {
+		return err
+	}
+	defer dbStore.Close()
+
+	ctx := context.Background()
+	switch cmd {
+	case "status":
+		migrations, err := dbStore.Migrations(ctx)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			state := "pending"
+			if m.Applied {
+				state = "applied " + m.AppliedAt.Local().Format("2006-01-02 15:04:05")
+			}
+			if m.Unknown {
+				state += " (unknown to this version)"
+			}
+			fmt.Printf("  %3d  %-30s %s\n", m.Version, m.Name, state)
+		}
+	case "up":
+		applied, err := dbStore.MigrateUp(ctx)
+		for _, m := range applied {
+			fmt.Printf("Applied migration %d: %s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Println("Database schema is up to date")
+		}
+	case "down":
+		m, err := dbStore.MigrateDown(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Reverted migration %d: %s\n", m.Version, m.Name)
+		fmt.Println("Note: the server reapplies it on its next start")
+	default:
+		return fmt.Errorf("unknown migrate command: %s (use: status, up, down)", cmd)
+	}
+	return nil
+}
+
+// handlePrune deletes the results past test.results_retention, or past
+// days when given, as the server's results_retention task does.
+func handlePrune(args []string, configDir, dataDir string) error {
+	cfg, dbStore, err := openMaintenanceStore(configDir, dataDir)
+	if err != nil {
+		return err
+	}
+	defer dbStore.Close()
+
+	if len(args) > 0 {
+		days, err := strconv.Atoi(args[0])
+		if err != nil || days < 1 {
+			return fmt.Errorf("invalid number of days: %s", args[0])
+		}
+		cfg.Test.ResultsRetention = days
+	}
+	opts, ok := store.RetentionOptions(cfg.Test, time.Now())
+	if !ok {
+		fmt.Println("Results are kept forever (test.results_retention: 0)")
+		return nil
+	}
+
+	ctx := context.Background()
+	applied, err := dbStore.MigrateUp(ctx)
+	for _, m := range applied {
+		fmt.Printf("Applied migration %d: %s\n", m.Version, m.Name)
+	}
+	if err != nil {
+		return err
+	}
+
+	n, err := dbStore.PruneSpeedTests(ctx, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Pruned %d results older than %s (%d days)\n", n, opts.Before.Format("2006-01-02 15:04"), cfg.Test.ResultsRetention)
+	if opts.KeepShared {
+		fmt.Println("Results with share codes were kept")
+	}
+	if opts.Rollup {
+		fmt.Println("Pruned results were added to the daily aggregates")
+	}
+	return nil
+}
+
 func handleUpdate(binaryName string, cmd string, args []string) {
 	fmt.Printf("%s: Update System\n", binaryName)
 	fmt.Println("─────────────────────────────────────")
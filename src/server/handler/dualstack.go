@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/casapps/casspeed/src/server/model"
+	"github.com/casapps/casspeed/src/server/service"
+	"github.com/casapps/casspeed/src/ssl"
+)
+
+// startDualStack opens a parent test with one run per IP family. The
+// client runs each over its family, attaching to the run's test_id as for
+// a single test; the results are stored under their own IDs and linked to
+// the parent, which GetResult reports as a whole.
+func (h *SpeedTestHandler) startDualStack(w http.ResponseWriter, r *http.Request, opts service.TestOptions) {
+	share := r.URL.Query().Get("share") != "false"
+	parentID, sessions := h.service.NewDualStackSessions(opts, share)
+	clientFamily := service.IPFamily(r.RemoteAddr)
+
+	endAll := func() {
+		for _, session := range sessions {
+			h.service.EndSession(session.ID)
+		}
+	}
+
+	err := h.store.CreateTestSession(r.Context(), &model.TestSession{
+		ID:            parentID,
+		Status:        model.TestStatusStarted,
+		IPFamily:      clientFamily,
+		DualStack:     true,
+		Streams:       opts.Streams,
+		Duration:      opts.Duration,
+		Stages:        opts.StageList(),
+		PayloadSize:   opts.PayloadSize,
+		LoadedLatency: opts.LoadedLatency,
+		Adaptive:      opts.Adaptive,
+		Transport:     opts.Transport,
+		Share:         share,
+		ClientIPHash:  service.HashIP(r.RemoteAddr),
+		CreatedAt:     sessions[0].CreatedAt,
+		ExpiresAt:     sessions[len(sessions)-1].ExpiresAt,
+	})
+	if err != nil {
+		endAll()
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	// Clients that can't pick a family for the server's hostname can
+	// connect to these addresses instead
+	ipv4, ipv6 := ssl.GetGlobalIPs()
+	addresses := map[string]string{service.FamilyIPv4: ipv4, service.FamilyIPv6: ipv6}
+
+	runs := make([]map[string]interface{}, 0, len(sessions))
+	for _, session := range sessions {
+		if err := h.store.CreateTestSession(r.Context(), newTestSessionRecord(session, r)); err != nil {
+			endAll()
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		run := map[string]interface{}{
+			"ip_family":  session.IPFamily,
+			"test_id":    session.ID,
+			"expires_at": session.ExpiresAt.UTC().Format(time.RFC3339),
+		}
+		if addr := addresses[session.IPFamily]; addr != "" {
+			run["address"] = addr
+		}
+		runs = append(runs, run)
+	}
+
+	response := map[string]interface{}{
+		"test_id":        parentID,
+		"status":         model.TestStatusStarted,
+		"dual_stack":     true,
+		"client_family":  clientFamily,
+		"runs":           runs,
+		"streams":        opts.Streams,
+		"duration":       opts.Duration,
+		"stages":         opts.Stages,
+		"payload_size":   opts.PayloadSize,
+		"loaded_latency": opts.LoadedLatency,
+		"adaptive":       opts.Adaptive,
+		"transport":      opts.Transport,
+	}
+	if plan := h.service.UDPPlan(sessions[0]); plan != nil {
+		response["udp"] = plan
+	}
+	if port := h.service.TransportPort(service.TransportH3); port != 0 {
+		response["http3_port"] = port
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	data, _ := json.MarshalIndent(response, "", "  ")
+	w.Write(data)
+	w.Write([]byte("\n"))
+}
+
+// dualStackStatus reports a dual-stack parent with its runs and whatever
+// results have been stored so far: 200 once every run has a result, 202
+// before that.
+func (h *SpeedTestHandler) dualStackStatus(w http.ResponseWriter, r *http.Request, parent *model.TestSession) {
+	runs, err := h.store.GetChildTestSessions(r.Context(), parent.ID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	test := &model.DualStackTest{
+		ID:           parent.ID,
+		Status:       model.TestStatusComplete,
+		DualStack:    true,
+		ClientFamily: parent.IPFamily,
+		Runs:         runs,
+		Results:      []*model.SpeedTest{},
+		CreatedAt:    parent.CreatedAt,
+	}
+	for _, run := range runs {
+		result, err := h.store.GetSpeedTest(r.Context(), run.ID)
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if result != nil {
+			test.Results = append(test.Results, result)
+		}
+		if run.Status != model.TestStatusComplete {
+			test.Status = run.Status
+		}
+	}
+
+	status := http.StatusOK
+	if len(test.Results) < len(runs) {
+		status = http.StatusAccepted
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	data, _ := json.MarshalIndent(test, "", "  ")
+	w.Write(data)
+	w.Write([]byte("\n"))
+}
@@ -0,0 +1,227 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// sseKeepalive is how often an idle event stream gets a comment line, so
+// proxies don't time it out between stages.
+const sseKeepalive = 15 * time.Second
+
+// errStreamClosed is returned by writes to an event stream whose handler
+// has returned.
+var errStreamClosed = errors.New("event stream closed")
+
+// TestEvents runs a server-driven test like TestStatus, for clients whose
+// WebSocket upgrade is blocked: progress updates go out as Server-Sent
+// Events, latency probes as "ping" events answered through Pong, and the
+// client moves data over the data endpoints as usual. Disconnecting
+// cancels the run.
+func (h *SpeedTestHandler) TestEvents(w http.ResponseWriter, r *http.Request) {
+	session := h.claimRunSession(w, r)
+	if session == nil {
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	// Keeps nginx and similar proxies from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		session.Release()
+		return
+	}
+	// The stream outlives the server's write timeout; the run's own
+	// timeout bounds it
+	rc.SetWriteDeadline(time.Time{})
+
+	events := &sseStream{w: w, rc: rc}
+	pinger := newSSEPinger(events)
+	h.pingers.Store(session.ID, pinger)
+	// The run may outlive the handler until it notices the cancellation;
+	// from then on its probes and updates must not touch the response
+	defer func() {
+		h.pingers.Delete(session.ID)
+		events.close()
+		pinger.close()
+	}()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	progressChan, final := h.startRun(ctx, session, r, pinger)
+
+	keepalive := time.NewTicker(sseKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case update := <-progressChan:
+			if err := events.send("", update); err != nil {
+				return
+			}
+		case update := <-final:
+			for _, pending := range pendingProgress(progressChan) {
+				if err := events.send("", pending); err != nil {
+					return
+				}
+			}
+			events.send("", update)
+			return
+		case <-keepalive.C:
+			if err := events.comment("keepalive"); err != nil {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Pong answers a "ping" event of an event-stream run.
+func (h *SpeedTestHandler) Pong(w http.ResponseWriter, r *http.Request) {
+	seq, err := strconv.Atoi(r.URL.Query().Get("seq"))
+	if err != nil {
+		http.Error(w, "seq is required", http.StatusBadRequest)
+		return
+	}
+	p, ok := h.pingers.Load(r.URL.Query().Get("test_id"))
+	if !ok {
+		http.Error(w, "No event stream is running for this test", http.StatusNotFound)
+		return
+	}
+
+	p.(*ssePinger).pong(seq)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sseStream writes Server-Sent Events to one response. Progress updates
+// and probes come from different goroutines, so writes are serialized,
+// and refused once the handler has closed the stream.
+type sseStream struct {
+	mu     sync.Mutex
+	w      http.ResponseWriter
+	rc     *http.ResponseController
+	closed bool
+}
+
+// close stops further writes; the response belongs to the server again
+// once the handler returns.
+func (e *sseStream) close() {
+	e.mu.Lock()
+	e.closed = true
+	e.mu.Unlock()
+}
+
+// send writes v as the JSON data of one event; an empty name sends the
+// default "message" event.
+func (e *sseStream) send(event string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return errStreamClosed
+	}
+	if event != "" {
+		if _, err := fmt.Fprintf(e.w, "event: %s\n", event); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(e.w, "data: %s\n\n", data); err != nil {
+		return err
+	}
+	return e.rc.Flush()
+}
+
+// comment writes a line clients ignore.
+func (e *sseStream) comment(text string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return errStreamClosed
+	}
+	if _, err := fmt.Fprintf(e.w, ": %s\n\n", text); err != nil {
+		return err
+	}
+	return e.rc.Flush()
+}
+
+// ssePinger measures round-trip time with "ping" events, which the client
+// echoes by posting the sequence number to Pong. The RTT includes that
+// request, so it reads somewhat higher than a WebSocket ping.
+type ssePinger struct {
+	events    *sseStream
+	mu        sync.Mutex
+	pending   map[int]chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newSSEPinger(events *sseStream) *ssePinger {
+	return &ssePinger{
+		events:  events,
+		pending: make(map[int]chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// close fails the probes waiting for an echo, and any sent after.
+func (p *ssePinger) close() {
+	p.closeOnce.Do(func() { close(p.done) })
+}
+
+func (p *ssePinger) Ping(seq int, timeout time.Duration) (time.Duration, error) {
+	ch := make(chan struct{}, 1)
+	p.mu.Lock()
+	p.pending[seq] = ch
+	p.mu.Unlock()
+
+	defer func() {
+		p.mu.Lock()
+		delete(p.pending, seq)
+		p.mu.Unlock()
+	}()
+
+	start := time.Now()
+	if err := p.events.send("ping", map[string]int{"seq": seq}); err != nil {
+		return 0, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-ch:
+		return time.Since(start), nil
+	case <-timer.C:
+		return 0, errProbeTimeout
+	case <-p.done:
+		return 0, errStreamClosed
+	}
+}
+
+// pong matches an echo to its probe; late or unknown ones are dropped.
+func (p *ssePinger) pong(seq int) {
+	p.mu.Lock()
+	ch := p.pending[seq]
+	p.mu.Unlock()
+
+	if ch != nil {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
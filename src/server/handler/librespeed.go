@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/casapps/casspeed/src/server/model"
+	"github.com/casapps/casspeed/src/server/service"
+)
+
+// LibreSpeed clients run the test themselves against four plain
+// endpoints: garbage for download data, empty for upload and ping, getIP
+// and telemetry. None of them carry a test ID, so transfers are not tied
+// to a session; the figures the client reports through telemetry are
+// stored as they are, marked with their source as unverified.
+
+const (
+	librespeedChunk     = 1024 * 1024 // garbage's ckSize unit
+	librespeedDefChunks = 4
+	librespeedMaxChunks = 1024
+)
+
+// LibreSpeedGarbage serves ckSize megabytes of download data.
+func (h *SpeedTestHandler) LibreSpeedGarbage(w http.ResponseWriter, r *http.Request) {
+	chunks := librespeedDefChunks
+	if n, err := strconv.Atoi(r.URL.Query().Get("ckSize")); err == nil && n > 0 {
+		chunks = min(n, librespeedMaxChunks)
+	}
+
+	w.Header().Set("Content-Description", "File Transfer")
+	w.Header().Set("Content-Disposition", "attachment; filename=random.dat")
+	w.Header().Set("Pragma", "no-cache")
+	// Large chunks outlast the server's write timeout on slow links; the
+	// request context still bounds them
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	h.service.ServePayload(w, r, int64(chunks)*librespeedChunk, nil)
+}
+
+// LibreSpeedEmpty answers pings and swallows uploads.
+func (h *SpeedTestHandler) LibreSpeedEmpty(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		_, err := h.service.DiscardUpload(w, r)
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(err, &tooLarge):
+			http.Error(w, fmt.Sprintf("Upload exceeds %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+			return
+		case err != nil:
+			http.Error(w, "Upload failed", http.StatusInternalServerError)
+			return
+		}
+	}
+	w.Header().Set("Cache-Control", "no-store, no-cache, must-revalidate, max-age=0")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(http.StatusOK)
+}
+
+// LibreSpeedGetIP reports the client's address. There is no ISP lookup,
+// so rawIspInfo is always empty.
+func (h *SpeedTestHandler) LibreSpeedGetIP(w http.ResponseWriter, r *http.Request) {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	response := map[string]interface{}{
+		"processedString": ip,
+		"rawIspInfo":      "",
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	data, _ := json.Marshal(response)
+	w.Write(data)
+}
+
+// LibreSpeedTelemetry stores a result reported by a LibreSpeed client and
+// answers "id <share code>", which the client turns into a results/?id=
+// link. Nothing backs the figures, so the result is stored with source
+// librespeed and left out of statistics unless test.unverified_stats is
+// on.
+func (h *SpeedTestHandler) LibreSpeedTelemetry(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(1024 * 1024); err != nil && err != http.ErrNotMultipart {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	test := &model.SpeedTest{
+		ID:           service.GenerateTestID(),
+		Status:       model.TestStatusComplete,
+		IPFamily:     service.IPFamily(r.RemoteAddr),
+		Timestamp:    time.Now(),
+		ClientIPHash: service.HashIP(r.RemoteAddr),
+		UserAgent:    r.UserAgent(),
+		Source:       model.SourceLibreSpeed,
+		ServerID:     h.registry.ID(),
+		ShareCode:    service.GenerateShareCode(),
+		CreatedAt:    time.Now(),
+	}
+
+	// Stages that didn't run or failed come as "" or "Fail"
+	var stages []string
+	if v, ok := librespeedFigure(r, "ping"); ok {
+		test.PingMs = v
+		test.JitterMs, _ = librespeedFigure(r, "jitter")
+		stages = append(stages, service.StagePing)
+	}
+	if v, ok := librespeedFigure(r, "dl"); ok {
+		test.DownloadMbps = v
+		stages = append(stages, service.StageDownload)
+	}
+	if v, ok := librespeedFigure(r, "ul"); ok {
+		test.UploadMbps = v
+		stages = append(stages, service.StageUpload)
+	}
+	if len(stages) == 0 {
+		http.Error(w, "No results", http.StatusBadRequest)
+		return
+	}
+	test.Stages = strings.Join(stages, ",")
+
+	if err := h.store.CreateSpeedTest(r.Context(), test); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintf(w, "id %s", test.ShareCode)
+}
+
+// LibreSpeedResult sends results/?id= links to the result's share image.
+func (h *SpeedTestHandler) LibreSpeedResult(w http.ResponseWriter, r *http.Request) {
+	code := r.URL.Query().Get("id")
+	if code == "" {
+		http.NotFound(w, r)
+		return
+	}
+	http.Redirect(w, r, "/s/"+code+".png", http.StatusFound)
+}
+
+// librespeedFigure parses one reported figure, if the stage produced one.
+func librespeedFigure(r *http.Request, field string) (float64, bool) {
+	v, err := strconv.ParseFloat(r.FormValue(field), 64)
+	if err != nil || v < 0 || math.IsInf(v, 0) || math.IsNaN(v) {
+		return 0, false
+	}
+	return v, true
+}
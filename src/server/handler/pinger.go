@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var errProbeTimeout = errors.New("probe timed out")
+
+// wsPinger measures round-trip time with WebSocket ping control frames.
+// Browsers and WebSocket libraries answer pings with a pong carrying the
+// same payload, so no client cooperation is needed beyond reading the
+// connection.
+type wsPinger struct {
+	conn    *websocket.Conn
+	mu      sync.Mutex
+	pending map[int]chan time.Duration
+}
+
+func newWSPinger(conn *websocket.Conn) *wsPinger {
+	p := &wsPinger{
+		conn:    conn,
+		pending: make(map[int]chan time.Duration),
+	}
+	conn.SetPongHandler(p.handlePong)
+	return p
+}
+
+func (p *wsPinger) Ping(seq int, timeout time.Duration) (time.Duration, error) {
+	ch := make(chan time.Duration, 1)
+	p.mu.Lock()
+	p.pending[seq] = ch
+	p.mu.Unlock()
+
+	defer func() {
+		p.mu.Lock()
+		delete(p.pending, seq)
+		p.mu.Unlock()
+	}()
+
+	payload := fmt.Sprintf("%d:%d", seq, time.Now().UnixNano())
+	if err := p.conn.WriteControl(websocket.PingMessage, []byte(payload), time.Now().Add(timeout)); err != nil {
+		return 0, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case rtt := <-ch:
+		return rtt, nil
+	case <-timer.C:
+		return 0, errProbeTimeout
+	}
+}
+
+// handlePong matches an echoed probe to its sender and computes the RTT
+// from the timestamp embedded in the payload.
+func (p *wsPinger) handlePong(appData string) error {
+	seqStr, tsStr, ok := strings.Cut(appData, ":")
+	if !ok {
+		return nil
+	}
+	seq, err := strconv.Atoi(seqStr)
+	if err != nil {
+		return nil
+	}
+	sent, err := strconv.ParseInt(tsStr, 10, 64)
+	if err != nil {
+		return nil
+	}
+
+	p.mu.Lock()
+	ch := p.pending[seq]
+	p.mu.Unlock()
+
+	if ch != nil {
+		select {
+		case ch <- time.Since(time.Unix(0, sent)):
+		default:
+		}
+	}
+	return nil
+}
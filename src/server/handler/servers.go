@@ -0,0 +1,19 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// ListServers returns the server registry: this server first, then its
+// configured peers.
+func (h *SpeedTestHandler) ListServers(w http.ResponseWriter, r *http.Request) {
+	response := map[string]interface{}{
+		"id":      h.registry.ID(),
+		"servers": h.registry.Servers(),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	data, _ := json.MarshalIndent(response, "", "  ")
+	w.Write(data)
+	w.Write([]byte("\n"))
+}
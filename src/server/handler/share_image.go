@@ -8,6 +8,7 @@ import (
 	"image/png"
 	"net/http"
 
+	"github.com/casapps/casspeed/src/server/service"
 	"github.com/casapps/casspeed/src/server/store"
 	"github.com/go-chi/chi/v5"
 )
@@ -48,6 +49,9 @@ func (h *ShareImageHandler) GetSharePNG(w http.ResponseWriter, r *http.Request)
 	drawText(img, 100, 200, fmt.Sprintf("Download: %.1f Mbps", test.DownloadMbps), color.White)
 	drawText(img, 100, 280, fmt.Sprintf("Upload: %.1f Mbps", test.UploadMbps), color.White)
 	drawText(img, 100, 360, fmt.Sprintf("Ping: %.1f ms", test.PingMs), color.White)
+	if grade := service.BufferbloatGrade(test.PingMs, test.PingDownloadMs, test.PingUploadMs); grade != "" {
+		drawText(img, 600, 360, fmt.Sprintf("Bufferbloat: %s (%d RPM)", grade, service.ResponsivenessRPM(test.PingDownloadMs, test.PingUploadMs)), color.White)
+	}
 	drawText(img, 100, 440, test.Timestamp.Format("2006-01-02 15:04:05"), color.RGBA{136, 136, 136, 255})
 
 	buf := new(bytes.Buffer)
@@ -77,14 +81,20 @@ func (h *ShareImageHandler) GetShareSVG(w http.ResponseWriter, r *http.Request)
 
 	h.store.IncrementShareViews(r.Context(), shareCode)
 
+	bufferbloat := ""
+	if grade := service.BufferbloatGrade(test.PingMs, test.PingDownloadMs, test.PingUploadMs); grade != "" {
+		bufferbloat = fmt.Sprintf(`
+  <text x="600" y="360" font-family="Arial" font-size="32" fill="white">Bufferbloat: %s (%d RPM)</text>`, grade, service.ResponsivenessRPM(test.PingDownloadMs, test.PingUploadMs))
+	}
+
 	svg := fmt.Sprintf(`<svg width="1200" height="630" xmlns="http://www.w3.org/2000/svg">
   <rect width="1200" height="630" fill="#0f0f23"/>
   <text x="100" y="100" font-family="Arial" font-size="48" fill="white">casspeed</text>
   <text x="100" y="200" font-family="Arial" font-size="32" fill="white">Download: %.1f Mbps</text>
   <text x="100" y="280" font-family="Arial" font-size="32" fill="white">Upload: %.1f Mbps</text>
-  <text x="100" y="360" font-family="Arial" font-size="32" fill="white">Ping: %.1f ms</text>
+  <text x="100" y="360" font-family="Arial" font-size="32" fill="white">Ping: %.1f ms</text>%s
   <text x="100" y="440" font-family="Arial" font-size="24" fill="#888">%s</text>
-</svg>`, test.DownloadMbps, test.UploadMbps, test.PingMs, test.Timestamp.Format("2006-01-02 15:04:05"))
+</svg>`, test.DownloadMbps, test.UploadMbps, test.PingMs, bufferbloat, test.Timestamp.Format("2006-01-02 15:04:05"))
 
 	w.Header().Set("Content-Type", "image/svg+xml")
 	w.Header().Set("Cache-Control", "public, max-age=3600")
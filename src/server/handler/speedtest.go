@@ -1,11 +1,18 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/casapps/casspeed/src/config"
 	"github.com/casapps/casspeed/src/server/model"
 	"github.com/casapps/casspeed/src/server/service"
 	"github.com/casapps/casspeed/src/server/store"
@@ -14,27 +21,63 @@ import (
 )
 
 type SpeedTestHandler struct {
-	store   store.Store
-	service *service.SpeedTestService
+	store    store.Store
+	service  *service.SpeedTestService
+	registry *service.Registry
 	upgrader websocket.Upgrader
+	pingers  sync.Map // *ssePinger of each event-stream run, by session ID
 }
 
-func NewSpeedTestHandler(st store.Store, svc *service.SpeedTestService) *SpeedTestHandler {
+func NewSpeedTestHandler(st store.Store, svc *service.SpeedTestService, reg *service.Registry) *SpeedTestHandler {
 	return &SpeedTestHandler{
-		store:   st,
-		service: svc,
+		store:    st,
+		service:  svc,
+		registry: reg,
 		upgrader: websocket.Upgrader{
 			CheckOrigin: func(r *http.Request) bool { return true },
 		},
 	}
 }
 
+// StartTest creates a test session. Its ID is the one the WebSocket run
+// attaches to with ?test_id=, or that a client-driven test tags its data
+// transfers with before submitting to SubmitResult, and under which the
+// result is stored.
 func (h *SpeedTestHandler) StartTest(w http.ResponseWriter, r *http.Request) {
-	testID := service.GenerateTestID()
-	
-	response := map[string]string{
-		"test_id": testID,
-		"status":  "started",
+	opts, err := h.testOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if opts.DualStack {
+		h.startDualStack(w, r, opts)
+		return
+	}
+
+	session, err := h.openSession(r, opts)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"test_id":        session.ID,
+		"status":         model.TestStatusStarted,
+		"streams":        session.Options.Streams,
+		"duration":       session.Options.Duration,
+		"stages":         session.Options.Stages,
+		"payload_size":   session.Options.PayloadSize,
+		"loaded_latency": session.Options.LoadedLatency,
+		"adaptive":       session.Options.Adaptive,
+		"transport":      session.Options.Transport,
+		"expires_at":     session.ExpiresAt.UTC().Format(time.RFC3339),
+	}
+	if plan := h.service.UDPPlan(session); plan != nil {
+		response["udp"] = plan
+	}
+	if port := h.service.TransportPort(service.TransportH3); port != 0 {
+		response["http3_port"] = port
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -43,65 +86,444 @@ func (h *SpeedTestHandler) StartTest(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("\n"))
 }
 
+// testOptions reads the requested run parameters from the query string
+// and, for JSON requests, the body, then validates and clamps them.
+func (h *SpeedTestHandler) testOptions(r *http.Request) (service.TestOptions, error) {
+	var params service.TestParams
+	q := r.URL.Query()
+
+	ints := map[string]*int{
+		"duration":     &params.Duration,
+		"streams":      &params.Streams,
+		"payload_size": &params.PayloadSize,
+	}
+	for name, dst := range ints {
+		if v := q.Get(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return service.TestOptions{}, fmt.Errorf("invalid %s: %q", name, v)
+			}
+			*dst = n
+		}
+	}
+	params.Stages = q.Get("stages")
+	params.Transport = q.Get("transport")
+
+	bools := map[string]**bool{
+		"loaded_latency": &params.LoadedLatency,
+		"adaptive":       &params.Adaptive,
+		"dual_stack":     &params.DualStack,
+	}
+	for name, dst := range bools {
+		if v := q.Get(name); v != "" {
+			b, err := config.ParseBool(v, false)
+			if err != nil {
+				return service.TestOptions{}, fmt.Errorf("invalid %s: %q", name, v)
+			}
+			*dst = &b
+		}
+	}
+
+	if r.ContentLength != 0 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil && err != io.EOF {
+			return service.TestOptions{}, fmt.Errorf("invalid request body: %w", err)
+		}
+	}
+
+	return h.service.Options(params)
+}
+
+// openSession registers a new test session and persists its record.
+func (h *SpeedTestHandler) openSession(r *http.Request, opts service.TestOptions) (*service.TestSession, error) {
+	session := h.service.NewSession(opts, r.URL.Query().Get("share") != "false")
+	if err := h.store.CreateTestSession(r.Context(), newTestSessionRecord(session, r)); err != nil {
+		h.service.EndSession(session.ID)
+		return nil, err
+	}
+	return session, nil
+}
+
+// newTestSessionRecord builds the stored record for a new test session.
+func newTestSessionRecord(session *service.TestSession, r *http.Request) *model.TestSession {
+	opts := session.Options
+	return &model.TestSession{
+		ID:            session.ID,
+		Status:        model.TestStatusStarted,
+		ParentID:      session.ParentID,
+		IPFamily:      session.IPFamily,
+		Streams:       opts.Streams,
+		Duration:      opts.Duration,
+		Stages:        opts.StageList(),
+		PayloadSize:   opts.PayloadSize,
+		LoadedLatency: opts.LoadedLatency,
+		Adaptive:      opts.Adaptive,
+		Transport:     opts.Transport,
+		Share:         session.Share,
+		ClientIPHash:  service.HashIP(r.RemoteAddr),
+		CreatedAt:     session.CreatedAt,
+		ExpiresAt:     session.ExpiresAt,
+	}
+}
+
+// TestStatus runs a server-driven test over a WebSocket. Clients attach to
+// a session from StartTest with ?test_id=, which fixes the run parameters;
+// without one a session is opened on the spot from the query parameters.
 func (h *SpeedTestHandler) TestStatus(w http.ResponseWriter, r *http.Request) {
+	session := h.claimRunSession(w, r)
+	if session == nil {
+		return
+	}
+
 	conn, err := h.upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		http.Error(w, "WebSocket upgrade failed", http.StatusInternalServerError)
+		// Left claimable, so the client can fall back to TestEvents
+		session.Release()
 		return
 	}
 	defer conn.Close()
 
-	progressChan := make(chan service.ProgressUpdate, 10)
+	// The run outlives the request timeout middleware's deadline, but not
+	// the connection or a cancel request from the client
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	disconnected := make(chan struct{})
+	pinger := newWSPinger(conn)
 
+	// Control frames (pongs) are only processed while reading
 	go func() {
-		result, _ := h.service.RunTest(10, progressChan)
-		
-		testID := service.GenerateTestID()
-		shareCode := ""
-		if r.URL.Query().Get("share") != "false" {
-			shareCode = service.GenerateShareCode()
-		}
-
-		test := &model.SpeedTest{
-			ID:           testID,
-			Timestamp:    time.Now(),
-			DownloadMbps: result.DownloadMbps,
-			UploadMbps:   result.UploadMbps,
-			PingMs:       result.PingMs,
-			JitterMs:     result.JitterMs,
-			PacketLoss:   result.PacketLoss,
-			ClientIPHash: service.HashIP(r.RemoteAddr),
-			UserAgent:    r.UserAgent(),
-			ShareCode:    shareCode,
-			CreatedAt:    time.Now(),
-		}
-
-		h.store.CreateSpeedTest(r.Context(), test)
-
-		finalUpdate := service.ProgressUpdate{
-			Stage:    "complete",
-			Progress: 1.0,
-			Message:  "Test complete",
-		}
-		progressChan <- finalUpdate
-		close(progressChan)
+		defer close(disconnected)
+		defer cancel()
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var msg struct {
+				Type string `json:"type"`
+			}
+			if json.Unmarshal(data, &msg) == nil && msg.Type == "cancel" {
+				cancel()
+			}
+		}
 	}()
 
-	for update := range progressChan {
-		if err := conn.WriteJSON(update); err != nil {
+	progressChan, final := h.startRun(ctx, session, r, pinger)
+
+	// Returning as soon as the client goes away frees its rate-limit slot;
+	// the run notices the cancelled context and winds down on its own.
+	for {
+		select {
+		case update := <-progressChan:
+			if err := conn.WriteJSON(update); err != nil {
+				return
+			}
+		case update := <-final:
+			for _, pending := range pendingProgress(progressChan) {
+				if err := conn.WriteJSON(pending); err != nil {
+					return
+				}
+			}
+			conn.WriteJSON(update)
+			return
+		case <-disconnected:
 			return
 		}
 	}
 }
 
+// startRun marks the session running and runs it in the background
+// until it finishes or ctx is cancelled. Progress comes on the first
+// channel; the second delivers the closing update once the outcome is
+// stored.
+func (h *SpeedTestHandler) startRun(ctx context.Context, session *service.TestSession, r *http.Request, pinger service.Pinger) (<-chan service.ProgressUpdate, <-chan service.ProgressUpdate) {
+	storeCtx := context.Background()
+	h.store.UpdateTestSessionStatus(storeCtx, session.ID, model.TestStatusRunning)
+
+	progressChan := make(chan service.ProgressUpdate, 10)
+	final := make(chan service.ProgressUpdate, 1)
+	go func() {
+		result, err := h.service.RunTest(ctx, session, pinger, progressChan)
+		h.service.EndSession(session.ID)
+		final <- h.finishTest(storeCtx, session, r, result, err != nil)
+	}()
+	return progressChan, final
+}
+
+// pendingProgress takes the updates still buffered on a run's progress
+// channel. The run has sent them all by the time its final update
+// arrives, and they go out before it.
+func pendingProgress(progressChan <-chan service.ProgressUpdate) []service.ProgressUpdate {
+	var updates []service.ProgressUpdate
+	for {
+		select {
+		case update := <-progressChan:
+			updates = append(updates, update)
+		default:
+			return updates
+		}
+	}
+}
+
+// claimRunSession finds the session a server-driven run attaches to with
+// ?test_id=, or opens one from the query parameters, and claims it for
+// the run. It writes the error response and returns nil if it can't.
+func (h *SpeedTestHandler) claimRunSession(w http.ResponseWriter, r *http.Request) *service.TestSession {
+	var session *service.TestSession
+	if testID := r.URL.Query().Get("test_id"); testID != "" {
+		session = h.service.Session(testID)
+		if session == nil {
+			http.Error(w, "Test session not found or expired", http.StatusNotFound)
+			return nil
+		}
+	} else {
+		opts, err := h.testOptions(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return nil
+		}
+		if opts.DualStack {
+			http.Error(w, "Dual-stack tests must be started with POST /api/v1/speedtest/start", http.StatusBadRequest)
+			return nil
+		}
+		session, err = h.openSession(r, opts)
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return nil
+		}
+	}
+	if !session.Claim() {
+		http.Error(w, service.ErrSessionInUse.Error(), http.StatusConflict)
+		return nil
+	}
+	return session
+}
+
+// finishTest stores the outcome of a WebSocket run and returns the final
+// update for the client. Aborted runs are stored, without a share code,
+// only if the server is configured to keep them. A dual-stack run whose
+// data moved over the wrong family counts as aborted.
+func (h *SpeedTestHandler) finishTest(ctx context.Context, session *service.TestSession, r *http.Request, result *service.TestResult, aborted bool) service.ProgressUpdate {
+	message := "Test cancelled"
+	if !aborted {
+		if err := session.CheckFamily(); err != nil {
+			aborted, message = true, err.Error()
+		}
+	}
+	if aborted {
+		if h.service.SaveAborted() {
+			h.store.CreateSpeedTest(ctx, h.newSpeedTest(session, r, result, model.TestStatusAborted))
+		}
+		h.store.UpdateTestSessionStatus(ctx, session.ID, model.TestStatusAborted)
+		return service.ProgressUpdate{
+			Stage:   model.TestStatusAborted,
+			Message: message,
+			TestID:  session.ID,
+		}
+	}
+
+	test := h.newSpeedTest(session, r, result, model.TestStatusComplete)
+	if err := h.store.CreateSpeedTest(ctx, test); err == nil {
+		h.store.UpdateTestSessionStatus(ctx, session.ID, model.TestStatusComplete)
+	}
+	return service.ProgressUpdate{
+		Stage:     "complete",
+		Progress:  1.0,
+		Message:   "Test complete",
+		TestID:    test.ID,
+		ShareCode: test.ShareCode,
+	}
+}
+
+// SubmitResult accepts the figures a client measured during a
+// client-driven test and stores them once they pass the server's
+// cross-check against its own byte counters.
+func (h *SpeedTestHandler) SubmitResult(w http.ResponseWriter, r *http.Request) {
+	session := h.service.Session(chi.URLParam(r, "id"))
+	if session == nil {
+		http.Error(w, "Test session not found or expired", http.StatusNotFound)
+		return
+	}
+
+	var submitted service.ClientResult
+	if err := json.NewDecoder(r.Body).Decode(&submitted); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.VerifyResult(session, &submitted); err != nil {
+		if errors.Is(err, service.ErrResultSubmitted) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	h.service.EndSession(session.ID)
+
+	// Setup figures only count for sessions that ran the stage
+	setup := submitted.Setup
+	if !session.Options.Runs(service.StageSetup) {
+		setup = nil
+	}
+
+	test := h.newSpeedTest(session, r, &service.TestResult{
+		DownloadMbps: submitted.DownloadMbps,
+		UploadMbps:   submitted.UploadMbps,
+		PingMs:       submitted.PingMs,
+		JitterMs:     submitted.JitterMs,
+		PacketLoss:   submitted.PacketLoss,
+		DownloadTCP:  session.DownloadTCP(),
+		UploadTCP:    session.UploadTCP(),
+		UDP:          session.UDPStats(),
+		Setup:        setup,
+	}, model.TestStatusComplete)
+	if err := h.store.CreateSpeedTest(r.Context(), test); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	h.store.UpdateTestSessionStatus(r.Context(), session.ID, model.TestStatusComplete)
+
+	w.Header().Set("Content-Type", "application/json")
+	data, _ := json.MarshalIndent(test, "", "  ")
+	w.Write(data)
+	w.Write([]byte("\n"))
+}
+
+// newSpeedTest builds the stored record for a finished test session.
+func (h *SpeedTestHandler) newSpeedTest(session *service.TestSession, r *http.Request, result *service.TestResult, status string) *model.SpeedTest {
+	shareCode := ""
+	if session.Share && status == model.TestStatusComplete {
+		shareCode = service.GenerateShareCode()
+	}
+	opts := session.Options
+
+	// The family the data moved over, which the request reporting the
+	// result need not share
+	family := session.TransferFamily()
+	if family == "" {
+		family = service.IPFamily(r.RemoteAddr)
+	}
+
+	return &model.SpeedTest{
+		ID:                 session.ID,
+		Status:             status,
+		ParentID:           session.ParentID,
+		IPFamily:           family,
+		Timestamp:          time.Now(),
+		DownloadMbps:       result.DownloadMbps,
+		UploadMbps:         result.UploadMbps,
+		PingMs:             result.PingMs,
+		JitterMs:           result.JitterMs,
+		PacketLoss:         result.PacketLoss,
+		PingDownloadMs:     result.PingDownloadMs,
+		PingUploadMs:       result.PingUploadMs,
+		Duration:           opts.Duration,
+		Streams:            opts.Streams,
+		Stages:             opts.StageList(),
+		PayloadSize:        opts.PayloadSize,
+		Adaptive:           opts.Adaptive,
+		Transport:          session.Transport(),
+		DownloadStopReason: result.DownloadStopReason,
+		UploadStopReason:   result.UploadStopReason,
+		DownloadStats:      result.DownloadStats,
+		UploadStats:        result.UploadStats,
+		DownloadTCP:        result.DownloadTCP,
+		UploadTCP:          result.UploadTCP,
+		UDP:                result.UDP,
+		Setup:              result.Setup,
+		ClientIPHash:       service.HashIP(r.RemoteAddr),
+		UserAgent:          r.UserAgent(),
+		ServerID:           h.registry.ID(),
+		ShareCode:          shareCode,
+		CreatedAt:          time.Now(),
+	}
+}
+
+// Ping answers latency probes for client-driven tests, and for clients
+// choosing between servers. Browsers on a peer's page may read its
+// timing.
+func (h *SpeedTestHandler) Ping(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Timing-Allow-Origin", "*")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ReportSetup takes the connection setup figures the client measured
+// during the setup stage of a server-driven run.
+func (h *SpeedTestHandler) ReportSetup(w http.ResponseWriter, r *http.Request) {
+	session := h.service.Session(r.URL.Query().Get("test_id"))
+	if session == nil {
+		http.Error(w, "Test session not found or expired", http.StatusNotFound)
+		return
+	}
+
+	var stats model.SetupStats
+	if err := json.NewDecoder(r.Body).Decode(&stats); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if err := session.ReportSetup(&stats); err != nil {
+		switch {
+		case errors.Is(err, service.ErrSetupNotRunning):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, service.ErrSetupReported):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Download serves one payload, or with ?stream=true keeps sending until
+// the client hangs up.
 func (h *SpeedTestHandler) Download(w http.ResponseWriter, r *http.Request) {
-	size := 10 * 1024 * 1024
-	h.service.GenerateRandomData(w, size)
+	size := int64(service.DefaultPayloadSize)
+	session := h.service.Session(r.URL.Query().Get("test_id"))
+	if session != nil {
+		size = int64(session.Options.PayloadSize)
+		if err := session.AcceptTransport(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if r.URL.Query().Get("stream") == "true" {
+		size = 0
+	}
+	h.service.ServePayload(w, r, size, session)
 }
 
+// Upload accepts one upload for a live test session, up to the session's
+// payload size and before its deadline.
 func (h *SpeedTestHandler) Upload(w http.ResponseWriter, r *http.Request) {
-	totalBytes, err := h.service.ConsumeUploadData(r)
-	if err != nil {
+	testID := r.URL.Query().Get("test_id")
+	if testID == "" {
+		http.Error(w, "test_id is required", http.StatusBadRequest)
+		return
+	}
+	session := h.service.Session(testID)
+	if session == nil {
+		http.Error(w, "Test session not found or expired", http.StatusNotFound)
+		return
+	}
+	if err := session.AcceptTransport(r); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	totalBytes, err := h.service.ConsumeUploadData(w, r, session)
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		http.Error(w, fmt.Sprintf("Upload exceeds the test's payload size of %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+		return
+	case errors.Is(err, service.ErrSessionDeadline):
+		http.Error(w, err.Error(), http.StatusRequestTimeout)
+		return
+	case err != nil:
 		http.Error(w, "Upload failed", http.StatusInternalServerError)
 		return
 	}
@@ -123,7 +545,7 @@ func (h *SpeedTestHandler) GetResult(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if test == nil {
-		http.Error(w, "Test not found", http.StatusNotFound)
+		h.testSessionStatus(w, r, testID)
 		return
 	}
 
@@ -133,6 +555,32 @@ func (h *SpeedTestHandler) GetResult(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("\n"))
 }
 
+// testSessionStatus reports a test that has been started but has no
+// stored result yet with 202 Accepted and the session record.
+func (h *SpeedTestHandler) testSessionStatus(w http.ResponseWriter, r *http.Request, testID string) {
+	session, err := h.store.GetTestSession(r.Context(), testID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	if session == nil {
+		http.Error(w, "Test not found", http.StatusNotFound)
+		return
+	}
+
+	if session.DualStack {
+		h.dualStackStatus(w, r, session)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	data, _ := json.MarshalIndent(session, "", "  ")
+	w.Write(data)
+	w.Write([]byte("\n"))
+}
+
 func (h *SpeedTestHandler) GetShare(w http.ResponseWriter, r *http.Request) {
 	shareCode := chi.URLParam(r, "code")
 
@@ -149,6 +597,25 @@ func (h *SpeedTestHandler) GetShare(w http.ResponseWriter, r *http.Request) {
 
 	h.store.IncrementShareViews(r.Context(), shareCode)
 
+	responsiveness := ""
+	if grade := service.BufferbloatGrade(test.PingMs, test.PingDownloadMs, test.PingUploadMs); grade != "" {
+		responsiveness = fmt.Sprintf(`
+    <p>Latency under load: %.1f ms down / %.1f ms up</p>
+    <p>Bufferbloat grade: %s (%d RPM)</p>`, test.PingDownloadMs, test.PingUploadMs, grade, service.ResponsivenessRPM(test.PingDownloadMs, test.PingUploadMs))
+	}
+
+	setup := ""
+	if test.Setup != nil {
+		setup = fmt.Sprintf(`
+    <p>Connection setup: %s</p>`, setupSummary(test.Setup))
+	}
+
+	source := ""
+	if test.Source != "" {
+		source = fmt.Sprintf(`
+    <p>Reported by a %s client; not verified by this server</p>`, test.Source)
+	}
+
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	fmt.Fprintf(w, `<!DOCTYPE html>
 <html lang="en">
@@ -162,11 +629,26 @@ func (h *SpeedTestHandler) GetShare(w http.ResponseWriter, r *http.Request) {
     <h1>Speed Test Result</h1>
     <p>Download: %.1f Mbps</p>
     <p>Upload: %.1f Mbps</p>
-    <p>Ping: %.1f ms</p>
+    <p>Ping: %.1f ms</p>%s%s%s
     <p>Tested: %s</p>
   </body>
 </html>
-`, shareCode, test.DownloadMbps, test.UploadMbps, test.PingMs, test.Timestamp.Format("2006-01-02 15:04:05"))
+`, shareCode, test.DownloadMbps, test.UploadMbps, test.PingMs, responsiveness, setup, source, test.Timestamp.Format("2006-01-02 15:04:05"))
+}
+
+// setupSummary lists the setup stage's timings, leaving out DNS and TLS
+// when the client didn't report them.
+func setupSummary(stats *model.SetupStats) string {
+	var parts []string
+	if stats.DNSMs > 0 {
+		parts = append(parts, fmt.Sprintf("DNS %.1f ms", stats.DNSMs))
+	}
+	parts = append(parts, fmt.Sprintf("TCP connect %.1f ms", stats.ConnectMs))
+	if stats.TLSMs > 0 {
+		parts = append(parts, fmt.Sprintf("TLS handshake %.1f ms", stats.TLSMs))
+	}
+	parts = append(parts, fmt.Sprintf("first byte %.1f ms", stats.TTFBMs))
+	return strings.Join(parts, ", ")
 }
 
 func (h *SpeedTestHandler) GetHistory(w http.ResponseWriter, r *http.Request) {
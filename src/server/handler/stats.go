@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/casapps/casspeed/src/server/model"
+	"github.com/casapps/casspeed/src/server/service"
+	"github.com/casapps/casspeed/src/server/store"
+)
+
+// Stats returns hourly, daily or weekly statistics (?bucket=, default day)
+// of the complete results from a from/to range, optionally only those of a
+// server_id. The endpoint is public, so it doesn't narrow to one user's or
+// device's history, the range is capped at two years and 400 buckets, and
+// the store sums up each bucket rather than handing over every result. Results the client reported, such as
+// LibreSpeed telemetry, count only with test.unverified_stats.
+func (h *SpeedTestHandler) Stats(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	bucket := q.Get("bucket")
+	if bucket == "" {
+		bucket = service.StatsDay
+	}
+	if !service.ValidStatsBucket(bucket) {
+		http.Error(w, "Invalid bucket: use hour, day or week", http.StatusBadRequest)
+		return
+	}
+	from, to, err := service.StatsRange(q.Get("from"), q.Get("to"), time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	filter := store.StatsFilter{
+		ServerID:   q.Get("server_id"),
+		From:       from,
+		To:         to,
+		Unverified: h.service.UnverifiedStats(),
+	}
+	splits, err := service.StatsSplits(from, to, bucket)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	stored, err := h.store.GetSpeedTestTotals(r.Context(), filter, splits)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	var rolled []*model.SpeedTestTotals
+	if bucket != service.StatsHour {
+		rolled, err = h.store.GetSpeedTestDayTotals(r.Context(), filter, splits)
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	response := map[string]interface{}{
+		"bucket":  bucket,
+		"from":    from,
+		"to":      to,
+		"buckets": service.StatsBuckets(stored, rolled, bucket),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	data, _ := json.MarshalIndent(response, "", "  ")
+	w.Write(data)
+	w.Write([]byte("\n"))
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/casapps/casspeed/src/server/service"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// tlsConfig loads the configured certificate. HTTP/2 is negotiated
+// through ALPN, with HTTP/1.1 as the fallback.
+func (s *Server) tlsConfig() (*tls.Config, error) {
+	sslConfig := s.Config.Server.SSL
+	cert, err := tls.LoadX509KeyPair(sslConfig.Cert, sslConfig.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	minVersion := uint16(tls.VersionTLS12)
+	if sslConfig.MinVersion == "TLS1.3" {
+		minVersion = tls.VersionTLS13
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   minVersion,
+		NextProtos:   []string{"h2", "http/1.1"},
+	}, nil
+}
+
+// listenHTTP3 serves the router over HTTP/3 on a UDP address. Responses
+// on the TLS listener then advertise it with Alt-Svc, so browsers can
+// move over on their own.
+func (s *Server) listenHTTP3(addr string, tlsConfig *tls.Config) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+
+	s.HTTP3 = &http3.Server{
+		Handler:     s.Router,
+		TLSConfig:   http3.ConfigureTLSConfig(tlsConfig.Clone()),
+		IdleTimeout: 60 * time.Second,
+	}
+	s.http3Conn = conn
+	go s.HTTP3.Serve(conn)
+
+	s.HTTP.Handler = s.altSvc(s.HTTP.Handler)
+	s.Service.EnableTransport(service.TransportH3, conn.LocalAddr().(*net.UDPAddr).Port)
+	return nil
+}
+
+// closeHTTP3 stops the HTTP/3 listener, if any.
+func (s *Server) closeHTTP3() {
+	if s.HTTP3 == nil {
+		return
+	}
+	s.Service.DisableTransport(service.TransportH3)
+	s.HTTP3.Close()
+	s.http3Conn.Close()
+}
+
+// altSvc advertises the HTTP/3 listener on every response.
+func (s *Server) altSvc(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.HTTP3.SetQUICHeaders(w.Header())
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,235 @@
+// Package iperf3 lets stock iperf3 clients test against casspeed.
+//
+// It speaks the iperf3 control protocol for TCP tests, forward or reverse
+// (-R), with parallel streams (-P), and stores every finished run like
+// any other speed test. UDP, SCTP and bidirectional tests are refused
+// with the error an iperf3 server gives for options it doesn't implement.
+package iperf3
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/casapps/casspeed/src/config"
+	"github.com/casapps/casspeed/src/server/payload"
+	"github.com/casapps/casspeed/src/server/store"
+)
+
+// Control connection states, sent as single signed bytes
+const (
+	stateTestStart       = 1
+	stateTestRunning     = 2
+	stateTestEnd         = 4
+	stateParamExchange   = 9
+	stateCreateStreams   = 10
+	stateExchangeResults = 13
+	stateDisplayResults  = 14
+	stateIperfDone       = 16
+	stateServerError     = -1
+	stateAccessDenied    = -2
+)
+
+// iperf3 error numbers, sent after stateServerError so the client prints
+// its own message for them
+const (
+	errDuration   = 5  // IEDURATION: test too long
+	errNumStreams = 6  // IENUMSTREAMS: too many parallel streams
+	errUnimp      = 13 // IEUNIMP: option not implemented
+)
+
+const (
+	DefaultPort = 5201
+
+	cookieSize   = 37 // 36 characters and a NUL
+	maxJSONSize  = 64 * 1024
+	setupTimeout = 10 * time.Second       // For each handshake step
+	endGrace     = 10 * time.Second       // Past a test's duration, before giving up on it
+	drainTimeout = 250 * time.Millisecond // For data sent before TEST_END to arrive
+)
+
+var errProtocol = errors.New("iperf3 protocol error")
+
+// Server accepts iperf3 control and data connections on one TCP listener,
+// as iperf3 itself does. Every connection opens with the test's cookie,
+// which tells data streams apart from new tests, so several tests can run
+// at once.
+type Server struct {
+	cfg     *config.Config
+	store   store.Store
+	payload *payload.Generator
+
+	mu       sync.Mutex
+	listener net.Listener
+	pending  map[string]*test // Tests waiting for their data streams, by cookie
+	running  map[string]int   // Tests per client IP
+}
+
+// New returns an iperf3 server that stores results in st and sends
+// reverse-mode data from gen.
+func New(cfg *config.Config, st store.Store, gen *payload.Generator) *Server {
+	return &Server{
+		cfg:     cfg,
+		store:   st,
+		payload: gen,
+		pending: make(map[string]*test),
+		running: make(map[string]int),
+	}
+}
+
+// Listen starts accepting iperf3 clients on addr.
+func (s *Server) Listen(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.listener = ln
+	s.mu.Unlock()
+
+	go s.serve(ln)
+	return nil
+}
+
+// Close stops the listener. Tests already running finish on their own.
+func (s *Server) Close() {
+	s.mu.Lock()
+	ln := s.listener
+	s.listener = nil
+	s.mu.Unlock()
+
+	if ln != nil {
+		ln.Close()
+	}
+}
+
+func (s *Server) serve(ln net.Listener) {
+	for seq := uint64(0); ; seq++ {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		go s.handle(conn, seq)
+	}
+}
+
+// handle reads the cookie a connection opens with. It is either a data
+// stream for a test waiting on one, or the control connection of a new
+// test. seq is the connection's place in accept order.
+func (s *Server) handle(conn net.Conn, seq uint64) {
+	cookie := make([]byte, cookieSize)
+	conn.SetReadDeadline(time.Now().Add(setupTimeout))
+	if _, err := io.ReadFull(conn, cookie); err != nil {
+		conn.Close()
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	s.mu.Lock()
+	t := s.pending[string(cookie)]
+	s.mu.Unlock()
+	if t != nil {
+		t.addStream(conn, seq)
+		return
+	}
+
+	defer conn.Close()
+	host := remoteHost(conn)
+	if !s.admit(host) {
+		writeState(conn, stateAccessDenied)
+		return
+	}
+	defer s.release(host)
+
+	s.runTest(conn, string(cookie))
+}
+
+// admit counts a new test against its client's max_concurrent.
+func (s *Server) admit(host string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running[host] >= s.cfg.Test.MaxConcurrent {
+		return false
+	}
+	s.running[host]++
+	return true
+}
+
+func (s *Server) release(host string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running[host]--; s.running[host] <= 0 {
+		delete(s.running, host)
+	}
+}
+
+func remoteHost(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	return host
+}
+
+// writeState sends a state change on the control connection.
+func writeState(conn net.Conn, state int8) error {
+	_, err := conn.Write([]byte{byte(state)})
+	return err
+}
+
+// readState reads the next state change from the control connection.
+func readState(conn net.Conn) (int8, error) {
+	var b [1]byte
+	if _, err := io.ReadFull(conn, b[:]); err != nil {
+		return 0, err
+	}
+	return int8(b[0]), nil
+}
+
+// writeError ends a test with one of iperf3's error numbers.
+func writeError(conn net.Conn, code int32) error {
+	state := int8(stateServerError)
+	msg := make([]byte, 9)
+	msg[0] = byte(state)
+	binary.BigEndian.PutUint32(msg[1:], uint32(code))
+	// The errno that follows is left at 0
+	_, err := conn.Write(msg)
+	return err
+}
+
+// readJSON reads a message framed, as all of iperf3's are, by a 32-bit
+// big-endian length.
+func readJSON(conn net.Conn, v any) error {
+	var size uint32
+	if err := binary.Read(conn, binary.BigEndian, &size); err != nil {
+		return err
+	}
+	if size > maxJSONSize {
+		return fmt.Errorf("%w: %d byte message", errProtocol, size)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(conn, data); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func writeJSON(conn net.Conn, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	msg := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	_, err = conn.Write(append(msg, data...))
+	return err
+}
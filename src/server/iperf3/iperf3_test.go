@@ -0,0 +1,231 @@
+package iperf3
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/casapps/casspeed/src/config"
+	"github.com/casapps/casspeed/src/server/model"
+	"github.com/casapps/casspeed/src/server/payload"
+	"github.com/casapps/casspeed/src/server/service"
+	"github.com/casapps/casspeed/src/server/store"
+)
+
+// savedStore hands over every result the server stores.
+type savedStore struct {
+	store.Store
+	saved chan *model.SpeedTest
+}
+
+func (s *savedStore) CreateSpeedTest(ctx context.Context, test *model.SpeedTest) error {
+	s.saved <- test
+	return nil
+}
+
+// startServer runs an iperf3 server on a loopback port and returns its
+// address and the results it stores.
+func startServer(t *testing.T) (string, <-chan *model.SpeedTest) {
+	t.Helper()
+	gen, err := payload.New(payload.KindZero, defaultLen, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := &savedStore{Store: store.NewMemoryStore(), saved: make(chan *model.SpeedTest, 1)}
+	s := New(config.Default(), st, gen)
+	if err := s.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	return s.listener.Addr().String(), st.saved
+}
+
+// client plays the iperf3 client's side of the control protocol.
+type client struct {
+	t       *testing.T
+	addr    string
+	cookie  []byte
+	control net.Conn
+}
+
+func dial(t *testing.T, addr string) *client {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+	// 36 characters and a NUL, like iperf3's own cookies
+	cookie := []byte(strings.Repeat("c", cookieSize-1) + "\x00")
+	if _, err := conn.Write(cookie); err != nil {
+		t.Fatal(err)
+	}
+	return &client{t: t, addr: addr, cookie: cookie, control: conn}
+}
+
+func (c *client) expect(want int8) {
+	c.t.Helper()
+	got, err := readState(c.control)
+	if err != nil {
+		c.t.Fatalf("waiting for state %d: %v", want, err)
+	}
+	if got != want {
+		c.t.Fatalf("got state %d, want %d", got, want)
+	}
+}
+
+// start runs the handshake up to TEST_RUNNING and returns the data
+// streams.
+func (c *client) start(p params) []net.Conn {
+	c.t.Helper()
+	c.expect(stateParamExchange)
+	if err := writeJSON(c.control, p); err != nil {
+		c.t.Fatal(err)
+	}
+	c.expect(stateCreateStreams)
+
+	streams := make([]net.Conn, p.Parallel)
+	for i := range streams {
+		conn, err := net.Dial("tcp", c.addr)
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		c.t.Cleanup(func() { conn.Close() })
+		conn.SetDeadline(time.Now().Add(30 * time.Second))
+		if _, err := conn.Write(c.cookie); err != nil {
+			c.t.Fatal(err)
+		}
+		streams[i] = conn
+	}
+	c.expect(stateTestStart)
+	c.expect(stateTestRunning)
+	return streams
+}
+
+// finish ends the test, exchanges results and returns the server's.
+func (c *client) finish(ours results) results {
+	c.t.Helper()
+	if err := writeState(c.control, stateTestEnd); err != nil {
+		c.t.Fatal(err)
+	}
+	c.expect(stateExchangeResults)
+	if err := writeJSON(c.control, ours); err != nil {
+		c.t.Fatal(err)
+	}
+	var theirs results
+	if err := readJSON(c.control, &theirs); err != nil {
+		c.t.Fatal(err)
+	}
+	c.expect(stateDisplayResults)
+	if err := writeState(c.control, stateIperfDone); err != nil {
+		c.t.Fatal(err)
+	}
+	return theirs
+}
+
+func totalBytes(r results) int64 {
+	var total int64
+	for _, st := range r.Streams {
+		total += st.Bytes
+	}
+	return total
+}
+
+// stored waits for the server to store the test after IPERF_DONE.
+func stored(t *testing.T, saved <-chan *model.SpeedTest) *model.SpeedTest {
+	t.Helper()
+	select {
+	case test := <-saved:
+		return test
+	case <-time.After(5 * time.Second):
+		t.Fatal("test result not stored")
+		return nil
+	}
+}
+
+func TestForward(t *testing.T) {
+	addr, saved := startServer(t)
+	c := dial(t, addr)
+
+	const perStream = 4 << 20
+	streams := c.start(params{TCP: true, Parallel: 2, Num: 2 * perStream})
+	block := make([]byte, defaultLen)
+	for _, conn := range streams {
+		for sent := 0; sent < perStream; sent += len(block) {
+			if _, err := conn.Write(block); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	theirs := c.finish(results{Streams: []streamResult{{ID: 1, Bytes: perStream}, {ID: 3, Bytes: perStream}}})
+	if len(theirs.Streams) != 2 {
+		t.Fatalf("server reported %d streams, want 2", len(theirs.Streams))
+	}
+	for _, s := range theirs.Streams {
+		if s.Bytes != perStream {
+			t.Errorf("stream %d: server received %d bytes, want %d", s.ID, s.Bytes, perStream)
+		}
+		if s.Retransmits != -1 {
+			t.Errorf("stream %d: receiver reported %d retransmits, want -1", s.ID, s.Retransmits)
+		}
+	}
+
+	test := stored(t, saved)
+	if test.Stages != service.StageUpload || test.UploadMbps <= 0 {
+		t.Errorf("stored stages %q, upload %v Mbps; want an upload", test.Stages, test.UploadMbps)
+	}
+}
+
+// counter counts what is written to it.
+type counter struct{ atomic.Int64 }
+
+func (c *counter) Write(p []byte) (int, error) {
+	c.Add(int64(len(p)))
+	return len(p), nil
+}
+
+func TestReverse(t *testing.T) {
+	addr, saved := startServer(t)
+	c := dial(t, addr)
+
+	const limit = 8 << 20
+	streams := c.start(params{TCP: true, Reverse: true, Parallel: 2, Num: limit})
+
+	var received counter
+	done := make(chan struct{}, len(streams))
+	for _, conn := range streams {
+		go func() {
+			io.Copy(&received, conn)
+			done <- struct{}{}
+		}()
+	}
+	// The server stops sending at the limit; end the test once it has
+	// all arrived
+	for deadline := time.Now().Add(10 * time.Second); received.Load() < limit; {
+		if time.Now().After(deadline) {
+			t.Fatalf("received %d bytes, want %d", received.Load(), limit)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	theirs := c.finish(results{Streams: []streamResult{{ID: 1}, {ID: 3}}})
+	test := stored(t, saved)
+
+	// The server closes the streams once the test is done
+	for range streams {
+		<-done
+	}
+	if sent := totalBytes(theirs); sent != received.Load() {
+		t.Errorf("server reported sending %d bytes, client received %d", sent, received.Load())
+	}
+	if test.Stages != service.StageDownload || test.DownloadMbps <= 0 {
+		t.Errorf("stored stages %q, download %v Mbps; want a download", test.Stages, test.DownloadMbps)
+	}
+}
@@ -0,0 +1,360 @@
+package iperf3
+
+import (
+	"cmp"
+	"context"
+	"math"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/casapps/casspeed/src/server/model"
+	"github.com/casapps/casspeed/src/server/service"
+)
+
+// defaultLen is iperf3's TCP block size, for clients that don't send one.
+const defaultLen = 128 * 1024
+
+// params is the test description the client sends during
+// PARAM_EXCHANGE. Fields casspeed doesn't act on are left out; byte
+// counts are floats because iperf3 may write large ones in exponent form.
+type params struct {
+	TCP           bool    `json:"tcp"`
+	UDP           bool    `json:"udp"`
+	SCTP          bool    `json:"sctp"`
+	Omit          int     `json:"omit"`
+	Time          int     `json:"time"`
+	Num           float64 `json:"num"`
+	BlockCount    float64 `json:"blockcount"`
+	Parallel      int     `json:"parallel"`
+	Reverse       bool    `json:"reverse"`
+	Bidirectional bool    `json:"bidirectional"`
+	Len           int     `json:"len"`
+	ClientVersion string  `json:"client_version"`
+}
+
+// byteLimit is the total the client asked for with -n or -k, or 0 for a
+// timed test.
+func (p *params) byteLimit() int64 {
+	if p.Num > 0 {
+		return int64(p.Num)
+	}
+	if p.BlockCount > 0 {
+		size := p.Len
+		if size <= 0 {
+			size = defaultLen
+		}
+		return int64(p.BlockCount) * int64(size)
+	}
+	return 0
+}
+
+// userAgent tags stored results with the client's iperf3 version.
+func (p *params) userAgent() string {
+	if p.ClientVersion == "" {
+		return "iperf3"
+	}
+	return "iperf3/" + p.ClientVersion
+}
+
+// results is what each side sends the other during EXCHANGE_RESULTS.
+// The client refuses the message if any of these fields are missing.
+type results struct {
+	CPUUtilTotal         float64        `json:"cpu_util_total"`
+	CPUUtilUser          float64        `json:"cpu_util_user"`
+	CPUUtilSystem        float64        `json:"cpu_util_system"`
+	SenderHasRetransmits int            `json:"sender_has_retransmits"`
+	Streams              []streamResult `json:"streams"`
+}
+
+type streamResult struct {
+	ID             int     `json:"id"`
+	Bytes          int64   `json:"bytes"`
+	Retransmits    int     `json:"retransmits"`
+	Jitter         float64 `json:"jitter"`
+	Errors         int     `json:"errors"`
+	OmittedErrors  int     `json:"omitted_errors"`
+	Packets        int     `json:"packets"`
+	OmittedPackets int     `json:"omitted_packets"`
+	StartTime      float64 `json:"start_time"`
+	EndTime        float64 `json:"end_time"`
+}
+
+// stream is one data connection of a test.
+type stream struct {
+	id      int
+	seq     uint64
+	conn    net.Conn
+	bytes   atomic.Int64
+	omitted atomic.Int64 // Bytes moved during the omitted start
+}
+
+// transferred returns the bytes moved since the omitted start.
+func (st *stream) transferred() int64 {
+	return st.bytes.Load() - st.omitted.Load()
+}
+
+// test is one iperf3 run.
+type test struct {
+	params   params
+	incoming chan *stream
+	streams  []*stream
+}
+
+// addStream hands a data connection to the test, or closes it if the
+// test already has all its streams.
+func (t *test) addStream(conn net.Conn, seq uint64) {
+	select {
+	case t.incoming <- &stream{seq: seq, conn: conn}:
+	default:
+		conn.Close()
+	}
+}
+
+// collectStreams waits for the client to open its parallel streams.
+// The client opens them one after another, so accept order gives the
+// client's own stream numbering, which in iperf3 skips 2.
+func (t *test) collectStreams() bool {
+	timer := time.NewTimer(setupTimeout)
+	defer timer.Stop()
+
+	for range t.params.Parallel {
+		select {
+		case st := <-t.incoming:
+			t.streams = append(t.streams, st)
+		case <-timer.C:
+			return false
+		}
+	}
+
+	slices.SortFunc(t.streams, func(a, b *stream) int {
+		return cmp.Compare(a.seq, b.seq)
+	})
+	for i, st := range t.streams {
+		st.id = 1
+		if i > 0 {
+			st.id = i + 2
+		}
+	}
+	return true
+}
+
+// closeStreams closes every data connection, including any that arrived
+// after the test stopped waiting for them.
+func (t *test) closeStreams() {
+	for _, st := range t.streams {
+		st.conn.Close()
+	}
+	for {
+		select {
+		case st := <-t.incoming:
+			st.conn.Close()
+		default:
+			return
+		}
+	}
+}
+
+// check validates the client's parameters against what casspeed serves,
+// returning an iperf3 error number, or 0 if the test can run.
+func (s *Server) check(p *params) int32 {
+	if p.UDP || p.SCTP || p.Bidirectional {
+		return errUnimp
+	}
+	if p.Parallel < 1 {
+		p.Parallel = 1
+	}
+	if p.Parallel > s.cfg.Test.MaxThreads {
+		return errNumStreams
+	}
+	if p.Omit < 0 || p.Time < 0 || p.Time+p.Omit > s.cfg.Test.Timeout {
+		return errDuration
+	}
+	return 0
+}
+
+// runTest drives one test over its control connection, from parameter
+// exchange to the final results, and stores it if it completed.
+func (s *Server) runTest(control net.Conn, cookie string) {
+	t := &test{}
+
+	control.SetDeadline(time.Now().Add(setupTimeout))
+	if err := writeState(control, stateParamExchange); err != nil {
+		return
+	}
+	if err := readJSON(control, &t.params); err != nil {
+		return
+	}
+	if code := s.check(&t.params); code != 0 {
+		writeError(control, code)
+		return
+	}
+	p := &t.params
+
+	t.incoming = make(chan *stream, p.Parallel)
+	s.mu.Lock()
+	s.pending[cookie] = t
+	s.mu.Unlock()
+
+	ok := writeState(control, stateCreateStreams) == nil && t.collectStreams()
+
+	s.mu.Lock()
+	delete(s.pending, cookie)
+	s.mu.Unlock()
+	defer t.closeStreams()
+	if !ok {
+		return
+	}
+
+	if writeState(control, stateTestStart) != nil || writeState(control, stateTestRunning) != nil {
+		return
+	}
+	start := time.Now()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var wg sync.WaitGroup
+	var sent atomic.Int64
+	limit := p.byteLimit()
+	for _, st := range t.streams {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if p.Reverse {
+				s.payload.Stream(ctx, st.conn, 0, func(n int) {
+					st.bytes.Add(int64(n))
+					if limit > 0 && sent.Add(int64(n)) >= limit {
+						cancel()
+					}
+				})
+				return
+			}
+			receive(st)
+		}()
+	}
+
+	// Like iperf3, the server's figures leave out the omitted start
+	if p.Omit > 0 {
+		omitTimer := time.AfterFunc(time.Duration(p.Omit)*time.Second, func() {
+			for _, st := range t.streams {
+				st.omitted.Store(st.bytes.Load())
+			}
+		})
+		defer omitTimer.Stop()
+	}
+
+	// The client ends the test, whether it is timed or bounded by bytes
+	maxRun := time.Duration(p.Time+p.Omit) * time.Second
+	if p.Time == 0 {
+		maxRun = time.Duration(s.cfg.Test.Timeout) * time.Second
+	}
+	control.SetDeadline(start.Add(maxRun + endGrace))
+	state, err := readState(control)
+	end := time.Now()
+	if err != nil || state != stateTestEnd {
+		return
+	}
+
+	cancel()
+	for _, st := range t.streams {
+		if p.Reverse {
+			// Unblock writes the client is no longer reading
+			st.conn.SetWriteDeadline(time.Now())
+		} else {
+			// Take in what the client sent before TEST_END, then stop
+			// waiting for more
+			st.conn.SetReadDeadline(time.Now().Add(drainTimeout))
+		}
+	}
+	wg.Wait()
+
+	elapsed := end.Sub(start) - time.Duration(p.Omit)*time.Second
+	if elapsed <= 0 {
+		elapsed = end.Sub(start)
+	}
+	ours := results{Streams: make([]streamResult, len(t.streams))}
+	var total int64
+	for i, st := range t.streams {
+		bytes := st.transferred()
+		total += bytes
+		ours.Streams[i] = streamResult{
+			ID:        st.id,
+			Bytes:     bytes,
+			StartTime: 0,
+			EndTime:   elapsed.Seconds(),
+		}
+		if !p.Reverse {
+			// Retransmits are the sender's to report
+			ours.Streams[i].Retransmits = -1
+		}
+	}
+
+	control.SetDeadline(time.Now().Add(setupTimeout))
+	if writeState(control, stateExchangeResults) != nil {
+		return
+	}
+	var theirs results
+	if err := readJSON(control, &theirs); err != nil {
+		return
+	}
+	if writeJSON(control, ours) != nil || writeState(control, stateDisplayResults) != nil {
+		return
+	}
+	if state, err := readState(control); err != nil || state != stateIperfDone {
+		return
+	}
+
+	s.save(control, p, total, elapsed)
+}
+
+// receive counts what the client sends on a stream until the connection
+// closes.
+func receive(st *stream) {
+	buf := make([]byte, defaultLen)
+	for {
+		n, err := st.conn.Read(buf)
+		st.bytes.Add(int64(n))
+		if err != nil {
+			return
+		}
+	}
+}
+
+// save stores a completed test. Forward tests are the client's upload,
+// reverse ones its download.
+func (s *Server) save(control net.Conn, p *params, total int64, elapsed time.Duration) {
+	mbps := float64(total) * 8 / elapsed.Seconds() / 1_000_000
+	mbps = math.Round(mbps*100) / 100
+
+	duration := p.Time
+	if duration == 0 {
+		duration = int(elapsed.Round(time.Second) / time.Second)
+	}
+
+	addr := control.RemoteAddr().String()
+	test := &model.SpeedTest{
+		ID:           service.GenerateTestID(),
+		Status:       model.TestStatusComplete,
+		IPFamily:     service.IPFamily(addr),
+		Timestamp:    time.Now(),
+		Duration:     duration,
+		Streams:      p.Parallel,
+		ClientIPHash: service.HashIP(addr),
+		UserAgent:    p.userAgent(),
+		ServerID:     s.cfg.Server.Registry.ID,
+		CreatedAt:    time.Now(),
+	}
+	if p.Reverse {
+		test.DownloadMbps = mbps
+		test.Stages = service.StageDownload
+	} else {
+		test.UploadMbps = mbps
+		test.Stages = service.StageUpload
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	s.store.CreateSpeedTest(ctx, test)
+}
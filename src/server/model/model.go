@@ -20,21 +20,234 @@ type Device struct {
 }
 
 type SpeedTest struct {
-	ID           string    `json:"id"`
-	UserID       string    `json:"user_id,omitempty"`
-	DeviceID     string    `json:"device_id,omitempty"`
-	Timestamp    time.Time `json:"timestamp"`
-	DownloadMbps float64   `json:"download_mbps"`
-	UploadMbps   float64   `json:"upload_mbps"`
-	PingMs       float64   `json:"ping_ms"`
-	JitterMs     float64   `json:"jitter_ms"`
-	PacketLoss   float64   `json:"packet_loss"`
-	ClientIPHash string    `json:"-"`
-	UserAgent    string    `json:"user_agent"`
-	ServerID     string    `json:"server_id"`
-	ShareCode    string    `json:"share_code,omitempty"`
-	ShareViews   int       `json:"share_views"`
-	CreatedAt    time.Time `json:"created_at"`
+	ID                 string           `json:"id"`
+	Status             string           `json:"status"`
+	ParentID           string           `json:"parent_id,omitempty"`
+	IPFamily           string           `json:"ip_family,omitempty"`
+	UserID             string           `json:"user_id,omitempty"`
+	DeviceID           string           `json:"device_id,omitempty"`
+	Timestamp          time.Time        `json:"timestamp"`
+	DownloadMbps       float64          `json:"download_mbps"`
+	UploadMbps         float64          `json:"upload_mbps"`
+	PingMs             float64          `json:"ping_ms"`
+	JitterMs           float64          `json:"jitter_ms"`
+	PacketLoss         float64          `json:"packet_loss"`
+	PingDownloadMs     float64          `json:"ping_download_ms"`
+	PingUploadMs       float64          `json:"ping_upload_ms"`
+	Duration           int              `json:"duration"`
+	Streams            int              `json:"streams"`
+	Stages             string           `json:"stages"`
+	PayloadSize        int              `json:"payload_size"`
+	Adaptive           bool             `json:"adaptive"`
+	Transport          string           `json:"transport,omitempty"`
+	DownloadStopReason string           `json:"download_stop_reason,omitempty"`
+	UploadStopReason   string           `json:"upload_stop_reason,omitempty"`
+	DownloadStats      *ThroughputStats `json:"download_stats,omitempty"`
+	UploadStats        *ThroughputStats `json:"upload_stats,omitempty"`
+	DownloadTCP        *TCPStats        `json:"download_tcp,omitempty"`
+	UploadTCP          *TCPStats        `json:"upload_tcp,omitempty"`
+	UDP                *UDPStats        `json:"udp,omitempty"`
+	Setup              *SetupStats      `json:"setup,omitempty"`
+	ClientIPHash       string           `json:"-"`
+	UserAgent          string           `json:"user_agent"`
+	Source             string           `json:"source,omitempty"`
+	ServerID           string           `json:"server_id"`
+	ShareCode          string           `json:"share_code,omitempty"`
+	ShareViews         int              `json:"share_views"`
+	CreatedAt          time.Time        `json:"created_at"`
+}
+
+// SpeedTestDay sums up one day (UTC) of complete results from one user's
+// device against one server. Results pruned past the retention window are
+// added here first when rollups are on, so history outlives them.
+type SpeedTestDay struct {
+	Day      time.Time `json:"day"`
+	UserID   string    `json:"user_id,omitempty"`
+	DeviceID string    `json:"device_id,omitempty"`
+	ServerID string    `json:"server_id,omitempty"`
+	Tests    int       `json:"tests"`
+	Download Aggregate `json:"download_mbps"`
+	Upload   Aggregate `json:"upload_mbps"`
+	Ping     Aggregate `json:"ping_ms"`
+	Jitter   Aggregate `json:"jitter_ms"`
+}
+
+// Aggregate is the sum and range of one figure over a set of results; the
+// average is Sum over the number of results.
+type Aggregate struct {
+	Sum float64 `json:"sum"`
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
+
+// SpeedTestTotals sums up the complete results between two bounds of a
+// statistics request, starting at Start. Median and P95 come from the
+// results still stored; totals of daily aggregates leave them zero.
+type SpeedTestTotals struct {
+	Start    time.Time
+	Tests    int
+	Download FigureTotals
+	Upload   FigureTotals
+	Ping     FigureTotals
+	Jitter   FigureTotals
+}
+
+// FigureTotals is the sum and range of one figure over a set of results,
+// with its median and 95th percentile.
+type FigureTotals struct {
+	Aggregate
+	Median float64
+	P95    float64
+}
+
+// StatsBucket summarizes the complete results of one hour, day or week
+// (UTC), starting at Start. RolledUp counts those of Tests known only from
+// daily aggregates; they count towards Min and Avg but not Median and P95,
+// which are nil when every result is rolled up.
+type StatsBucket struct {
+	Start    time.Time   `json:"start"`
+	Tests    int         `json:"tests"`
+	RolledUp int         `json:"rolled_up,omitempty"`
+	Download MetricStats `json:"download_mbps"`
+	Upload   MetricStats `json:"upload_mbps"`
+	Ping     MetricStats `json:"ping_ms"`
+	Jitter   MetricStats `json:"jitter_ms"`
+}
+
+// MetricStats describes how one figure varied over a bucket's results.
+type MetricStats struct {
+	Min    float64  `json:"min"`
+	Avg    float64  `json:"avg"`
+	Median *float64 `json:"median"`
+	P95    *float64 `json:"p95"`
+}
+
+// ThroughputStats describes how throughput varied over a stage. Series
+// holds every sampled interval; the percentiles leave out the first
+// WarmupIntervals of them.
+type ThroughputStats struct {
+	P10             float64   `json:"p10_mbps"`
+	P50             float64   `json:"p50_mbps"`
+	P90             float64   `json:"p90_mbps"`
+	Max             float64   `json:"max_mbps"`
+	IntervalMs      int       `json:"interval_ms"`
+	WarmupIntervals int       `json:"warmup_intervals"`
+	Series          []float64 `json:"series_mbps"`
+}
+
+// TCPStats summarizes the kernel's TCP_INFO for the connections that
+// carried one throughput stage. Retransmits, congestion window, delivery
+// rate and the limited percentages describe the server's sending side,
+// so they are meaningful for downloads; uploads report the receive-side
+// RTT and out-of-order segments instead. Limit names what most likely
+// held the stage back.
+type TCPStats struct {
+	Limit            string           `json:"limit"`
+	Retransmits      uint32           `json:"retransmits"`
+	RetransmitPct    float64          `json:"retransmit_pct"`
+	OutOfOrder       uint32           `json:"out_of_order"`
+	RTTMs            float64          `json:"rtt_ms"`
+	DeliveryRateMbps float64          `json:"delivery_rate_mbps"`
+	RwndLimitedPct   float64          `json:"rwnd_limited_pct"`
+	Streams          []TCPStreamStats `json:"streams"`
+}
+
+// TCPStreamStats is the TCP_INFO summary for one measurement connection.
+// RTT, congestion window and delivery rate are averaged over the samples
+// taken during the stage; counters are the change over the stage.
+type TCPStreamStats struct {
+	Retransmits      uint32  `json:"retransmits"`
+	RetransmitPct    float64 `json:"retransmit_pct"`
+	OutOfOrder       uint32  `json:"out_of_order"`
+	RTTMs            float64 `json:"rtt_ms"`
+	MinRTTMs         float64 `json:"min_rtt_ms"`
+	Cwnd             uint32  `json:"cwnd"`
+	DeliveryRateMbps float64 `json:"delivery_rate_mbps"`
+	RwndLimitedPct   float64 `json:"rwnd_limited_pct"`
+	SndbufLimitedPct float64 `json:"sndbuf_limited_pct"`
+	Samples          int     `json:"samples"`
+}
+
+// UDPStats is what the server observed of the client's UDP datagrams:
+// one-way (client to server) loss, reordering, duplicates and RFC 3550
+// interarrival jitter. Sent is the number the client was asked to send.
+type UDPStats struct {
+	Sent       int     `json:"sent"`
+	Received   int     `json:"received"`
+	LossPct    float64 `json:"loss_pct"`
+	Reordered  int     `json:"reordered"`
+	Duplicates int     `json:"duplicates"`
+	JitterMs   float64 `json:"jitter_ms"`
+	Rate       int     `json:"rate"`
+	PacketSize int     `json:"packet_size"`
+}
+
+// SetupStats is what opening a fresh connection to the server cost, as
+// the client measured it: medians over Samples new connections, each
+// timed up to the first byte of a ping response. DNS is only reported by
+// clients that resolve the server's name themselves, TLS only when the
+// server speaks HTTPS.
+type SetupStats struct {
+	DNSMs     float64 `json:"dns_ms,omitempty"`
+	ConnectMs float64 `json:"connect_ms"`
+	TLSMs     float64 `json:"tls_ms,omitempty"`
+	TTFBMs    float64 `json:"ttfb_ms"`
+	Samples   int     `json:"samples"`
+}
+
+// What limited a throughput stage, from TCP_INFO
+const (
+	TCPLimitNetwork  = "network"  // Path capacity: no loss, no window limits
+	TCPLimitLoss     = "loss"     // Retransmits or out-of-order segments
+	TCPLimitReceiver = "receiver" // Client's receive window
+	TCPLimitSender   = "sender"   // Server's send buffer
+)
+
+// Test session statuses
+const (
+	TestStatusStarted  = "started"
+	TestStatusRunning  = "running"
+	TestStatusComplete = "complete"
+	TestStatusAborted  = "aborted"
+)
+
+// SourceLibreSpeed marks results a LibreSpeed client reported through
+// telemetry. Results the server measured itself have no source; those
+// with one are unverified and left out of statistics by default.
+const SourceLibreSpeed = "librespeed"
+
+// TestSession is the persisted record of a test run, created by
+// /speedtest/start and shared by the WebSocket run and the stored result.
+type TestSession struct {
+	ID            string    `json:"id"`
+	Status        string    `json:"status"`
+	ParentID      string    `json:"parent_id,omitempty"`
+	IPFamily      string    `json:"ip_family,omitempty"`
+	DualStack     bool      `json:"dual_stack,omitempty"`
+	Streams       int       `json:"streams"`
+	Duration      int       `json:"duration"`
+	Stages        string    `json:"stages"`
+	PayloadSize   int       `json:"payload_size"`
+	LoadedLatency bool      `json:"loaded_latency"`
+	Adaptive      bool      `json:"adaptive"`
+	Transport     string    `json:"transport,omitempty"`
+	Share         bool      `json:"share"`
+	ClientIPHash  string    `json:"-"`
+	CreatedAt     time.Time `json:"created_at"`
+	ExpiresAt     time.Time `json:"expires_at"`
+}
+
+// DualStackTest is a parent test that ran once over IPv4 and once over
+// IPv6. ClientFamily is the family the client used to start it; each
+// result's IPFamily is the one its run actually used.
+type DualStackTest struct {
+	ID           string         `json:"id"`
+	Status       string         `json:"status"`
+	DualStack    bool           `json:"dual_stack"`
+	ClientFamily string         `json:"client_family"`
+	Runs         []*TestSession `json:"runs"`
+	Results      []*SpeedTest   `json:"results"`
+	CreatedAt    time.Time      `json:"created_at"`
 }
 
 type APIToken struct {
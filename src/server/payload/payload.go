@@ -0,0 +1,141 @@
+// Package payload generates the bytes served to download tests.
+//
+// Filling every write from crypto/rand makes the server CPU the bottleneck
+// on fast links, so the default source hands out slices of a random pool
+// generated once at startup. Zero-fill and pattern payloads are for
+// checking whether a compressing middlebox sits on the path: if they come
+// out faster than random data, something along the way is compressing.
+package payload
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	mathrand "math/rand/v2"
+)
+
+// Payload kinds
+const (
+	KindRandom  = "random"  // Incompressible, sliced from a shared random pool
+	KindCrypto  = "crypto"  // Incompressible, fresh from crypto/rand for every write
+	KindZero    = "zero"    // All zero bytes
+	KindPattern = "pattern" // A short repeating text pattern
+)
+
+// Kinds lists the payload kinds New accepts.
+var Kinds = []string{KindRandom, KindCrypto, KindZero, KindPattern}
+
+const (
+	DefaultWriteSize = 1024 * 1024
+	DefaultPoolSize  = 8 * 1024 * 1024
+)
+
+// pattern is repeated to fill pattern payloads.
+const pattern = "casspeed payload pattern 0123456789 "
+
+var ErrUnknownKind = errors.New("unknown payload kind")
+
+// Generator writes payload of one kind in writeSize blocks. It is safe for
+// concurrent use; the shared buffers are never modified after New.
+type Generator struct {
+	kind      string
+	writeSize int
+	poolSize  int
+	buf       []byte // Pool (random), or one block (zero, pattern)
+}
+
+// New returns a generator of kind writing writeSize bytes at a time. For
+// KindRandom, poolSize bytes of random data are generated up front; the
+// pool should be well above writeSize so consecutive blocks differ.
+func New(kind string, writeSize, poolSize int) (*Generator, error) {
+	if writeSize <= 0 {
+		writeSize = DefaultWriteSize
+	}
+	if poolSize <= 0 {
+		poolSize = DefaultPoolSize
+	}
+
+	g := &Generator{kind: kind, writeSize: writeSize, poolSize: poolSize}
+	switch kind {
+	case KindRandom:
+		// The first block is repeated past the end so any block can be
+		// sliced out without wrapping
+		g.buf = make([]byte, poolSize+writeSize)
+		rand.Read(g.buf[:poolSize])
+		for copied := 0; copied < writeSize; {
+			copied += copy(g.buf[poolSize+copied:], g.buf[:poolSize])
+		}
+	case KindCrypto:
+	case KindZero:
+		g.buf = make([]byte, writeSize)
+	case KindPattern:
+		g.buf = make([]byte, writeSize)
+		for i := 0; i < writeSize; i += len(pattern) {
+			copy(g.buf[i:], pattern)
+		}
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKind, kind)
+	}
+	return g, nil
+}
+
+// Kind returns the generator's payload kind.
+func (g *Generator) Kind() string {
+	return g.kind
+}
+
+// WriteSize returns the bytes handed to each Write.
+func (g *Generator) WriteSize() int {
+	return g.writeSize
+}
+
+// Stream writes payload to w until limit bytes have been written (limit
+// <= 0 for no limit), ctx is done or a write fails, typically because the
+// client went away. onWrite, if set, is called after every write with the
+// bytes accepted. It returns the total written.
+func (g *Generator) Stream(ctx context.Context, w io.Writer, limit int64, onWrite func(n int)) (int64, error) {
+	var block []byte
+	if g.kind == KindCrypto {
+		block = make([]byte, g.writeSize)
+	}
+	// Each response starts at its own place in the pool
+	offset := 0
+	if g.kind == KindRandom {
+		offset = mathrand.IntN(g.poolSize)
+	}
+
+	var total int64
+	for limit <= 0 || total < limit {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+
+		size := g.writeSize
+		if limit > 0 && limit-total < int64(size) {
+			size = int(limit - total)
+		}
+
+		switch g.kind {
+		case KindRandom:
+			block = g.buf[offset : offset+size]
+			offset = (offset + size) % g.poolSize
+		case KindCrypto:
+			block = block[:size]
+			rand.Read(block)
+		default:
+			block = g.buf[:size]
+		}
+
+		n, err := w.Write(block)
+		total += int64(n)
+		if onWrite != nil {
+			onWrite(n)
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
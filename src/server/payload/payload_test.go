@@ -0,0 +1,45 @@
+package payload
+
+import (
+	"context"
+	"io"
+	"testing"
+)
+
+// BenchmarkStream compares the generators writing to io.Discard, so the
+// figures are the cost of producing the payload alone.
+func BenchmarkStream(b *testing.B) {
+	const size = 64 * 1024 * 1024
+
+	for _, kind := range Kinds {
+		b.Run(kind, func(b *testing.B) {
+			g, err := New(kind, DefaultWriteSize, DefaultPoolSize)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.SetBytes(size)
+			for b.Loop() {
+				if _, err := g.Stream(context.Background(), io.Discard, size, nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkLegacy is the per-request approach the generators replace:
+// crypto/rand into an 8KB buffer for every write.
+func BenchmarkLegacy(b *testing.B) {
+	const size = 64 * 1024 * 1024
+
+	g, err := New(KindCrypto, 8192, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(size)
+	for b.Loop() {
+		if _, err := g.Stream(context.Background(), io.Discard, size, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
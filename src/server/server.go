@@ -4,10 +4,10 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
-	"path/filepath"
 	"sync"
 	"syscall"
 	"time"
@@ -16,13 +16,16 @@ import (
 	"github.com/casapps/casspeed/src/config"
 	"github.com/casapps/casspeed/src/graphql"
 	"github.com/casapps/casspeed/src/mode"
+	"github.com/casapps/casspeed/src/scheduler"
 	"github.com/casapps/casspeed/src/server/handler"
+	"github.com/casapps/casspeed/src/server/iperf3"
 	"github.com/casapps/casspeed/src/server/service"
 	"github.com/casapps/casspeed/src/server/store"
 	"github.com/casapps/casspeed/src/swagger"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
+	"github.com/quic-go/quic-go/http3"
 )
 
 type Server struct {
@@ -30,8 +33,12 @@ type Server struct {
 	Mode         *mode.State
 	Router       *chi.Mux
 	HTTP         *http.Server
+	HTTP3        *http3.Server
+	Iperf3       *iperf3.Server
+	Scheduler    *scheduler.Scheduler
 	Store        store.Store
 	Handler      *handler.SpeedTestHandler
+	Service      *service.SpeedTestService
 	ImageHandler *handler.ShareImageHandler
 	UserHandler  *handler.UserHandler
 	AdminHandler *admin.Handler
@@ -39,6 +46,7 @@ type Server struct {
 	ipMutex      sync.RWMutex
 	startTime    time.Time
 	version      string
+	http3Conn    net.PacketConn
 }
 
 type ipRateLimit struct {
@@ -47,14 +55,13 @@ type ipRateLimit struct {
 }
 
 func New(cfg *config.Config, appMode *mode.State, dataDir string, version string) (*Server, error) {
-	dbPath := filepath.Join(dataDir, "db", "speedtest.db")
-	dbStore, err := store.NewSQLiteStore(dbPath)
+	dbStore, err := store.New(cfg.Server.Database, dataDir)
 	if err != nil {
 		return nil, fmt.Errorf("creating store: %w", err)
 	}
 
-	speedTestService := service.NewSpeedTestService(cfg.Test.MaxThreads, cfg.Test.ChunkSize)
-	speedTestHandler := handler.NewSpeedTestHandler(dbStore, speedTestService)
+	speedTestService := service.NewSpeedTestService(cfg.Test)
+	speedTestHandler := handler.NewSpeedTestHandler(dbStore, speedTestService, service.NewRegistry(cfg.Server.Registry))
 	imageHandler := handler.NewShareImageHandler(dbStore)
 	userHandler := handler.NewUserHandler(dbStore)
 	adminHandler := admin.NewHandler(dbStore)
@@ -65,6 +72,7 @@ func New(cfg *config.Config, appMode *mode.State, dataDir string, version string
 		Router:       chi.NewRouter(),
 		Store:        dbStore,
 		Handler:      speedTestHandler,
+		Service:      speedTestService,
 		ImageHandler: imageHandler,
 		UserHandler:  userHandler,
 		AdminHandler: adminHandler,
@@ -87,9 +95,9 @@ func (s *Server) setupMiddleware() {
 	s.Router.Use(s.rateLimitMiddleware)
 
 	if s.Mode.IsDevelopment() || s.Mode.IsDebug() {
-		s.Router.Use(middleware.Timeout(60 * time.Second))
+		s.Router.Use(timeoutMiddleware(60 * time.Second))
 	} else {
-		s.Router.Use(middleware.Timeout(30 * time.Second))
+		s.Router.Use(timeoutMiddleware(30 * time.Second))
 	}
 
 	corsHandler := cors.New(cors.Options{
@@ -110,14 +118,21 @@ func (s *Server) setupRoutes() {
 	s.Router.Route("/api/v1", func(r chi.Router) {
 		r.Get("/", s.handleAPIRoot)
 		r.Get("/healthz", s.handleHealth)
+		r.Get("/servers", s.Handler.ListServers)
 		
 		// Speed test endpoints
 		r.Post("/speedtest/start", s.Handler.StartTest)
 		r.Get("/speedtest/ws", s.Handler.TestStatus)
+		r.Get("/speedtest/events", s.Handler.TestEvents)
+		r.Post("/speedtest/pong", s.Handler.Pong)
+		r.Get("/speedtest/ping", s.Handler.Ping)
+		r.Post("/speedtest/setup", s.Handler.ReportSetup)
 		r.Get("/speedtest/download", s.Handler.Download)
 		r.Post("/speedtest/upload", s.Handler.Upload)
 		r.Get("/speedtest/result/{id}", s.Handler.GetResult)
+		r.Post("/speedtest/result/{id}", s.Handler.SubmitResult)
 		r.Get("/speedtest/history", s.Handler.GetHistory)
+		r.Get("/stats", s.Handler.Stats)
 
 		// User management endpoints
 		r.Post("/users/register", s.UserHandler.Register)
@@ -143,6 +158,16 @@ func (s *Server) setupRoutes() {
 	s.Router.Get("/admin/server/info", s.AdminHandler.RequireAuth(s.AdminHandler.ServerInfo))
 	s.Router.Get("/admin/server/logs", s.AdminHandler.RequireAuth(s.AdminHandler.ServerLogs))
 
+	// LibreSpeed clients, under the paths of its PHP and Go backends
+	for _, ext := range []string{".php", ""} {
+		s.Router.Get("/backend/garbage"+ext, s.Handler.LibreSpeedGarbage)
+		s.Router.Get("/backend/empty"+ext, s.Handler.LibreSpeedEmpty)
+		s.Router.Post("/backend/empty"+ext, s.Handler.LibreSpeedEmpty)
+		s.Router.Get("/backend/getIP"+ext, s.Handler.LibreSpeedGetIP)
+		s.Router.Post("/results/telemetry"+ext, s.Handler.LibreSpeedTelemetry)
+	}
+	s.Router.Get("/results/", s.Handler.LibreSpeedResult)
+
 	s.Router.Get("/share/{code}", s.Handler.GetShare)
 	s.Router.Get("/s/{code}", s.Handler.GetShare)
 	s.Router.Get("/share/{code}.png", s.ImageHandler.GetSharePNG)
@@ -228,16 +253,74 @@ func (s *Server) Start(address string, port int) error {
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  60 * time.Second,
+		ConnContext:  service.ConnContext,
 	}
 
-	fmt.Printf("│  🌐 HTTP   http://%s%s│\n", addr, padAddr(addr))
+	scheme := "http"
+	if sslConfig := s.Config.Server.SSL; sslConfig.Enabled {
+		tlsConfig, err := s.tlsConfig()
+		if err != nil {
+			return fmt.Errorf("loading TLS certificate: %w", err)
+		}
+		s.HTTP.TLSConfig = tlsConfig
+		s.Service.EnableTransport(service.TransportH2, port)
+		scheme = "https"
+
+		// "https://" is one byte longer than "http://"
+		fmt.Printf("│  🔒 HTTPS  https://%s%s│\n", addr, padAddr(addr+" "))
+		if sslConfig.HTTP3 {
+			http3Port := sslConfig.HTTP3Port
+			if http3Port == 0 {
+				http3Port = port
+			}
+			http3Addr := fmt.Sprintf("%s:%d", address, http3Port)
+			if err := s.listenHTTP3(http3Addr, tlsConfig); err != nil {
+				fmt.Printf("⚠️  HTTP/3 disabled: %v\n", err)
+			} else {
+				fmt.Printf("│  🚀 HTTP/3 https://%s%s│\n", http3Addr, padAddr(http3Addr+" "))
+			}
+		}
+	} else {
+		fmt.Printf("│  🌐 HTTP   http://%s%s│\n", addr, padAddr(addr))
+	}
+	if s.Config.Test.UDP {
+		udpPort := s.Config.Test.UDPPort
+		if udpPort == 0 {
+			udpPort = port
+		}
+		udpAddr := fmt.Sprintf("%s:%d", address, udpPort)
+		if err := s.Service.ListenUDP(udpAddr); err != nil {
+			fmt.Printf("⚠️  UDP responder disabled: %v\n", err)
+		} else {
+			// "udp://" is one byte shorter than "http://"
+			fmt.Printf("│  📶 UDP    udp://%s %s│\n", udpAddr, padAddr(udpAddr))
+		}
+	}
+	if s.Config.Test.Iperf3 {
+		iperfAddr := fmt.Sprintf("%s:%d", address, s.Config.Test.Iperf3Port)
+		s.Iperf3 = iperf3.New(s.Config, s.Store, s.Service.Payload())
+		if err := s.Iperf3.Listen(iperfAddr); err != nil {
+			s.Iperf3 = nil
+			fmt.Printf("⚠️  iperf3 server disabled: %v\n", err)
+		} else {
+			// "iperf3 tcp://" is one byte shorter than "HTTP   http://"
+			fmt.Printf("│  📏 iperf3 tcp://%s %s│\n", iperfAddr, padAddr(iperfAddr))
+		}
+	}
+	if err := s.startScheduler(); err != nil {
+		fmt.Printf("⚠️  Scheduler disabled: %v\n", err)
+	}
 	fmt.Println("├─────────────────────────────────────────────────────────────┤")
-	fmt.Printf("│  📡 Listening on http://%s%s│\n", addr, padAddr(addr))
+	fmt.Printf("│  📡 Listening on %s://%s%s│\n", scheme, addr, padAddr(scheme[4:]+addr))
 	fmt.Printf("│  ✅ Server started on %s%s│\n", time.Now().Format("Mon Jan 02, 2006 at 15:04:05 MST"), padTime())
 	fmt.Println("╰─────────────────────────────────────────────────────────────╯")
 
 	errChan := make(chan error, 1)
 	go func() {
+		if s.HTTP.TLSConfig != nil {
+			errChan <- s.HTTP.ListenAndServeTLS("", "")
+			return
+		}
 		errChan <- s.HTTP.ListenAndServe()
 	}()
 
@@ -258,6 +341,16 @@ func (s *Server) Shutdown() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
+	if s.Service != nil {
+		s.Service.CloseUDP()
+		s.closeHTTP3()
+	}
+	if s.Iperf3 != nil {
+		s.Iperf3.Close()
+	}
+	if s.Scheduler != nil {
+		s.Scheduler.Stop()
+	}
 	if s.Store != nil {
 		s.Store.Close()
 	}
@@ -287,14 +380,46 @@ func padTime() string {
 	return fmt.Sprintf("%*s", needed, "")
 }
 
+// timeoutMiddleware applies the request timeout, except to event streams,
+// which last as long as their test run.
+func timeoutMiddleware(timeout time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		timed := middleware.Timeout(timeout)(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/api/v1/speedtest/events" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			timed.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rateLimited reports whether requests to path start or store a test, and
+// so count against test.max_concurrent and test.min_interval. LibreSpeed
+// telemetry stores a result without any session, so it is spaced out like
+// /speedtest/start.
+func rateLimited(path string) bool {
+	switch path {
+	case "/api/v1/speedtest/ws", "/api/v1/speedtest/events", "/api/v1/speedtest/start",
+		"/results/telemetry", "/results/telemetry.php":
+		return true
+	}
+	return false
+}
+
 func (s *Server) rateLimitMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path != "/api/v1/speedtest/ws" && r.URL.Path != "/api/v1/speedtest/start" {
+		if !rateLimited(r.URL.Path) {
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		clientIP := r.RemoteAddr
+		// Keyed by host: each connection has its own port
+		clientIP, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			clientIP = r.RemoteAddr
+		}
 
 		s.ipMutex.Lock()
 		limit, exists := s.ipTestCount[clientIP]
@@ -310,8 +435,12 @@ func (s *Server) rateLimitMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
+		// A run attaching to a session from /start was already spaced out
+		// when the session was opened, possibly on the same connection
+		attaching := r.URL.Path != "/api/v1/speedtest/start" && r.URL.Query().Get("test_id") != ""
+
 		secondsSinceLastTest := time.Since(limit.lastTest).Seconds()
-		if secondsSinceLastTest < float64(s.Config.Test.MinInterval) {
+		if !attaching && secondsSinceLastTest < float64(s.Config.Test.MinInterval) {
 			retryAfter := int(float64(s.Config.Test.MinInterval) - secondsSinceLastTest)
 			s.ipMutex.Unlock()
 			w.Header().Set("Retry-After", fmt.Sprintf("%d", retryAfter))
@@ -320,7 +449,9 @@ func (s *Server) rateLimitMiddleware(next http.Handler) http.Handler {
 		}
 
 		limit.activeTests++
-		limit.lastTest = time.Now()
+		if !attaching {
+			limit.lastTest = time.Now()
+		}
 		s.ipMutex.Unlock()
 
 		defer func() {
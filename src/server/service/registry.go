@@ -0,0 +1,61 @@
+package service
+
+import (
+	"strings"
+
+	"github.com/casapps/casspeed/src/config"
+)
+
+// ServerInfo is one entry of the server registry. Self marks the server
+// answering; its URL is empty unless configured, meaning the URL the
+// client already uses.
+type ServerInfo struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Location string `json:"location,omitempty"`
+	URL      string `json:"url,omitempty"`
+	Self     bool   `json:"self,omitempty"`
+}
+
+// Registry lists this server and the peers configured alongside it.
+// Clients pick one by latency, or let the user choose, and run the whole
+// test against it; each server records its own ID on the results it
+// stores.
+type Registry struct {
+	servers []ServerInfo
+}
+
+// NewRegistry builds the registry from the server's configuration.
+func NewRegistry(cfg config.Registry) *Registry {
+	servers := []ServerInfo{{
+		ID:       cfg.ID,
+		Name:     cfg.Name,
+		Location: cfg.Location,
+		URL:      strings.TrimRight(cfg.URL, "/"),
+		Self:     true,
+	}}
+	for _, peer := range cfg.Peers {
+		servers = append(servers, ServerInfo{
+			ID:       peer.ID,
+			Name:     peer.Name,
+			Location: peer.Location,
+			URL:      strings.TrimRight(peer.URL, "/"),
+		})
+	}
+	for i := range servers {
+		if servers[i].Name == "" {
+			servers[i].Name = servers[i].ID
+		}
+	}
+	return &Registry{servers: servers}
+}
+
+// ID is this server's ID, as recorded on its results.
+func (r *Registry) ID() string {
+	return r.servers[0].ID
+}
+
+// Servers returns this server followed by its peers.
+func (r *Registry) Servers() []ServerInfo {
+	return r.servers
+}
@@ -0,0 +1,360 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/netip"
+	"sync/atomic"
+	"time"
+
+	"github.com/casapps/casspeed/src/server/model"
+)
+
+// IP families a test can run over
+const (
+	FamilyIPv4 = "ipv4"
+	FamilyIPv6 = "ipv6"
+)
+
+var (
+	ErrSessionNotFound   = errors.New("test session not found or expired")
+	ErrSessionInUse      = errors.New("test session already in use")
+	ErrResultSubmitted   = errors.New("result already submitted for this test")
+	ErrResultImplausible = errors.New("submitted result does not match server measurements")
+	ErrSessionDeadline   = errors.New("test session deadline passed")
+	ErrFamilyMismatch    = errors.New("test ran over the wrong IP family")
+)
+
+// resultTolerance is the relative slack allowed between client-reported
+// figures and the server's own counters (headers, buffering, clock skew).
+const resultTolerance = 0.10
+
+// TestSession counts the bytes actually moved over the data endpoints
+// for a single test run. Clients tag their download and upload requests
+// with the session ID so the server can attribute the traffic.
+type TestSession struct {
+	ID         string
+	ParentID   string // Dual-stack parent, if any
+	IPFamily   string // Family a dual-stack run is meant to use
+	Options    TestOptions
+	Share      bool
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	download   transferCounter
+	upload     transferCounter
+	claimed    atomic.Bool
+	claimedAt  atomic.Int64
+	timeout    time.Duration
+	transports transportSet
+
+	downloadTCP tcpTracker
+	uploadTCP   tcpTracker
+	udp         udpTracker
+	setup       setupReport
+}
+
+// Claim marks the session as taken by a run or a result submission. A
+// session can only be claimed once.
+func (t *TestSession) Claim() bool {
+	if !t.claimed.CompareAndSwap(false, true) {
+		return false
+	}
+	t.claimedAt.Store(time.Now().UnixNano())
+	return true
+}
+
+// Release undoes a Claim whose run never started, such as when the
+// WebSocket upgrade fails, so another run or a result can claim the
+// session.
+func (t *TestSession) Release() {
+	t.claimedAt.Store(0)
+	t.claimed.Store(false)
+}
+
+// Deadline is when the data endpoints stop serving the session: its
+// expiry until it is claimed, then one test timeout after the claim.
+func (t *TestSession) Deadline() time.Time {
+	if claimedAt := t.claimedAt.Load(); claimedAt != 0 {
+		return time.Unix(0, claimedAt).Add(t.timeout)
+	}
+	return t.ExpiresAt
+}
+
+func (t *TestSession) DownloadBytes() int64 {
+	return t.download.bytes.Load()
+}
+
+func (t *TestSession) UploadBytes() int64 {
+	return t.upload.bytes.Load()
+}
+
+// DownloadTCP summarizes TCP_INFO for the download connections, or
+// returns nil where it isn't available.
+func (t *TestSession) DownloadTCP() *model.TCPStats {
+	return t.downloadTCP.summary()
+}
+
+// UploadTCP summarizes TCP_INFO for the upload connections, or returns
+// nil where it isn't available.
+func (t *TestSession) UploadTCP() *model.TCPStats {
+	return t.uploadTCP.summary()
+}
+
+// expired reports whether the session timed out before being claimed.
+// A claimed session stays live until its run ends it.
+func (t *TestSession) expired(now time.Time) bool {
+	return !t.claimed.Load() && now.After(t.ExpiresAt)
+}
+
+// IPFamily returns the family of a "host:port" or bare address, or ""
+// if it isn't an IP. IPv4-mapped IPv6 addresses count as IPv4.
+func IPFamily(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return ""
+	}
+	if ip.Unmap().Is4() {
+		return FamilyIPv4
+	}
+	return FamilyIPv6
+}
+
+// transferCounter records how many bytes moved in one direction, when
+// the first and last of them did, and which IP families carried them.
+type transferCounter struct {
+	bytes    atomic.Int64
+	first    atomic.Int64
+	last     atomic.Int64
+	families atomic.Uint32
+}
+
+// Bits of transferCounter.families
+const (
+	familyBitIPv4 uint32 = 1 << iota
+	familyBitIPv6
+)
+
+// from records the family of a connection carrying the transfer.
+func (c *transferCounter) from(addr string) {
+	switch IPFamily(addr) {
+	case FamilyIPv4:
+		c.families.Or(familyBitIPv4)
+	case FamilyIPv6:
+		c.families.Or(familyBitIPv6)
+	}
+}
+
+func (c *transferCounter) add(n int) {
+	if n <= 0 {
+		return
+	}
+	now := time.Now().UnixNano()
+	c.first.CompareAndSwap(0, now)
+	c.last.Store(now)
+	c.bytes.Add(int64(n))
+}
+
+// window is the time between the first and last byte seen.
+func (c *transferCounter) window() time.Duration {
+	first := c.first.Load()
+	if first == 0 {
+		return 0
+	}
+	return time.Duration(c.last.Load() - first)
+}
+
+// TransferFamily is the family every download and upload connection of
+// the session used, or "" if none was seen or they used both.
+func (t *TestSession) TransferFamily() string {
+	switch t.download.families.Load() | t.upload.families.Load() {
+	case familyBitIPv4:
+		return FamilyIPv4
+	case familyBitIPv6:
+		return FamilyIPv6
+	}
+	return ""
+}
+
+// CheckFamily fails if a run pinned to an IP family moved data over
+// another one, since its result would then describe the wrong path.
+func (t *TestSession) CheckFamily() error {
+	if t.IPFamily == "" {
+		return nil
+	}
+	used := t.download.families.Load() | t.upload.families.Load()
+	if used == 0 || t.TransferFamily() == t.IPFamily {
+		return nil
+	}
+	return fmt.Errorf("%w: transfers did not run over %s only", ErrFamilyMismatch, t.IPFamily)
+}
+
+// ClientResult is what a client submits after running a client-driven
+// test against the data endpoints.
+type ClientResult struct {
+	DownloadMbps       float64 `json:"download_mbps"`
+	UploadMbps         float64 `json:"upload_mbps"`
+	PingMs             float64 `json:"ping_ms"`
+	JitterMs           float64 `json:"jitter_ms"`
+	PacketLoss         float64 `json:"packet_loss"`
+	DownloadBytes      int64   `json:"download_bytes"`
+	UploadBytes        int64   `json:"upload_bytes"`
+	DownloadDurationMs float64 `json:"download_duration_ms"`
+	UploadDurationMs   float64 `json:"upload_duration_ms"`
+
+	Setup *model.SetupStats `json:"setup,omitempty"`
+}
+
+// NewSession registers a new test session whose byte counters are fed
+// by the download and upload endpoints.
+func (s *SpeedTestService) NewSession(opts TestOptions, share bool) *TestSession {
+	session := s.newSession(opts, share, time.Now())
+	s.register(session)
+	return session
+}
+
+// NewDualStackSessions registers one session per IP family under a new
+// parent ID. The client runs them one after the other, so each stays
+// claimable for one more timeout than the one before it.
+func (s *SpeedTestService) NewDualStackSessions(opts TestOptions, share bool) (string, []*TestSession) {
+	parentID := GenerateTestID()
+	now := time.Now()
+
+	var sessions []*TestSession
+	for i, family := range []string{FamilyIPv4, FamilyIPv6} {
+		session := s.newSession(opts, share, now)
+		session.ParentID = parentID
+		session.IPFamily = family
+		session.ExpiresAt = session.ExpiresAt.Add(time.Duration(i*s.cfg.Timeout) * time.Second)
+		s.register(session)
+		sessions = append(sessions, session)
+	}
+	return parentID, sessions
+}
+
+func (s *SpeedTestService) newSession(opts TestOptions, share bool, now time.Time) *TestSession {
+	session := &TestSession{
+		ID:        GenerateTestID(),
+		Options:   opts,
+		Share:     share,
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Duration(s.cfg.Timeout) * time.Second),
+		timeout:   time.Duration(s.cfg.Timeout) * time.Second,
+	}
+	session.uploadTCP.receive = true
+	if opts.Runs(StageUDP) {
+		session.udp.rate = s.cfg.UDPRate
+		session.udp.packetSize = s.cfg.UDPPacketSize
+		session.udp.expected = opts.Duration * s.cfg.UDPRate
+	}
+	if opts.Runs(StageSetup) {
+		session.setup.stats = make(chan *model.SetupStats, 1)
+	}
+	return session
+}
+
+// register makes a session visible to the data endpoints, dropping any
+// that expired unclaimed.
+func (s *SpeedTestService) register(session *TestSession) {
+	now := time.Now()
+	s.mu.Lock()
+	for id, existing := range s.sessions {
+		if existing.expired(now) {
+			delete(s.sessions, id)
+		}
+	}
+	s.sessions[session.ID] = session
+	s.mu.Unlock()
+}
+
+// Session returns the active session with the given ID, or nil.
+func (s *SpeedTestService) Session(id string) *TestSession {
+	if id == "" {
+		return nil
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	session := s.sessions[id]
+	if session == nil || session.expired(time.Now()) {
+		return nil
+	}
+	return session
+}
+
+// EndSession stops attributing traffic to the session.
+func (s *SpeedTestService) EndSession(id string) {
+	s.mu.Lock()
+	delete(s.sessions, id)
+	s.mu.Unlock()
+}
+
+// VerifyResult cross-checks a client-submitted result against the bytes
+// the server itself moved for the session. A result can only be accepted
+// once per session, and not for sessions a WebSocket run has claimed;
+// runs of a dual-stack test must have moved their data over their family.
+func (s *SpeedTestService) VerifyResult(session *TestSession, res *ClientResult) error {
+	if err := session.CheckFamily(); err != nil {
+		return err
+	}
+	if err := checkTransfer("download", &session.download, res.DownloadMbps, res.DownloadBytes, res.DownloadDurationMs); err != nil {
+		return err
+	}
+	if err := checkTransfer("upload", &session.upload, res.UploadMbps, res.UploadBytes, res.UploadDurationMs); err != nil {
+		return err
+	}
+	if res.PacketLoss < 0 || res.PacketLoss > 100 || res.PingMs < 0 || res.JitterMs < 0 {
+		return fmt.Errorf("%w: latency figures out of range", ErrResultImplausible)
+	}
+	if res.Setup != nil && session.Options.Runs(StageSetup) {
+		if err := checkSetup(res.Setup); err != nil {
+			return err
+		}
+	}
+
+	if !session.Claim() {
+		return ErrResultSubmitted
+	}
+	return nil
+}
+
+// checkTransfer accepts a reported rate only if the bytes behind it were
+// actually moved by the server, and the rate is no faster than the server
+// saw them move, over the window from their first byte to their last. The
+// client's duration need only match its rate: it takes in request
+// latency, so it can run longer than the window, but it can't make the
+// rate exceed what the server observed.
+func checkTransfer(stage string, counter *transferCounter, mbps float64, bytes int64, durationMs float64) error {
+	if mbps == 0 && bytes == 0 {
+		return nil
+	}
+	if mbps < 0 || bytes < 0 || durationMs <= 0 {
+		return fmt.Errorf("%w: invalid %s figures", ErrResultImplausible, stage)
+	}
+
+	serverBytes := float64(counter.bytes.Load())
+	if float64(bytes) > serverBytes*(1+resultTolerance) {
+		return fmt.Errorf("%w: %s bytes %d exceed the %d the server moved", ErrResultImplausible, stage, bytes, int64(serverBytes))
+	}
+
+	window := counter.window()
+	if window <= 0 {
+		return fmt.Errorf("%w: %s transfer too short to verify", ErrResultImplausible, stage)
+	}
+	serverMbps := serverBytes * 8 / window.Seconds() / 1_000_000
+	if mbps > serverMbps*(1+resultTolerance) {
+		return fmt.Errorf("%w: %s rate %.1f Mbps exceeds the %.1f Mbps the server measured", ErrResultImplausible, stage, mbps, serverMbps)
+	}
+
+	implied := float64(bytes) * 8 / (durationMs / 1000) / 1_000_000
+	if math.Abs(mbps-implied) > implied*resultTolerance {
+		return fmt.Errorf("%w: %s rate %.1f Mbps does not match %.1f Mbps from bytes and duration", ErrResultImplausible, stage, mbps, implied)
+	}
+
+	return nil
+}
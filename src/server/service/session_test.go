@@ -0,0 +1,84 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// movedOver is a counter that saw bytes move over window.
+func movedOver(bytes int64, window time.Duration) *transferCounter {
+	c := &transferCounter{}
+	c.bytes.Store(bytes)
+	c.first.Store(1)
+	c.last.Store(1 + int64(window))
+	return c
+}
+
+func TestCheckTransfer(t *testing.T) {
+	// The server moved 100MB in 10s: 80 Mbps
+	server := movedOver(100_000_000, 10*time.Second)
+
+	for _, tt := range []struct {
+		name       string
+		counter    *transferCounter
+		mbps       float64
+		bytes      int64
+		durationMs float64
+		ok         bool
+	}{
+		{"stage not run", &transferCounter{}, 0, 0, 0, true},
+		{"as measured", server, 80, 100_000_000, 10_000, true},
+		{"longer than the window", server, 72.7, 100_000_000, 11_000, true},
+		{"within tolerance", server, 87.9, 109_875_000, 10_000, true},
+		{"faster than measured", server, 88.9, 100_000_000, 9_000, false},
+		{"half the window", server, 176, 110_000_000, 5_000, false},
+		{"more bytes than moved", server, 80, 111_000_000, 11_100, false},
+		{"rate off its own figures", server, 60, 100_000_000, 10_000, false},
+		{"no duration", server, 80, 100_000_000, 0, false},
+		{"negative rate", server, -1, 100_000_000, 10_000, false},
+		{"nothing moved", &transferCounter{}, 80, 100_000_000, 10_000, false},
+		{"no window", movedOver(100_000, 0), 80, 100_000, 10, false},
+	} {
+		err := checkTransfer("download", tt.counter, tt.mbps, tt.bytes, tt.durationMs)
+		if tt.ok && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if !tt.ok && !errors.Is(err, ErrResultImplausible) {
+			t.Errorf("%s: got %v, want ErrResultImplausible", tt.name, err)
+		}
+	}
+}
+
+func TestCheckFamily(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		pinned   string
+		download string
+		upload   string
+		family   string
+		ok       bool
+	}{
+		{"single-stack run", "", "192.0.2.1:1000", "[2001:db8::1]:1000", "", true},
+		{"as pinned", FamilyIPv6, "[2001:db8::1]:1000", "[2001:db8::1]:1001", FamilyIPv6, true},
+		{"mapped IPv4", FamilyIPv4, "[::ffff:192.0.2.1]:1000", "192.0.2.1:1001", FamilyIPv4, true},
+		{"nothing moved", FamilyIPv4, "", "", "", true},
+		{"other family", FamilyIPv6, "192.0.2.1:1000", "192.0.2.1:1001", FamilyIPv4, false},
+		{"upload over the other family", FamilyIPv4, "192.0.2.1:1000", "[2001:db8::1]:1001", "", false},
+	} {
+		session := &TestSession{IPFamily: tt.pinned}
+		session.download.from(tt.download)
+		session.upload.from(tt.upload)
+
+		if got := session.TransferFamily(); got != tt.family {
+			t.Errorf("%s: TransferFamily() = %q, want %q", tt.name, got, tt.family)
+		}
+		err := session.CheckFamily()
+		if tt.ok && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if !tt.ok && !errors.Is(err, ErrFamilyMismatch) {
+			t.Errorf("%s: got %v, want ErrFamilyMismatch", tt.name, err)
+		}
+	}
+}
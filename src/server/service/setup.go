@@ -0,0 +1,101 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/casapps/casspeed/src/server/model"
+)
+
+// The server can't see a client's DNS lookups or handshakes from its
+// side, so the setup stage is measured by the client: it opens fresh
+// connections to the ping endpoint, times them, and reports the medians.
+// Server-driven runs wait for that report; client-driven ones submit it
+// with the result.
+
+const (
+	// setupWait is how long a server-driven run waits for the client's
+	// setup figures before moving on without them.
+	setupWait = 20 * time.Second
+
+	MaxSetupSamples = 20
+	maxSetupMs      = 30_000
+)
+
+var (
+	ErrSetupNotRunning = errors.New("test session does not run the setup stage")
+	ErrSetupReported   = errors.New("setup figures already reported for this test")
+)
+
+// setupReport carries the client's setup figures to the running stage.
+type setupReport struct {
+	stats    chan *model.SetupStats
+	reported atomic.Bool
+}
+
+// ReportSetup hands the client's connection setup figures to the
+// session's setup stage. A report without samples says the client
+// couldn't measure, and ends the stage without figures. Only the first
+// report is accepted.
+func (t *TestSession) ReportSetup(stats *model.SetupStats) error {
+	if t.setup.stats == nil {
+		return ErrSetupNotRunning
+	}
+	if stats.Samples == 0 {
+		stats = nil
+	} else if err := checkSetup(stats); err != nil {
+		return err
+	}
+	if !t.setup.reported.CompareAndSwap(false, true) {
+		return ErrSetupReported
+	}
+	t.setup.stats <- stats
+	return nil
+}
+
+// checkSetup rejects setup figures that can't be real timings.
+func checkSetup(stats *model.SetupStats) error {
+	if stats.Samples < 1 || stats.Samples > MaxSetupSamples {
+		return fmt.Errorf("%w: setup samples must be 1 to %d", ErrResultImplausible, MaxSetupSamples)
+	}
+	for _, ms := range []float64{stats.DNSMs, stats.ConnectMs, stats.TLSMs, stats.TTFBMs} {
+		if ms < 0 || ms > maxSetupMs || math.IsNaN(ms) {
+			return fmt.Errorf("%w: setup timings out of range", ErrResultImplausible)
+		}
+	}
+	return nil
+}
+
+// runSetupStage asks the client to time fresh connections and waits for
+// its report, returning nil if none arrives in time.
+func (s *SpeedTestService) runSetupStage(ctx context.Context, session *TestSession, progressChan chan<- ProgressUpdate) *model.SetupStats {
+	send(ctx, progressChan, ProgressUpdate{Stage: StageSetup, Progress: 0, Message: "Starting connection setup test", TestID: session.ID})
+
+	timer := time.NewTimer(setupWait)
+	defer timer.Stop()
+
+	var stats *model.SetupStats
+	select {
+	case stats = <-session.setup.stats:
+	case <-timer.C:
+	case <-ctx.Done():
+		return nil
+	}
+
+	if stats == nil {
+		send(ctx, progressChan, ProgressUpdate{Stage: StageSetup, Progress: 1.0, Message: "No connection setup figures reported"})
+		return nil
+	}
+	send(ctx, progressChan, ProgressUpdate{
+		Stage:    StageSetup,
+		Progress: 1.0,
+		Speed:    stats.ConnectMs,
+		Message:  "Connection setup test complete",
+		Setup:    stats,
+	})
+	return stats
+}
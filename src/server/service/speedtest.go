@@ -1,90 +1,518 @@
 package service
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"math"
+	"net"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/casapps/casspeed/src/config"
+	"github.com/casapps/casspeed/src/server/model"
+	"github.com/casapps/casspeed/src/server/payload"
 	"github.com/google/uuid"
 )
 
 type SpeedTestService struct {
-	maxThreads int
-	chunkSize  int
+	cfg        config.TestConfig
+	sessions   map[string]*TestSession
+	udpConn    net.PacketConn
+	transports map[string]int // Enabled transports beyond HTTP/1.1, by port
+	payload    *payload.Generator
+	mu         sync.RWMutex
 }
 
-func NewSpeedTestService(maxThreads, chunkSize int) *SpeedTestService {
+func NewSpeedTestService(cfg config.TestConfig) *SpeedTestService {
+	gen, err := payload.New(cfg.Payload, cfg.ChunkSize, cfg.PayloadPool)
+	if err != nil {
+		// Config validation rejects unknown kinds; fall back regardless
+		gen, _ = payload.New(payload.KindRandom, cfg.ChunkSize, cfg.PayloadPool)
+	}
 	return &SpeedTestService{
-		maxThreads: maxThreads,
-		chunkSize:  chunkSize,
+		cfg:      cfg,
+		sessions: make(map[string]*TestSession),
+		payload:  gen,
+	}
+}
+
+// Payload returns the generator download data is served from.
+func (s *SpeedTestService) Payload() *payload.Generator {
+	return s.payload
+}
+
+// Test stages, in the order they run
+const (
+	StagePing     = "ping"
+	StageSetup    = "setup"
+	StageUDP      = "udp"
+	StageDownload = "download"
+	StageUpload   = "upload"
+)
+
+var allStages = []string{StagePing, StageSetup, StageUDP, StageDownload, StageUpload}
+
+// defaultStages leaves out the optional setup and UDP stages; browsers
+// can't run the UDP one.
+var defaultStages = []string{StagePing, StageDownload, StageUpload}
+
+// Payload size bounds for a single download response or upload request
+const (
+	MinPayloadSize     = 64 * 1024
+	MaxPayloadSize     = 100 * 1024 * 1024
+	DefaultPayloadSize = 10 * 1024 * 1024
+)
+
+var ErrInvalidStage = errors.New("invalid test stage")
+
+// TestOptions controls how a single test run is performed.
+type TestOptions struct {
+	Duration      int      // Seconds per throughput stage
+	Streams       int      // Parallel transfers per throughput stage
+	Stages        []string // Stages to run, in run order
+	PayloadSize   int      // Bytes per download response and upload request
+	LoadedLatency bool     // Keep probing latency during download and upload
+	Adaptive      bool     // Ramp streams and end stages once throughput is stable
+	DualStack     bool     // Run once over IPv4 and once over IPv6
+	Transport     string   // HTTP transport the data endpoints must use ("" for any)
+}
+
+// Runs reports whether the options include the given stage.
+func (o TestOptions) Runs(stage string) bool {
+	for _, s := range o.Stages {
+		if s == stage {
+			return true
+		}
+	}
+	return false
+}
+
+// StageList returns the stages as a comma-separated list.
+func (o TestOptions) StageList() string {
+	return strings.Join(o.Stages, ",")
+}
+
+// TestParams are the run parameters a client may request. Zero values
+// fall back to the server defaults.
+type TestParams struct {
+	Duration      int    `json:"duration"`
+	Streams       int    `json:"streams"`
+	Stages        string `json:"stages"`
+	PayloadSize   int    `json:"payload_size"`
+	LoadedLatency *bool  `json:"loaded_latency"`
+	Adaptive      *bool  `json:"adaptive"`
+	DualStack     *bool  `json:"dual_stack"`
+	Transport     string `json:"transport"`
+}
+
+// DefaultOptions returns the run options configured for the server.
+func (s *SpeedTestService) DefaultOptions() TestOptions {
+	return TestOptions{
+		Duration:      s.cfg.DefaultDuration,
+		Streams:       s.cfg.MaxThreads,
+		Stages:        defaultStages,
+		PayloadSize:   DefaultPayloadSize,
+		LoadedLatency: s.cfg.LoadedLatency,
+		Adaptive:      s.cfg.Adaptive,
+	}
+}
+
+// SaveAborted reports whether cancelled tests are stored, marked aborted.
+func (s *SpeedTestService) SaveAborted() bool {
+	return s.cfg.SaveAborted
+}
+
+// UnverifiedStats reports whether statistics count results the client
+// reported rather than the server measured.
+func (s *SpeedTestService) UnverifiedStats() bool {
+	return s.cfg.UnverifiedStats
+}
+
+// Options validates requested parameters and clamps them to the server's
+// limits: at most MaxThreads streams, and throughput stages that together
+// fit within the test timeout.
+func (s *SpeedTestService) Options(p TestParams) (TestOptions, error) {
+	opts := s.DefaultOptions()
+
+	if p.Stages != "" {
+		requested := make(map[string]bool)
+		for _, stage := range strings.Split(p.Stages, ",") {
+			stage = strings.ToLower(strings.TrimSpace(stage))
+			if stage == "" {
+				continue
+			}
+			if !slices.Contains(allStages, stage) {
+				return opts, fmt.Errorf("%w: %q", ErrInvalidStage, stage)
+			}
+			if stage == StageUDP && !s.udpEnabled() {
+				return opts, fmt.Errorf("%w: %w", ErrInvalidStage, ErrUDPDisabled)
+			}
+			requested[stage] = true
+		}
+		if len(requested) == 0 {
+			return opts, fmt.Errorf("%w: no stages selected", ErrInvalidStage)
+		}
+		opts.Stages = nil
+		for _, stage := range allStages {
+			if requested[stage] {
+				opts.Stages = append(opts.Stages, stage)
+			}
+		}
+	}
+
+	if p.Duration != 0 {
+		opts.Duration = p.Duration
+	}
+	opts.Duration = clamp(opts.Duration, 1, s.maxStageDuration(opts))
+
+	if p.Streams != 0 {
+		opts.Streams = clamp(p.Streams, 1, s.cfg.MaxThreads)
+	}
+	if p.PayloadSize != 0 {
+		opts.PayloadSize = clamp(p.PayloadSize, MinPayloadSize, MaxPayloadSize)
+	}
+	if p.LoadedLatency != nil {
+		opts.LoadedLatency = *p.LoadedLatency
+	}
+	if p.Adaptive != nil {
+		opts.Adaptive = *p.Adaptive
 	}
+	if p.DualStack != nil {
+		opts.DualStack = *p.DualStack
+	}
+	transport, err := s.parseTransport(strings.ToLower(strings.TrimSpace(p.Transport)))
+	if err != nil {
+		return opts, err
+	}
+	opts.Transport = transport
+
+	return opts, nil
+}
+
+// maxStageDuration is the longest a throughput stage may run so that all
+// of a test's throughput stages fit within the test timeout.
+func (s *SpeedTestService) maxStageDuration(opts TestOptions) int {
+	throughputStages := 0
+	for _, stage := range opts.Stages {
+		if stage != StagePing && stage != StageSetup {
+			throughputStages++
+		}
+	}
+	if throughputStages == 0 {
+		return s.cfg.Timeout
+	}
+	return s.cfg.Timeout / throughputStages
+}
+
+func clamp(v, lo, hi int) int {
+	return max(lo, min(v, hi))
 }
 
 type TestResult struct {
-	DownloadMbps float64
-	UploadMbps   float64
-	PingMs       float64
-	JitterMs     float64
-	PacketLoss   float64
+	DownloadMbps   float64
+	UploadMbps     float64
+	PingMs         float64
+	JitterMs       float64
+	PacketLoss     float64
+	PingDownloadMs float64
+	PingUploadMs   float64
+
+	DownloadStopReason string
+	UploadStopReason   string
+
+	DownloadStats *model.ThroughputStats
+	UploadStats   *model.ThroughputStats
+
+	DownloadTCP *model.TCPStats
+	UploadTCP   *model.TCPStats
+
+	UDP *model.UDPStats
+
+	Setup *model.SetupStats
 }
 
+// Why a throughput stage ended
+const (
+	StopDuration  = "duration"  // Fixed duration elapsed
+	StopStable    = "stable"    // Adaptive: throughput stabilized
+	StopTimeout   = "timeout"   // Adaptive: time bound reached before stabilizing
+	StopCancelled = "cancelled" // Client went away
+)
+
 type ProgressUpdate struct {
-	Stage    string  `json:"stage"`
-	Progress float64 `json:"progress"`
-	Speed    float64 `json:"speed"`
-	Message  string  `json:"message"`
+	Stage     string            `json:"stage"`
+	Progress  float64           `json:"progress"`
+	Speed     float64           `json:"speed"`
+	Message   string            `json:"message"`
+	TestID    string            `json:"test_id,omitempty"`
+	ShareCode string            `json:"share_code,omitempty"`
+	Streams   int               `json:"streams,omitempty"`
+	Seq       int               `json:"seq,omitempty"`
+	RTT       float64           `json:"rtt_ms,omitempty"`
+	Lost      bool              `json:"lost,omitempty"`
+	UDP       *UDPPlan          `json:"udp,omitempty"`
+	Setup     *model.SetupStats `json:"setup,omitempty"`
 }
 
-func (s *SpeedTestService) RunTest(duration int, progressChan chan<- ProgressUpdate) (*TestResult, error) {
+// Pinger sends a single timestamped latency probe to the client over the
+// control channel and returns the round-trip time once the echo arrives.
+type Pinger interface {
+	Ping(seq int, timeout time.Duration) (time.Duration, error)
+}
+
+// RunTest runs the session's stages in order. When ctx is cancelled the
+// current stage stops, the remaining ones are skipped and ctx's error is
+// returned along with whatever was measured so far. Progress updates are
+// dropped rather than blocking once ctx is done.
+func (s *SpeedTestService) RunTest(ctx context.Context, session *TestSession, pinger Pinger, progressChan chan<- ProgressUpdate) (*TestResult, error) {
 	result := &TestResult{}
+	opts := session.Options
+
+	if opts.Runs(StagePing) {
+		send(ctx, progressChan, ProgressUpdate{Stage: StagePing, Progress: 0, Message: "Starting ping test", TestID: session.ID})
+		ping, jitter, loss := s.testPing(ctx, pinger, progressChan)
+		result.PingMs = ping
+		result.JitterMs = jitter
+		result.PacketLoss = loss
+		send(ctx, progressChan, ProgressUpdate{Stage: StagePing, Progress: 1.0, Speed: ping, Message: "Ping test complete"})
+	}
+
+	if opts.Runs(StageSetup) && ctx.Err() == nil {
+		result.Setup = s.runSetupStage(ctx, session, progressChan)
+	}
+
+	if opts.Runs(StageUDP) && ctx.Err() == nil {
+		result.UDP = s.runUDPStage(ctx, session, progressChan)
+	}
+
+	if opts.Runs(StageDownload) && ctx.Err() == nil {
+		stage := s.runThroughputStage(ctx, session, StageDownload, session.DownloadBytes, pinger, 1000, progressChan)
+		result.DownloadMbps = stage.mbps
+		result.PingDownloadMs = stage.loadedMs
+		result.DownloadStopReason = stage.stopReason
+		result.DownloadStats = stage.stats
+		result.DownloadTCP = session.DownloadTCP()
+	}
+
+	if opts.Runs(StageUpload) && ctx.Err() == nil {
+		stage := s.runThroughputStage(ctx, session, StageUpload, session.UploadBytes, pinger, 2000, progressChan)
+		result.UploadMbps = stage.mbps
+		result.PingUploadMs = stage.loadedMs
+		result.UploadStopReason = stage.stopReason
+		result.UploadStats = stage.stats
+		result.UploadTCP = session.UploadTCP()
+	}
+
+	return result, ctx.Err()
+}
+
+// send delivers a progress update unless ctx is done first, and reports
+// whether it was delivered.
+func send(ctx context.Context, progressChan chan<- ProgressUpdate, update ProgressUpdate) bool {
+	select {
+	case progressChan <- update:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// stageResult is the outcome of one throughput stage.
+type stageResult struct {
+	mbps       float64
+	loadedMs   float64
+	stopReason string
+	stats      *model.ThroughputStats
+}
+
+// runThroughputStage runs one download or upload stage, probing loaded
+// latency alongside it if enabled.
+func (s *SpeedTestService) runThroughputStage(ctx context.Context, session *TestSession, stage string, counter func() int64, pinger Pinger, firstSeq int, progressChan chan<- ProgressUpdate) stageResult {
+	opts := session.Options
+	streams := opts.Streams
+	if opts.Adaptive {
+		streams = 1
+	}
+	send(ctx, progressChan, ProgressUpdate{Stage: stage, Progress: 0, Message: fmt.Sprintf("Starting %s test", stage), TestID: session.ID, Streams: streams})
+
+	var probe *loadedProbe
+	if opts.LoadedLatency {
+		probe = startLoadedProbe(pinger, firstSeq)
+	}
+	result := s.measureThroughput(ctx, stage, opts, streams, counter, probe, progressChan)
+	if probe != nil {
+		result.loadedMs = probe.stop()
+	}
+
+	send(ctx, progressChan, ProgressUpdate{Stage: stage, Progress: 1.0, Speed: result.mbps, Message: fmt.Sprintf("%s test complete", strings.ToUpper(stage[:1])+stage[1:])})
+	return result
+}
+
+// loadedProbe keeps sending latency probes while a throughput stage
+// saturates the link, so bufferbloat shows up as increased RTT.
+type loadedProbe struct {
+	done chan struct{}
+	wg   sync.WaitGroup
+	mu   sync.Mutex
+	rtts []float64
+}
+
+func startLoadedProbe(pinger Pinger, firstSeq int) *loadedProbe {
+	p := &loadedProbe{done: make(chan struct{})}
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(250 * time.Millisecond)
+		defer ticker.Stop()
+
+		for seq := firstSeq; ; seq++ {
+			if rtt, err := pinger.Ping(seq, 2*time.Second); err == nil {
+				p.mu.Lock()
+				p.rtts = append(p.rtts, float64(rtt.Microseconds())/1000)
+				p.mu.Unlock()
+			}
+			select {
+			case <-p.done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return p
+}
 
-	progressChan <- ProgressUpdate{Stage: "ping", Progress: 0, Message: "Starting ping test"}
-	ping, jitter, loss := s.testPing()
-	result.PingMs = ping
-	result.JitterMs = jitter
-	result.PacketLoss = loss
-	progressChan <- ProgressUpdate{Stage: "ping", Progress: 1.0, Speed: ping, Message: "Ping test complete"}
+// last returns the most recent loaded RTT sample, or 0.
+func (p *loadedProbe) last() float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.rtts) == 0 {
+		return 0
+	}
+	return p.rtts[len(p.rtts)-1]
+}
 
-	progressChan <- ProgressUpdate{Stage: "download", Progress: 0, Message: "Starting download test"}
-	downloadSpeed := s.testDownload(duration, progressChan)
-	result.DownloadMbps = downloadSpeed
-	progressChan <- ProgressUpdate{Stage: "download", Progress: 1.0, Speed: downloadSpeed, Message: "Download test complete"}
+// stop ends probing and returns the mean loaded RTT in milliseconds.
+func (p *loadedProbe) stop() float64 {
+	close(p.done)
+	p.wg.Wait()
 
-	progressChan <- ProgressUpdate{Stage: "upload", Progress: 0, Message: "Starting upload test"}
-	uploadSpeed := s.testUpload(duration, progressChan)
-	result.UploadMbps = uploadSpeed
-	progressChan <- ProgressUpdate{Stage: "upload", Progress: 1.0, Speed: uploadSpeed, Message: "Upload test complete"}
+	if len(p.rtts) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, rtt := range p.rtts {
+		sum += rtt
+	}
+	return sum / float64(len(p.rtts))
+}
 
-	progressChan <- ProgressUpdate{Stage: "complete", Progress: 1.0, Message: "Test complete"}
+// BufferbloatGrade grades how much latency grows under load, using the
+// worse of the download and upload loaded RTTs. It returns "" when idle
+// or loaded latency was not measured.
+func BufferbloatGrade(idleMs, downloadMs, uploadMs float64) string {
+	loaded := math.Max(downloadMs, uploadMs)
+	if idleMs <= 0 || loaded <= 0 {
+		return ""
+	}
 
-	return result, nil
+	increase := loaded - idleMs
+	switch {
+	case increase < 5:
+		return "A+"
+	case increase < 30:
+		return "A"
+	case increase < 60:
+		return "B"
+	case increase < 200:
+		return "C"
+	case increase < 400:
+		return "D"
+	default:
+		return "F"
+	}
+}
+
+// ResponsivenessRPM converts the worse loaded RTT into round-trips per
+// minute, the unit used by Apple's networkQuality tool. It returns 0 when
+// no loaded latency was measured.
+func ResponsivenessRPM(downloadMs, uploadMs float64) int {
+	loaded := math.Max(downloadMs, uploadMs)
+	if loaded <= 0 {
+		return 0
+	}
+	return int(60000 / loaded)
 }
 
-func (s *SpeedTestService) testPing() (avgMs, jitterMs, packetLoss float64) {
-	const samples = 10
+// testPing probes the client's round-trip time, reporting every sample.
+// Jitter is the mean absolute difference between consecutive RTTs
+// (RFC 3550 style) and probes that time out count as lost. Cancelling ctx
+// stops probing; the figures then cover the probes sent so far.
+func (s *SpeedTestService) testPing(ctx context.Context, pinger Pinger, progressChan chan<- ProgressUpdate) (avgMs, jitterMs, packetLoss float64) {
+	const (
+		samples  = 10
+		timeout  = 1 * time.Second
+		interval = 100 * time.Millisecond
+	)
 	var pings []float64
-	var successful int
-
-	for i := 0; i < samples; i++ {
-		start := time.Now()
-		time.Sleep(1 * time.Millisecond)
-		elapsed := time.Since(start).Milliseconds()
-		
-		if elapsed > 0 {
-			pings = append(pings, float64(elapsed))
-			successful++
+	var diffSum float64
+	var diffs int
+	last := -1.0
+	sent := 0
+
+	for i := 0; i < samples && ctx.Err() == nil; i++ {
+		sent++
+		update := ProgressUpdate{
+			Stage:    "ping",
+			Progress: float64(i+1) / float64(samples+1),
+			Seq:      i + 1,
+		}
+
+		rtt, err := pinger.Ping(i+1, timeout)
+		if err != nil {
+			update.Lost = true
+			update.Message = fmt.Sprintf("Probe %d lost", i+1)
+			last = -1
+		} else {
+			ms := float64(rtt.Microseconds()) / 1000
+			pings = append(pings, ms)
+			if last >= 0 {
+				diffSum += math.Abs(ms - last)
+				diffs++
+			}
+			last = ms
+			update.RTT = ms
+			update.Speed = ms
+			update.Message = fmt.Sprintf("%.1f ms", ms)
+		}
+		if !send(ctx, progressChan, update) {
+			break
+		}
+
+		if i < samples-1 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(interval):
+			}
 		}
-		time.Sleep(50 * time.Millisecond)
 	}
 
+	if sent == 0 {
+		return 0, 0, 0
+	}
+	packetLoss = float64(sent-len(pings)) / float64(sent) * 100
 	if len(pings) == 0 {
-		return 0, 0, 100
+		return 0, 0, packetLoss
 	}
 
 	var sum float64
@@ -93,160 +521,244 @@ func (s *SpeedTestService) testPing() (avgMs, jitterMs, packetLoss float64) {
 	}
 	avgMs = sum / float64(len(pings))
 
-	var variance float64
-	for _, p := range pings {
-		variance += math.Pow(p-avgMs, 2)
+	if diffs > 0 {
+		jitterMs = diffSum / float64(diffs)
 	}
-	jitterMs = math.Sqrt(variance / float64(len(pings)))
-
-	packetLoss = float64(samples-successful) / float64(samples) * 100
 
 	return avgMs, jitterMs, packetLoss
 }
 
-func (s *SpeedTestService) testDownload(durationSec int, progressChan chan<- ProgressUpdate) float64 {
-	var totalBytes int64
-	var mu sync.Mutex
-	startTime := time.Now()
-	endTime := startTime.Add(time.Duration(durationSec) * time.Second)
-
-	numThreads := s.maxThreads
-	var wg sync.WaitGroup
-
-	for i := 0; i < numThreads; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			buffer := make([]byte, s.chunkSize)
-			for time.Now().Before(endTime) {
-				n := len(buffer)
-				mu.Lock()
-				totalBytes += int64(n)
-				mu.Unlock()
-				time.Sleep(10 * time.Millisecond)
-			}
-		}()
+// Progress is reported every progressInterval and throughput sampled every
+// sampleInterval; adaptive stages judge stability on those samples,
+// averaging the last stableWindow of them.
+const (
+	progressInterval = 250 * time.Millisecond
+	sampleInterval   = 500 * time.Millisecond
+	stableWindow     = 4
+)
+
+// measureThroughput samples a session byte counter while the client moves
+// data over the data endpoints. The reported rate and percentiles leave
+// out the configured warm-up so TCP slow start does not drag them down;
+// the full per-interval series is kept.
+//
+// A fixed stage runs for the configured duration. An adaptive stage starts
+// with the given streams and doubles them every interval while that still
+// raises throughput, then ends once the rolling estimate has stayed within
+// the stable tolerance for the configured number of intervals, or when the
+// stage's share of the test timeout runs out.
+func (s *SpeedTestService) measureThroughput(ctx context.Context, stage string, opts TestOptions, streams int, counter func() int64, probe *loadedProbe, progressChan chan<- ProgressUpdate) stageResult {
+	limit := time.Duration(opts.Duration) * time.Second
+	reason := StopDuration
+	if opts.Adaptive {
+		limit = time.Duration(s.maxStageDuration(opts)) * time.Second
+		reason = StopTimeout
 	}
+	warmupSamples := int(math.Ceil(float64(time.Duration(s.cfg.WarmupMs)*time.Millisecond) / float64(sampleInterval)))
 
-	ticker := time.NewTicker(200 * time.Millisecond)
+	startBytes := counter()
+	startTime := time.Now()
+	endTime := startTime.Add(limit)
+	tolerance := s.cfg.StableTolerance / 100
+
+	ramping := opts.Adaptive && streams < opts.Streams
+	intervalStart, intervalBytes := startTime, startBytes
+	measuredStart, measuredBytes := startTime, startBytes
+	warmupIntervals := 0
+	var rates []float64
+	var estimate float64
+	stable := 0
+
+	ticker := time.NewTicker(progressInterval)
 	defer ticker.Stop()
+	ticksPerSample := int(sampleInterval / progressInterval)
+
+measure:
+	for tick := 1; ; tick++ {
+		var now time.Time
+		select {
+		case <-ctx.Done():
+			reason = StopCancelled
+			break measure
+		case now = <-ticker.C:
+		}
+		if !now.Before(endTime) {
+			break
+		}
 
-	go func() {
-		for range ticker.C {
-			if time.Now().After(endTime) {
-				return
-			}
-			elapsed := time.Since(startTime).Seconds()
-			progress := elapsed / float64(durationSec)
-			if progress > 1.0 {
-				progress = 1.0
+		total := counter()
+		elapsed := now.Sub(startTime).Seconds()
+		speed := (float64(total-startBytes) * 8) / elapsed / 1_000_000
+		update := ProgressUpdate{
+			Stage:    stage,
+			Progress: elapsed / limit.Seconds(),
+			Speed:    speed,
+			Message:  fmt.Sprintf("%.1f Mbps", speed),
+		}
+		if probe != nil {
+			update.RTT = probe.last()
+		}
+
+		if tick%ticksPerSample == 0 {
+			rate := (float64(total-intervalBytes) * 8) / now.Sub(intervalStart).Seconds() / 1_000_000
+			intervalStart, intervalBytes = now, total
+			rates = append(rates, rate)
+			if len(rates) <= warmupSamples {
+				warmupIntervals = len(rates)
+				measuredStart, measuredBytes = now, total
 			}
-			mu.Lock()
-			currentBytes := totalBytes
-			mu.Unlock()
-			speed := (float64(currentBytes) * 8) / elapsed / 1_000_000
-			progressChan <- ProgressUpdate{
-				Stage:    "download",
-				Progress: progress,
-				Speed:    speed,
-				Message:  fmt.Sprintf("%.1f Mbps", speed),
+
+			switch {
+			case !opts.Adaptive:
+			case ramping && len(rates) > 1 && rate < rates[len(rates)-2]*(1+tolerance):
+				// More streams stopped paying off
+				ramping = false
+			case ramping:
+				streams = min(streams*2, opts.Streams)
+				ramping = streams < opts.Streams
+				update.Streams = streams
+				update.Message = fmt.Sprintf("%.1f Mbps, %d streams", speed, streams)
+			default:
+				previous := estimate
+				estimate = mean(rates[max(0, len(rates)-stableWindow):])
+				if previous > 0 && math.Abs(estimate-previous) <= previous*tolerance {
+					stable++
+				} else {
+					stable = 0
+				}
 			}
 		}
-	}()
+		if !send(ctx, progressChan, update) {
+			reason = StopCancelled
+			break
+		}
+
+		if stable >= s.cfg.StableIntervals {
+			reason = StopStable
+			break
+		}
+	}
 
-	wg.Wait()
-	elapsed := time.Since(startTime).Seconds()
-	mbps := (float64(totalBytes) * 8) / elapsed / 1_000_000
+	// A stage too short to get past the warm-up is measured whole
+	if warmupIntervals == len(rates) {
+		warmupIntervals = 0
+		measuredStart, measuredBytes = startTime, startBytes
+	}
 
-	return mbps
+	elapsed := time.Since(measuredStart).Seconds()
+	return stageResult{
+		mbps:       (float64(counter()-measuredBytes) * 8) / elapsed / 1_000_000,
+		stopReason: reason,
+		stats:      throughputStats(rates, warmupIntervals),
+	}
 }
 
-func (s *SpeedTestService) testUpload(durationSec int, progressChan chan<- ProgressUpdate) float64 {
-	var totalBytes int64
-	var mu sync.Mutex
-	startTime := time.Now()
-	endTime := startTime.Add(time.Duration(durationSec) * time.Second)
-
-	numThreads := s.maxThreads
-	var wg sync.WaitGroup
-
-	for i := 0; i < numThreads; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			buffer := make([]byte, s.chunkSize)
-			for time.Now().Before(endTime) {
-				n := len(buffer)
-				mu.Lock()
-				totalBytes += int64(n)
-				mu.Unlock()
-				time.Sleep(10 * time.Millisecond)
-			}
-		}()
+// throughputStats summarizes the per-interval rates after the warm-up.
+func throughputStats(rates []float64, warmupIntervals int) *model.ThroughputStats {
+	stats := &model.ThroughputStats{
+		IntervalMs:      int(sampleInterval.Milliseconds()),
+		WarmupIntervals: warmupIntervals,
+		Series:          rates,
 	}
 
-	ticker := time.NewTicker(200 * time.Millisecond)
-	defer ticker.Stop()
-
-	go func() {
-		for range ticker.C {
-			if time.Now().After(endTime) {
-				return
-			}
-			elapsed := time.Since(startTime).Seconds()
-			progress := elapsed / float64(durationSec)
-			if progress > 1.0 {
-				progress = 1.0
-			}
-			mu.Lock()
-			currentBytes := totalBytes
-			mu.Unlock()
-			speed := (float64(currentBytes) * 8) / elapsed / 1_000_000
-			progressChan <- ProgressUpdate{
-				Stage:    "upload",
-				Progress: progress,
-				Speed:    speed,
-				Message:  fmt.Sprintf("%.1f Mbps", speed),
-			}
-		}
-	}()
+	measured := slices.Clone(rates[warmupIntervals:])
+	if len(measured) == 0 {
+		return stats
+	}
+	slices.Sort(measured)
+	stats.P10 = percentile(measured, 10)
+	stats.P50 = percentile(measured, 50)
+	stats.P90 = percentile(measured, 90)
+	stats.Max = measured[len(measured)-1]
+	return stats
+}
 
-	wg.Wait()
-	elapsed := time.Since(startTime).Seconds()
-	mbps := (float64(totalBytes) * 8) / elapsed / 1_000_000
+// percentile interpolates the p-th percentile of sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower))
+}
 
-	return mbps
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
 }
 
-func (s *SpeedTestService) GenerateRandomData(w http.ResponseWriter, size int) {
+// ServePayload streams download data to the client: size bytes, or with
+// size 0 an unbounded stream (no Content-Length) that runs until the
+// client disconnects, the request context ends or test.stream_limit bytes
+// have gone. Every byte the socket accepts is credited to the session, if
+// any, and the connection's TCP_INFO is sampled along the way.
+func (s *SpeedTestService) ServePayload(w http.ResponseWriter, r *http.Request, size int64, session *TestSession) {
 	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", size))
+	w.Header().Set("Cache-Control", "no-store")
+
+	limit := size
+	if size > 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	} else {
+		limit = s.cfg.StreamLimit
+		// The server's write timeout would cut a stream short; the
+		// request context still bounds it
+		http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	}
 
-	buffer := make([]byte, 8192)
-	remaining := size
+	var stream *tcpStream
+	if session != nil {
+		session.download.from(r.RemoteAddr)
+		stream = session.downloadTCP.stream(r)
+		defer stream.done()
+	}
 
-	for remaining > 0 {
-		toWrite := remaining
-		if toWrite > len(buffer) {
-			toWrite = len(buffer)
+	s.payload.Stream(r.Context(), w, limit, func(n int) {
+		if session != nil {
+			session.download.add(n)
+			stream.maybeSample()
 		}
-		rand.Read(buffer[:toWrite])
-		w.Write(buffer[:toWrite])
-		remaining -= toWrite
-	}
+	})
 }
 
-func (s *SpeedTestService) ConsumeUploadData(r *http.Request) (int64, error) {
+// ConsumeUploadData reads and discards an upload for the session,
+// crediting every byte received to it and sampling the connection's
+// TCP_INFO along the way. Bodies over the session's payload size fail
+// with *http.MaxBytesError, and reads stop at the session's deadline with
+// ErrSessionDeadline; bytes received until then still count.
+func (s *SpeedTestService) ConsumeUploadData(w http.ResponseWriter, r *http.Request, session *TestSession) (int64, error) {
+	deadline := session.Deadline()
+	if !time.Now().Before(deadline) {
+		return 0, ErrSessionDeadline
+	}
+	// Replaces the server's read timeout for this body
+	http.NewResponseController(w).SetReadDeadline(deadline)
+	body := http.MaxBytesReader(w, r.Body, int64(session.Options.PayloadSize))
+
 	var totalBytes int64
-	buffer := make([]byte, 8192)
+	buffer := make([]byte, 32*1024)
+
+	session.upload.from(r.RemoteAddr)
+	stream := session.uploadTCP.stream(r)
+	defer stream.done()
 
 	for {
-		n, err := r.Body.Read(buffer)
+		n, err := body.Read(buffer)
 		totalBytes += int64(n)
+		session.upload.add(n)
+		stream.maybeSample()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
+			if !time.Now().Before(deadline) {
+				return totalBytes, ErrSessionDeadline
+			}
 			return totalBytes, err
 		}
 	}
@@ -254,6 +766,15 @@ func (s *SpeedTestService) ConsumeUploadData(r *http.Request) (int64, error) {
 	return totalBytes, nil
 }
 
+// DiscardUpload reads and drops an upload that belongs to no session, as
+// LibreSpeed clients send. Bodies are capped at MaxPayloadSize and get one
+// test.timeout to arrive.
+func (s *SpeedTestService) DiscardUpload(w http.ResponseWriter, r *http.Request) (int64, error) {
+	timeout := time.Duration(s.cfg.Timeout) * time.Second
+	http.NewResponseController(w).SetReadDeadline(time.Now().Add(timeout))
+	return io.Copy(io.Discard, http.MaxBytesReader(w, r.Body, MaxPayloadSize))
+}
+
 func GenerateShareCode() string {
 	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 	b := make([]byte, 10)
@@ -0,0 +1,170 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/casapps/casspeed/src/server/model"
+)
+
+// Bucket sizes for statistics
+const (
+	StatsHour = "hour"
+	StatsDay  = "day"
+	StatsWeek = "week" // Starting on Monday
+)
+
+// ValidStatsBucket reports whether bucket names a bucket size.
+func ValidStatsBucket(bucket string) bool {
+	return bucket == StatsHour || bucket == StatsDay || bucket == StatsWeek
+}
+
+// bucketStart is the start (UTC) of the bucket t falls in.
+func bucketStart(t time.Time, bucket string) time.Time {
+	t = t.UTC()
+	if bucket == StatsHour {
+		return t.Truncate(time.Hour)
+	}
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	if bucket == StatsWeek {
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	}
+	return day
+}
+
+// nextBucket is the start of the bucket after the one starting at start.
+func nextBucket(start time.Time, bucket string) time.Time {
+	switch bucket {
+	case StatsHour:
+		return start.Add(time.Hour)
+	case StatsWeek:
+		return start.AddDate(0, 0, 7)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// maxStatsBuckets bounds how many buckets one request asks the store for.
+const maxStatsBuckets = 400
+
+// StatsSplits gives where the buckets from from to to start, after the
+// first, which starts at from. Ranges of more than maxStatsBuckets
+// buckets are refused.
+func StatsSplits(from, to time.Time, bucket string) ([]time.Time, error) {
+	var splits []time.Time
+	for start := nextBucket(bucketStart(from, bucket), bucket); start.Before(to); start = nextBucket(start, bucket) {
+		if len(splits)+1 == maxStatsBuckets {
+			return nil, fmt.Errorf("more than %d %s buckets: narrow the range or use larger buckets", maxStatsBuckets, bucket)
+		}
+		splits = append(splits, start)
+	}
+	return splits, nil
+}
+
+// metricStats describes one figure over a bucket's stored results and the
+// rolled-up ones, either of which may be missing. Rolled-up results only
+// keep their sum and range, so the median and 95th percentile are left
+// out of buckets holding any.
+func metricStats(stored model.FigureTotals, storedTests int, rolled model.FigureTotals, rolledTests int) model.MetricStats {
+	stats := model.MetricStats{Avg: (stored.Sum + rolled.Sum) / float64(storedTests+rolledTests)}
+	switch {
+	case rolledTests == 0:
+		stats.Min = stored.Min
+		stats.Median, stats.P95 = &stored.Median, &stored.P95
+	case storedTests == 0:
+		stats.Min = rolled.Min
+	default:
+		stats.Min = min(stored.Min, rolled.Min)
+	}
+	return stats
+}
+
+// StatsBuckets labels the totals of stored results, and of the daily
+// aggregates of those rolled up, with the start of their bucket and
+// describes each figure, oldest first. Both come from the same splits;
+// hourly buckets have no rolled-up totals, as days can't be split.
+func StatsBuckets(stored, rolled []*model.SpeedTestTotals, bucket string) []*model.StatsBucket {
+	type bucketTotals struct{ stored, rolled model.SpeedTestTotals }
+	buckets := make(map[time.Time]*bucketTotals)
+	get := func(t *model.SpeedTestTotals) *bucketTotals {
+		start := bucketStart(t.Start, bucket)
+		b, ok := buckets[start]
+		if !ok {
+			b = &bucketTotals{}
+			buckets[start] = b
+		}
+		return b
+	}
+	for _, t := range stored {
+		get(t).stored = *t
+	}
+	for _, t := range rolled {
+		get(t).rolled = *t
+	}
+
+	result := make([]*model.StatsBucket, 0, len(buckets))
+	for start, b := range buckets {
+		s, r := &b.stored, &b.rolled
+		result = append(result, &model.StatsBucket{
+			Start:    start,
+			Tests:    s.Tests + r.Tests,
+			RolledUp: r.Tests,
+			Download: metricStats(s.Download, s.Tests, r.Download, r.Tests),
+			Upload:   metricStats(s.Upload, s.Tests, r.Upload, r.Tests),
+			Ping:     metricStats(s.Ping, s.Tests, r.Ping, r.Tests),
+			Jitter:   metricStats(s.Jitter, s.Tests, r.Jitter, r.Tests),
+		})
+	}
+	slices.SortFunc(result, func(a, b *model.StatsBucket) int { return a.Start.Compare(b.Start) })
+	return result
+}
+
+// defaultStatsDays is how far back statistics go when no range is given,
+// and maxStatsDays how far apart from and to may be.
+const (
+	defaultStatsDays = 30
+	maxStatsDays     = 731
+)
+
+// StatsRange parses the from and to of a statistics request, either
+// dates (YYYY-MM-DD, UTC) covering whole days, so a to date is included,
+// or RFC 3339 times. Without to the range ends at now, and without from it
+// starts defaultStatsDays before its end. Ranges over maxStatsDays are
+// refused.
+func StatsRange(from, to string, now time.Time) (time.Time, time.Time, error) {
+	end := now
+	if to != "" {
+		t, err := parseStatsTime(to)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid to: %q", to)
+		}
+		if _, err := time.Parse(time.DateOnly, to); err == nil {
+			t = t.AddDate(0, 0, 1)
+		}
+		end = t
+	}
+
+	start := end.AddDate(0, 0, -defaultStatsDays)
+	if from != "" {
+		t, err := parseStatsTime(from)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid from: %q", from)
+		}
+		start = t
+	}
+	if !start.Before(end) {
+		return time.Time{}, time.Time{}, errors.New("from must be before to")
+	}
+	if end.Sub(start) > maxStatsDays*24*time.Hour {
+		return time.Time{}, time.Time{}, fmt.Errorf("range is longer than %d days", maxStatsDays)
+	}
+	return start, end, nil
+}
+
+func parseStatsTime(v string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, v); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
@@ -0,0 +1,251 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/casapps/casspeed/src/server/model"
+)
+
+// tcpSampleInterval is how often a busy transfer re-reads TCP_INFO.
+const tcpSampleInterval = 250 * time.Millisecond
+
+// Thresholds for naming what limited a stage
+const (
+	limitedPctThreshold = 50.0 // Share of busy time spent window- or buffer-limited
+	lossPctThreshold    = 1.0  // Retransmitted or out-of-order share of segments
+)
+
+type connContextKey struct{}
+
+// ConnContext stores the accepted connection in the request context so
+// the data endpoints can read its TCP_INFO. It fits http.Server.ConnContext.
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connContextKey{}, c)
+}
+
+// requestConn returns the TCP connection a request arrived on, or nil.
+func requestConn(r *http.Request) net.Conn {
+	c, _ := r.Context().Value(connContextKey{}).(net.Conn)
+	if tc, ok := c.(*tls.Conn); ok {
+		return tc.NetConn()
+	}
+	return c
+}
+
+// tcpSample is one TCP_INFO reading. Counters are cumulative over the
+// connection's lifetime.
+type tcpSample struct {
+	totalRetrans  uint32
+	dataSegsOut   uint32
+	dataSegsIn    uint32
+	outOfOrder    uint32
+	rtt           time.Duration
+	rcvRTT        time.Duration
+	minRTT        time.Duration
+	cwnd          uint32
+	deliveryRate  uint64 // Bytes per second
+	busy          time.Duration
+	rwndLimited   time.Duration
+	sndbufLimited time.Duration
+}
+
+// tcpStream accumulates samples for one connection within one stage.
+// Keep-alive requests reuse the connection, so several requests can feed
+// the same stream.
+type tcpStream struct {
+	mu       sync.Mutex
+	conn     net.Conn
+	receive  bool
+	first    tcpSample
+	last     tcpSample
+	rttSum   time.Duration
+	cwndSum  uint64
+	rateSum  uint64
+	samples  int
+	sampleAt time.Time
+}
+
+// sample reads TCP_INFO now. Reads that fail, or platforms without
+// TCP_INFO, are skipped.
+func (st *tcpStream) sample() {
+	info, ok := readTCPInfo(st.conn)
+	if !ok {
+		return
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.samples == 0 {
+		st.first = info
+	}
+	st.last = info
+	if st.receive {
+		st.rttSum += info.rcvRTT
+	} else {
+		st.rttSum += info.rtt
+	}
+	st.cwndSum += uint64(info.cwnd)
+	st.rateSum += info.deliveryRate
+	st.samples++
+	st.sampleAt = time.Now()
+}
+
+// maybeSample re-reads TCP_INFO if the last reading is older than
+// tcpSampleInterval. It is called from the transfer loops.
+func (st *tcpStream) maybeSample() {
+	if st == nil {
+		return
+	}
+	st.mu.Lock()
+	due := time.Since(st.sampleAt) >= tcpSampleInterval
+	st.mu.Unlock()
+	if due {
+		st.sample()
+	}
+}
+
+func (st *tcpStream) done() {
+	if st != nil {
+		st.sample()
+	}
+}
+
+func (st *tcpStream) stats() (model.TCPStreamStats, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.samples == 0 {
+		return model.TCPStreamStats{}, false
+	}
+
+	first, last := st.first, st.last
+	n := float64(st.samples)
+	s := model.TCPStreamStats{
+		Retransmits:      last.totalRetrans - first.totalRetrans,
+		OutOfOrder:       last.outOfOrder - first.outOfOrder,
+		RTTMs:            float64(st.rttSum.Microseconds()) / 1000 / n,
+		MinRTTMs:         float64(last.minRTT.Microseconds()) / 1000,
+		Cwnd:             uint32(float64(st.cwndSum)/n + 0.5),
+		DeliveryRateMbps: float64(st.rateSum) * 8 / n / 1_000_000,
+		Samples:          st.samples,
+	}
+	if segs := last.dataSegsOut - first.dataSegsOut; segs > 0 {
+		s.RetransmitPct = float64(s.Retransmits) / float64(segs) * 100
+	}
+	if busy := last.busy - first.busy; busy > 0 {
+		s.RwndLimitedPct = float64(last.rwndLimited-first.rwndLimited) / float64(busy) * 100
+		s.SndbufLimitedPct = float64(last.sndbufLimited-first.sndbufLimited) / float64(busy) * 100
+	}
+	return s, true
+}
+
+// tcpTracker collects per-connection TCP_INFO for one direction of a
+// test session.
+type tcpTracker struct {
+	mu      sync.Mutex
+	receive bool
+	streams map[net.Conn]*tcpStream
+}
+
+// stream returns the tracked stream for the request's connection, taking
+// a first sample when the connection is new. It returns nil when the
+// connection isn't known or TCP_INFO isn't available.
+func (t *tcpTracker) stream(r *http.Request) *tcpStream {
+	conn := requestConn(r)
+	if conn == nil || !tcpInfoSupported {
+		return nil
+	}
+
+	t.mu.Lock()
+	st, ok := t.streams[conn]
+	if !ok {
+		if t.streams == nil {
+			t.streams = make(map[net.Conn]*tcpStream)
+		}
+		st = &tcpStream{conn: conn, receive: t.receive}
+		t.streams[conn] = st
+	}
+	t.mu.Unlock()
+
+	if !ok {
+		st.sample()
+	}
+	return st
+}
+
+// summary aggregates every stream seen, or returns nil if none was
+// sampled.
+func (t *tcpTracker) summary() *model.TCPStats {
+	t.mu.Lock()
+	streams := make([]*tcpStream, 0, len(t.streams))
+	for _, st := range t.streams {
+		streams = append(streams, st)
+	}
+	t.mu.Unlock()
+
+	var out model.TCPStats
+	var segsOut, segsIn uint32
+	var rttSum, rwndSum float64
+	for _, st := range streams {
+		s, ok := st.stats()
+		if !ok {
+			continue
+		}
+		out.Streams = append(out.Streams, s)
+		out.Retransmits += s.Retransmits
+		out.OutOfOrder += s.OutOfOrder
+		out.DeliveryRateMbps += s.DeliveryRateMbps
+		rttSum += s.RTTMs
+		rwndSum += s.RwndLimitedPct
+
+		st.mu.Lock()
+		segsOut += st.last.dataSegsOut - st.first.dataSegsOut
+		segsIn += st.last.dataSegsIn - st.first.dataSegsIn
+		st.mu.Unlock()
+	}
+	if len(out.Streams) == 0 {
+		return nil
+	}
+
+	n := float64(len(out.Streams))
+	out.RTTMs = rttSum / n
+	out.RwndLimitedPct = rwndSum / n
+	if segsOut > 0 {
+		out.RetransmitPct = float64(out.Retransmits) / float64(segsOut) * 100
+	}
+	if t.receive {
+		// The server only sends ACKs during uploads, so its send-side
+		// figures say nothing about the client's transfer
+		out.Retransmits, out.RetransmitPct, out.DeliveryRateMbps, out.RwndLimitedPct = 0, 0, 0, 0
+		for i := range out.Streams {
+			s := &out.Streams[i]
+			s.Retransmits, s.RetransmitPct, s.Cwnd, s.DeliveryRateMbps, s.RwndLimitedPct, s.SndbufLimitedPct = 0, 0, 0, 0, 0, 0
+		}
+	}
+	out.Limit = tcpLimit(&out, segsIn)
+	return &out
+}
+
+// tcpLimit names what most likely held a stage back: the client's
+// receive window, the server's send buffer, loss, or otherwise the path.
+func tcpLimit(s *model.TCPStats, segsIn uint32) string {
+	var sndbufSum float64
+	for _, st := range s.Streams {
+		sndbufSum += st.SndbufLimitedPct
+	}
+	switch {
+	case s.RwndLimitedPct >= limitedPctThreshold:
+		return model.TCPLimitReceiver
+	case sndbufSum/float64(len(s.Streams)) >= limitedPctThreshold:
+		return model.TCPLimitSender
+	case s.RetransmitPct >= lossPctThreshold:
+		return model.TCPLimitLoss
+	case segsIn > 0 && float64(s.OutOfOrder)/float64(segsIn)*100 >= lossPctThreshold:
+		return model.TCPLimitLoss
+	}
+	return model.TCPLimitNetwork
+}
@@ -0,0 +1,50 @@
+//go:build linux
+
+package service
+
+import (
+	"net"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const tcpInfoSupported = true
+
+// readTCPInfo reads TCP_INFO for conn. Fields the running kernel doesn't
+// fill in read as zero.
+func readTCPInfo(conn net.Conn) (tcpSample, bool) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return tcpSample{}, false
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return tcpSample{}, false
+	}
+
+	var info *unix.TCPInfo
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		info, sockErr = unix.GetsockoptTCPInfo(int(fd), unix.IPPROTO_TCP, unix.TCP_INFO)
+	})
+	if err != nil || sockErr != nil {
+		return tcpSample{}, false
+	}
+
+	return tcpSample{
+		totalRetrans:  info.Total_retrans,
+		dataSegsOut:   info.Data_segs_out,
+		dataSegsIn:    info.Data_segs_in,
+		outOfOrder:    info.Rcv_ooopack,
+		rtt:           time.Duration(info.Rtt) * time.Microsecond,
+		rcvRTT:        time.Duration(info.Rcv_rtt) * time.Microsecond,
+		minRTT:        time.Duration(info.Min_rtt) * time.Microsecond,
+		cwnd:          info.Snd_cwnd,
+		deliveryRate:  info.Delivery_rate,
+		busy:          time.Duration(info.Busy_time) * time.Microsecond,
+		rwndLimited:   time.Duration(info.Rwnd_limited) * time.Microsecond,
+		sndbufLimited: time.Duration(info.Sndbuf_limited) * time.Microsecond,
+	}, true
+}
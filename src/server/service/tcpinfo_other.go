@@ -0,0 +1,13 @@
+//go:build !linux
+
+package service
+
+import "net"
+
+// TCP_INFO per-stream diagnostics are Linux-only; elsewhere results are
+// stored without them.
+const tcpInfoSupported = false
+
+func readTCPInfo(conn net.Conn) (tcpSample, bool) {
+	return tcpSample{}, false
+}
@@ -0,0 +1,133 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync/atomic"
+)
+
+// HTTP transports the data endpoints can be measured over. HTTP/1.1 is
+// always served; h2 needs the TLS listener and h3 the QUIC one.
+const (
+	TransportHTTP1 = "http1"
+	TransportH2    = "h2"
+	TransportH3    = "h3"
+
+	// TransportMixed is stored for unpinned tests whose transfers used
+	// more than one transport
+	TransportMixed = "mixed"
+)
+
+var allTransports = []string{TransportHTTP1, TransportH2, TransportH3}
+
+var (
+	ErrInvalidTransport     = errors.New("invalid transport")
+	ErrTransportUnavailable = errors.New("transport is not enabled on this server")
+)
+
+// EnableTransport makes a transport available to tests; port is where it
+// listens, TCP for h2 and UDP for h3.
+func (s *SpeedTestService) EnableTransport(transport string, port int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.transports == nil {
+		s.transports = make(map[string]int)
+	}
+	s.transports[transport] = port
+}
+
+// DisableTransport withdraws a transport, as when its listener stops.
+func (s *SpeedTestService) DisableTransport(transport string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.transports, transport)
+}
+
+// TransportPort returns the port a transport listens on, or 0 if it
+// isn't enabled.
+func (s *SpeedTestService) TransportPort(transport string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.transports[transport]
+}
+
+func (s *SpeedTestService) transportEnabled(transport string) bool {
+	if transport == TransportHTTP1 {
+		return true
+	}
+	return s.TransportPort(transport) != 0
+}
+
+// parseTransport validates a requested transport; "" leaves the client
+// free to use any.
+func (s *SpeedTestService) parseTransport(transport string) (string, error) {
+	if transport == "" {
+		return "", nil
+	}
+	if !slices.Contains(allTransports, transport) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidTransport, transport)
+	}
+	if !s.transportEnabled(transport) {
+		return "", fmt.Errorf("%w: %s", ErrTransportUnavailable, transport)
+	}
+	return transport, nil
+}
+
+// RequestTransport returns the transport a request arrived over.
+func RequestTransport(r *http.Request) string {
+	switch r.ProtoMajor {
+	case 3:
+		return TransportH3
+	case 2:
+		return TransportH2
+	}
+	return TransportHTTP1
+}
+
+// transportSet records which transports a session's transfers used.
+type transportSet struct {
+	seen atomic.Uint32
+}
+
+func (t *transportSet) add(transport string) {
+	bit := uint32(1) << slices.Index(allTransports, transport)
+	for {
+		old := t.seen.Load()
+		if old&bit != 0 || t.seen.CompareAndSwap(old, old|bit) {
+			return
+		}
+	}
+}
+
+// AcceptTransport admits a data request to the session if it arrived over
+// the transport the test was pinned to, noting the transport either way.
+func (t *TestSession) AcceptTransport(r *http.Request) error {
+	transport := RequestTransport(r)
+	if pinned := t.Options.Transport; pinned != "" && transport != pinned {
+		return fmt.Errorf("%w: test runs over %s, request came over %s", ErrInvalidTransport, pinned, transport)
+	}
+	t.transports.add(transport)
+	return nil
+}
+
+// Transport returns the transport the session's transfers used: the
+// pinned one, the single one seen, TransportMixed, or "" if none ran.
+func (t *TestSession) Transport() string {
+	if t.Options.Transport != "" {
+		return t.Options.Transport
+	}
+	seen := t.transports.seen.Load()
+	var used string
+	for i, transport := range allTransports {
+		if seen&(1<<i) == 0 {
+			continue
+		}
+		if used != "" {
+			return TransportMixed
+		}
+		used = transport
+	}
+	return used
+}
@@ -0,0 +1,261 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/casapps/casspeed/src/server/model"
+	"github.com/google/uuid"
+)
+
+// UDP datagram layout, all integers big-endian:
+//
+//	0..3   magic "CSUP"
+//	4..19  test ID (UUID bytes)
+//	20..23 sequence number, starting at 0
+//	24..31 client send time, nanoseconds on the client's clock
+//	32..   padding up to the planned packet size
+//
+// The responder echoes each accepted datagram back unchanged, once, so the
+// client can also time round trips and spot loss on the way back.
+const (
+	udpMagic      = "CSUP"
+	UDPHeaderSize = 32
+	MaxUDPSize    = 1400
+)
+
+// udpGrace is how long the UDP stage waits after the last datagram is
+// due, for stragglers still in flight.
+const udpGrace = time.Second
+
+var ErrUDPDisabled = errors.New("udp stage is not enabled on this server")
+
+// UDPPlan tells the client how to run the UDP stage: send Count
+// datagrams of PacketSize bytes at Rate per second to Port.
+type UDPPlan struct {
+	Port       int `json:"port"`
+	Rate       int `json:"rate"`
+	PacketSize int `json:"packet_size"`
+	Count      int `json:"count"`
+}
+
+// ListenUDP starts the UDP responder. Until it is running, sessions
+// cannot include the UDP stage.
+func (s *SpeedTestService) ListenUDP(addr string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.udpConn = conn
+	s.mu.Unlock()
+
+	go s.serveUDP(conn)
+	return nil
+}
+
+// CloseUDP stops the UDP responder.
+func (s *SpeedTestService) CloseUDP() {
+	s.mu.Lock()
+	conn := s.udpConn
+	s.udpConn = nil
+	s.mu.Unlock()
+
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+// UDPPlan returns the UDP stage plan for the session, or nil if the
+// session doesn't run it.
+func (s *SpeedTestService) UDPPlan(session *TestSession) *UDPPlan {
+	if !session.Options.Runs(StageUDP) {
+		return nil
+	}
+
+	s.mu.RLock()
+	conn := s.udpConn
+	s.mu.RUnlock()
+	if conn == nil {
+		return nil
+	}
+
+	return &UDPPlan{
+		Port:       conn.LocalAddr().(*net.UDPAddr).Port,
+		Rate:       session.udp.rate,
+		PacketSize: session.udp.packetSize,
+		Count:      session.udp.expected,
+	}
+}
+
+func (s *SpeedTestService) udpEnabled() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.udpConn != nil
+}
+
+// serveUDP records and echoes datagrams for live sessions that run the
+// UDP stage. Anything else is dropped, as are repeated sequence numbers
+// and anything past the session's planned count, so the responder can't
+// be used as a reflector: it sends at most count datagrams per session.
+func (s *SpeedTestService) serveUDP(conn net.PacketConn) {
+	buf := make([]byte, MaxUDPSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		received := time.Now()
+
+		if n < UDPHeaderSize || !bytes.Equal(buf[:4], []byte(udpMagic)) {
+			continue
+		}
+		id, err := uuid.FromBytes(buf[4:20])
+		if err != nil {
+			continue
+		}
+		session := s.Session(id.String())
+		if session == nil || !session.Options.Runs(StageUDP) {
+			continue
+		}
+
+		seq := binary.BigEndian.Uint32(buf[20:24])
+		sent := int64(binary.BigEndian.Uint64(buf[24:32]))
+		if !session.udp.record(seq, sent, received.UnixNano()) {
+			continue
+		}
+		conn.WriteTo(buf[:n], addr)
+	}
+}
+
+// runUDPStage waits while the client sends its datagrams, reporting the
+// loss seen so far, and returns what the server observed.
+func (s *SpeedTestService) runUDPStage(ctx context.Context, session *TestSession, progressChan chan<- ProgressUpdate) *model.UDPStats {
+	plan := s.UDPPlan(session)
+	if plan == nil {
+		return nil
+	}
+	send(ctx, progressChan, ProgressUpdate{Stage: StageUDP, Progress: 0, Message: "Starting UDP test", TestID: session.ID, UDP: plan})
+
+	total := time.Duration(plan.Count)*time.Second/time.Duration(plan.Rate) + udpGrace
+	start := time.Now()
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+
+	for elapsed := time.Duration(0); elapsed < total; elapsed = time.Since(start) {
+		select {
+		case <-ctx.Done():
+			return session.UDPStats()
+		case <-ticker.C:
+		}
+
+		// Loss so far is judged against the datagrams already due
+		stats := session.UDPStats()
+		due := min(int(time.Since(start).Seconds()*float64(plan.Rate)), plan.Count)
+		var loss float64
+		if due > stats.Received {
+			loss = float64(due-stats.Received) / float64(due) * 100
+		}
+		send(ctx, progressChan, ProgressUpdate{
+			Stage:    StageUDP,
+			Progress: min(float64(time.Since(start))/float64(total), 0.99),
+			Speed:    loss,
+			Message:  fmt.Sprintf("%d/%d received, jitter %.1f ms", stats.Received, plan.Count, stats.JitterMs),
+		})
+	}
+
+	stats := session.UDPStats()
+	send(ctx, progressChan, ProgressUpdate{Stage: StageUDP, Progress: 1.0, Speed: stats.LossPct, Message: "UDP test complete"})
+	return stats
+}
+
+// udpTracker accumulates the client-to-server datagrams of one session.
+type udpTracker struct {
+	mu          sync.Mutex
+	expected    int
+	rate        int
+	packetSize  int
+	seen        []bool
+	received    int
+	duplicates  int
+	reordered   int
+	highest     int64
+	lastTransit int64
+	jitter      float64 // Nanoseconds, RFC 3550 running estimate
+}
+
+// record notes one arrival and reports whether it should be echoed: only
+// the first arrival of each planned sequence number is. Duplicates are
+// counted, but echoing them would let one replayed datagram draw any
+// number of replies.
+func (u *udpTracker) record(seq uint32, sent, received int64) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if int64(seq) >= int64(u.expected) {
+		return false
+	}
+	if u.seen == nil {
+		u.seen = make([]bool, u.expected)
+		u.highest = -1
+	}
+	if u.seen[seq] {
+		u.duplicates++
+		return false
+	}
+	u.seen[seq] = true
+	u.received++
+
+	if int64(seq) < u.highest {
+		u.reordered++
+	} else {
+		u.highest = int64(seq)
+	}
+
+	// RFC 3550 interarrival jitter; the clock offset between client and
+	// server cancels out of the transit-time difference
+	transit := received - sent
+	if u.received > 1 {
+		d := float64(transit - u.lastTransit)
+		if d < 0 {
+			d = -d
+		}
+		u.jitter += (d - u.jitter) / 16
+	}
+	u.lastTransit = transit
+	return true
+}
+
+// UDPStats returns what the server observed of the session's UDP stage,
+// or nil if the session doesn't run it.
+func (t *TestSession) UDPStats() *model.UDPStats {
+	u := &t.udp
+	if u.expected == 0 {
+		return nil
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	stats := &model.UDPStats{
+		Sent:       u.expected,
+		Received:   u.received,
+		Reordered:  u.reordered,
+		Duplicates: u.duplicates,
+		JitterMs:   u.jitter / 1e6,
+		Rate:       u.rate,
+		PacketSize: u.packetSize,
+		LossPct:    float64(u.expected-u.received) / float64(u.expected) * 100,
+	}
+	return stats
+}
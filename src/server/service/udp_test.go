@@ -0,0 +1,25 @@
+package service
+
+import "testing"
+
+func TestUDPTrackerEchoesOnce(t *testing.T) {
+	u := &udpTracker{expected: 3}
+	for _, tt := range []struct {
+		seq  uint32
+		echo bool
+	}{
+		{0, true},
+		{2, true},
+		{0, false}, // Replayed
+		{1, true},
+		{2, false},
+		{3, false}, // Past the planned count
+	} {
+		if got := u.record(tt.seq, 0, 0); got != tt.echo {
+			t.Errorf("record(%d) = %v, want %v", tt.seq, got, tt.echo)
+		}
+	}
+	if u.received != 3 || u.duplicates != 2 || u.reordered != 1 {
+		t.Errorf("received %d, duplicates %d, reordered %d; want 3, 2, 1", u.received, u.duplicates, u.reordered)
+	}
+}
@@ -0,0 +1,716 @@
+package store
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/casapps/casspeed/src/server/model"
+)
+
+// ErrDuplicate is what MemoryStore returns where a SQL store would report
+// a primary key or UNIQUE constraint violation.
+var ErrDuplicate = errors.New("duplicate key")
+
+// MemoryStore keeps everything in maps, for tests that need a Store
+// without a database. It behaves as SQLiteStore does: lookups of missing
+// rows return nil without an error, updates and deletes of missing rows do
+// nothing, and what it returns are copies the caller may change.
+type MemoryStore struct {
+	mu            sync.Mutex
+	users         map[string]model.User
+	devices       map[string]model.Device
+	speedTests    map[string]model.SpeedTest
+	days          map[dayKey]model.SpeedTestDay
+	testSessions  map[string]model.TestSession
+	apiTokens     map[string]model.APIToken
+	sessions      map[string]model.Session
+	admins        map[int]model.Admin
+	adminSessions map[string]model.AdminSession
+	nextAdminID   int
+	// seq orders rows by insertion, which breaks ties in sorted lists
+	seq map[string]int
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users:         make(map[string]model.User),
+		devices:       make(map[string]model.Device),
+		speedTests:    make(map[string]model.SpeedTest),
+		days:          make(map[dayKey]model.SpeedTestDay),
+		testSessions:  make(map[string]model.TestSession),
+		apiTokens:     make(map[string]model.APIToken),
+		sessions:      make(map[string]model.Session),
+		admins:        make(map[int]model.Admin),
+		adminSessions: make(map[string]model.AdminSession),
+		nextAdminID:   1,
+		seq:           make(map[string]int),
+	}
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// inserted records the insertion order of a row.
+func (s *MemoryStore) inserted(table, id string) {
+	s.seq[table+"/"+id] = len(s.seq)
+}
+
+// sortNewest orders rows newest first, by when and then by insertion.
+func sortNewest[T any](s *MemoryStore, table string, rows []T, id func(T) string, when func(T) time.Time) {
+	slices.SortStableFunc(rows, func(a, b T) int {
+		if c := when(b).Compare(when(a)); c != 0 {
+			return c
+		}
+		return cmp.Compare(s.seq[table+"/"+id(b)], s.seq[table+"/"+id(a)])
+	})
+}
+
+// page applies LIMIT and OFFSET.
+func page[T any](rows []T, limit, offset int) []T {
+	if offset >= len(rows) {
+		return nil
+	}
+	rows = rows[offset:]
+	if limit >= 0 && limit < len(rows) {
+		rows = rows[:limit]
+	}
+	return rows
+}
+
+func (s *MemoryStore) CreateUser(ctx context.Context, user *model.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[user.ID]; ok {
+		return ErrDuplicate
+	}
+	for _, u := range s.users {
+		if u.Username == user.Username || u.Email == user.Email {
+			return ErrDuplicate
+		}
+	}
+	s.users[user.ID] = *user
+	return nil
+}
+
+func (s *MemoryStore) findUser(match func(model.User) bool) *model.User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range s.users {
+		if match(u) {
+			return &u
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetUser(ctx context.Context, id string) (*model.User, error) {
+	return s.findUser(func(u model.User) bool { return u.ID == id }), nil
+}
+
+func (s *MemoryStore) GetUserByUsername(ctx context.Context, username string) (*model.User, error) {
+	return s.findUser(func(u model.User) bool { return u.Username == username }), nil
+}
+
+func (s *MemoryStore) GetUserByEmail(ctx context.Context, email string) (*model.User, error) {
+	return s.findUser(func(u model.User) bool { return u.Email == email }), nil
+}
+
+func (s *MemoryStore) UpdateUser(ctx context.Context, user *model.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.users[user.ID]
+	if !ok {
+		return nil
+	}
+	for _, u := range s.users {
+		if u.ID != user.ID && (u.Username == user.Username || u.Email == user.Email) {
+			return ErrDuplicate
+		}
+	}
+	stored.Username = user.Username
+	stored.Email = user.Email
+	stored.PasswordHash = user.PasswordHash
+	stored.ShareShowUsername = user.ShareShowUsername
+	s.users[user.ID] = stored
+	return nil
+}
+
+func (s *MemoryStore) DeleteUser(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.users, id)
+	return nil
+}
+
+func (s *MemoryStore) CreateDevice(ctx context.Context, device *model.Device) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.devices[device.ID]; ok {
+		return ErrDuplicate
+	}
+	s.devices[device.ID] = *device
+	s.inserted("devices", device.ID)
+	return nil
+}
+
+func (s *MemoryStore) GetDevice(ctx context.Context, id string) (*model.Device, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	device, ok := s.devices[id]
+	if !ok {
+		return nil, nil
+	}
+	return &device, nil
+}
+
+func (s *MemoryStore) GetUserDevices(ctx context.Context, userID string) ([]*model.Device, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var devices []*model.Device
+	for _, d := range s.devices {
+		if d.UserID == userID {
+			devices = append(devices, &d)
+		}
+	}
+	sortNewest(s, "devices", devices, func(d *model.Device) string { return d.ID }, func(d *model.Device) time.Time { return d.CreatedAt })
+	return devices, nil
+}
+
+func (s *MemoryStore) UpdateDevice(ctx context.Context, device *model.Device) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.devices[device.ID]
+	if !ok {
+		return nil
+	}
+	stored.Name = device.Name
+	stored.LastSeen = device.LastSeen
+	s.devices[device.ID] = stored
+	return nil
+}
+
+func (s *MemoryStore) DeleteDevice(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.devices, id)
+	return nil
+}
+
+// copySpeedTest returns a copy sharing nothing with test, its stats
+// included.
+func copySpeedTest(test model.SpeedTest) *model.SpeedTest {
+	test.DownloadStats = decodeStats[model.ThroughputStats](encodeStats(test.DownloadStats))
+	test.UploadStats = decodeStats[model.ThroughputStats](encodeStats(test.UploadStats))
+	test.DownloadTCP = decodeStats[model.TCPStats](encodeStats(test.DownloadTCP))
+	test.UploadTCP = decodeStats[model.TCPStats](encodeStats(test.UploadTCP))
+	test.UDP = decodeStats[model.UDPStats](encodeStats(test.UDP))
+	test.Setup = decodeStats[model.SetupStats](encodeStats(test.Setup))
+	return &test
+}
+
+func (s *MemoryStore) CreateSpeedTest(ctx context.Context, test *model.SpeedTest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.speedTests[test.ID]; ok {
+		return ErrDuplicate
+	}
+	if test.ShareCode != "" && s.shareCodeTaken(test.ShareCode, test.ID) {
+		return ErrDuplicate
+	}
+	s.speedTests[test.ID] = *copySpeedTest(*test)
+	s.inserted("speed_tests", test.ID)
+	return nil
+}
+
+// shareCodeTaken reports whether a result other than id has the code.
+func (s *MemoryStore) shareCodeTaken(code, id string) bool {
+	for _, t := range s.speedTests {
+		if t.ShareCode == code && t.ID != id {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *MemoryStore) GetSpeedTest(ctx context.Context, id string) (*model.SpeedTest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	test, ok := s.speedTests[id]
+	if !ok {
+		return nil, nil
+	}
+	return copySpeedTest(test), nil
+}
+
+func (s *MemoryStore) GetSpeedTestByShareCode(ctx context.Context, shareCode string) (*model.SpeedTest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.speedTests {
+		if shareCode != "" && t.ShareCode == shareCode {
+			return copySpeedTest(t), nil
+		}
+	}
+	return nil, nil
+}
+
+// listSpeedTests returns a page of the matching results, newest first.
+func (s *MemoryStore) listSpeedTests(match func(model.SpeedTest) bool, limit, offset int) []*model.SpeedTest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var tests []*model.SpeedTest
+	for _, t := range s.speedTests {
+		if match(t) {
+			tests = append(tests, copySpeedTest(t))
+		}
+	}
+	sortNewest(s, "speed_tests", tests, func(t *model.SpeedTest) string { return t.ID }, func(t *model.SpeedTest) time.Time { return t.Timestamp })
+	return page(tests, limit, offset)
+}
+
+func (s *MemoryStore) GetUserSpeedTests(ctx context.Context, userID string, limit, offset int) ([]*model.SpeedTest, error) {
+	return s.listSpeedTests(func(t model.SpeedTest) bool { return userID != "" && t.UserID == userID }, limit, offset), nil
+}
+
+func (s *MemoryStore) GetDeviceSpeedTests(ctx context.Context, deviceID string, limit, offset int) ([]*model.SpeedTest, error) {
+	return s.listSpeedTests(func(t model.SpeedTest) bool { return deviceID != "" && t.DeviceID == deviceID }, limit, offset), nil
+}
+
+func (s *MemoryStore) UpdateSpeedTest(ctx context.Context, test *model.SpeedTest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.speedTests[test.ID]
+	if !ok {
+		return nil
+	}
+	if test.ShareCode != "" && s.shareCodeTaken(test.ShareCode, test.ID) {
+		return ErrDuplicate
+	}
+	stored.ShareCode = test.ShareCode
+	stored.ShareViews = test.ShareViews
+	s.speedTests[test.ID] = stored
+	return nil
+}
+
+func (s *MemoryStore) DeleteSpeedTest(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.speedTests, id)
+	return nil
+}
+
+func (s *MemoryStore) IncrementShareViews(ctx context.Context, shareCode string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, t := range s.speedTests {
+		if shareCode != "" && t.ShareCode == shareCode {
+			t.ShareViews++
+			s.speedTests[id] = t
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) PruneSpeedTests(ctx context.Context, opts PruneOptions) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pruned := make(map[dayKey]*model.SpeedTestDay)
+	n := 0
+	for id, t := range s.speedTests {
+		if !opts.prunable(&t) {
+			continue
+		}
+		if opts.Rollup && t.Status == model.TestStatusComplete && t.Source == "" {
+			rollUp(pruned, &t)
+		}
+		delete(s.speedTests, id)
+		n++
+	}
+	for key, day := range pruned {
+		if stored, ok := s.days[key]; ok {
+			mergeDay(&stored, day)
+			day = &stored
+		}
+		s.days[key] = *day
+	}
+	return n, nil
+}
+
+func (s *MemoryStore) GetSpeedTestTotals(ctx context.Context, filter StatsFilter, splits []time.Time) ([]*model.SpeedTestTotals, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bounds := filter.bounds(splits)
+	values := make([][4][]float64, len(bounds)-1) // Each figure's values, by bucket
+	for _, t := range s.speedTests {
+		if t.Status != model.TestStatusComplete || t.Timestamp.Before(filter.From) || !t.Timestamp.Before(filter.To) ||
+			!filter.matches(t.UserID, t.DeviceID, t.ServerID) || (t.Source != "" && !filter.Unverified) {
+			continue
+		}
+		i := bucketOf(bounds, t.Timestamp, time.Time.Compare)
+		for j, v := range []float64{t.DownloadMbps, t.UploadMbps, t.PingMs, t.JitterMs} {
+			values[i][j] = append(values[i][j], v)
+		}
+	}
+
+	var totals []*model.SpeedTestTotals
+	for i, figs := range values {
+		if len(figs[0]) == 0 {
+			continue
+		}
+		t := &model.SpeedTestTotals{Start: bounds[i], Tests: len(figs[0])}
+		for j, fig := range figures(t) {
+			sorted := figs[j]
+			slices.Sort(sorted)
+			fig.Min, fig.Max = sorted[0], sorted[len(sorted)-1]
+			for _, v := range sorted {
+				fig.Sum += v
+			}
+			setPercentiles(fig, t.Tests, func(rank int) float64 { return sorted[rank] })
+		}
+		totals = append(totals, t)
+	}
+	return totals, nil
+}
+
+// bucketOf is the index of the bucket between bounds that v falls in.
+func bucketOf[T any](bounds []T, v T, cmp func(a, b T) int) int {
+	i, found := slices.BinarySearchFunc(bounds, v, cmp)
+	if found {
+		return i
+	}
+	return i - 1
+}
+
+func (s *MemoryStore) GetSpeedTestDays(ctx context.Context, filter StatsFilter) ([]*model.SpeedTestDay, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	first, last := filter.From.UTC().Format(dayFormat), filter.To.UTC().Format(dayFormat)
+	var days []*model.SpeedTestDay
+	for key, day := range s.days {
+		if key.day >= first && key.day < last && filter.matches(key.userID, key.deviceID, key.serverID) {
+			days = append(days, &day)
+		}
+	}
+	slices.SortFunc(days, func(a, b *model.SpeedTestDay) int {
+		return cmp.Or(a.Day.Compare(b.Day), cmp.Compare(a.UserID, b.UserID), cmp.Compare(a.DeviceID, b.DeviceID), cmp.Compare(a.ServerID, b.ServerID))
+	})
+	return days, nil
+}
+
+func (s *MemoryStore) GetSpeedTestDayTotals(ctx context.Context, filter StatsFilter, splits []time.Time) ([]*model.SpeedTestTotals, error) {
+	days, err := s.GetSpeedTestDays(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	bounds := filter.bounds(splits)
+	dayBounds := make([]string, len(bounds))
+	for i, b := range bounds {
+		dayBounds[i] = b.UTC().Format(dayFormat)
+	}
+
+	var totals []*model.SpeedTestTotals
+	for _, day := range days {
+		i := bucketOf(dayBounds, day.Day.Format(dayFormat), strings.Compare)
+		if len(totals) == 0 || totals[len(totals)-1].Start != bounds[i] {
+			totals = append(totals, &model.SpeedTestTotals{Start: bounds[i]})
+		}
+		t := totals[len(totals)-1]
+		for j, a := range []model.Aggregate{day.Download, day.Upload, day.Ping, day.Jitter} {
+			fig := &figures(t)[j].Aggregate
+			if t.Tests == 0 {
+				*fig = a
+			} else {
+				mergeAggregate(fig, a)
+			}
+		}
+		t.Tests += day.Tests
+	}
+	return totals, nil
+}
+
+func (s *MemoryStore) CreateTestSession(ctx context.Context, session *model.TestSession) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.testSessions[session.ID]; ok {
+		return ErrDuplicate
+	}
+	s.testSessions[session.ID] = *session
+	return nil
+}
+
+func (s *MemoryStore) GetTestSession(ctx context.Context, id string) (*model.TestSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.testSessions[id]
+	if !ok {
+		return nil, nil
+	}
+	return &session, nil
+}
+
+func (s *MemoryStore) UpdateTestSessionStatus(ctx context.Context, id, status string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if session, ok := s.testSessions[id]; ok {
+		session.Status = status
+		s.testSessions[id] = session
+	}
+	return nil
+}
+
+// GetChildTestSessions returns the runs of a dual-stack test, IPv4 first.
+func (s *MemoryStore) GetChildTestSessions(ctx context.Context, parentID string) ([]*model.TestSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var sessions []*model.TestSession
+	for _, session := range s.testSessions {
+		if parentID != "" && session.ParentID == parentID {
+			sessions = append(sessions, &session)
+		}
+	}
+	slices.SortFunc(sessions, func(a, b *model.TestSession) int { return cmp.Compare(a.IPFamily, b.IPFamily) })
+	return sessions, nil
+}
+
+func (s *MemoryStore) CreateAPIToken(ctx context.Context, token *model.APIToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.apiTokens[token.ID]; ok {
+		return ErrDuplicate
+	}
+	for _, t := range s.apiTokens {
+		if t.Token == token.Token {
+			return ErrDuplicate
+		}
+	}
+	s.apiTokens[token.ID] = *token
+	s.inserted("api_tokens", token.ID)
+	return nil
+}
+
+func (s *MemoryStore) GetAPIToken(ctx context.Context, id string) (*model.APIToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token, ok := s.apiTokens[id]
+	if !ok {
+		return nil, nil
+	}
+	return &token, nil
+}
+
+func (s *MemoryStore) GetAPITokenByToken(ctx context.Context, tokenStr string) (*model.APIToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.apiTokens {
+		if t.Token == tokenStr {
+			return &t, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *MemoryStore) GetUserAPITokens(ctx context.Context, userID string) ([]*model.APIToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var tokens []*model.APIToken
+	for _, t := range s.apiTokens {
+		if t.UserID == userID {
+			tokens = append(tokens, &t)
+		}
+	}
+	sortNewest(s, "api_tokens", tokens, func(t *model.APIToken) string { return t.ID }, func(t *model.APIToken) time.Time { return t.CreatedAt })
+	return tokens, nil
+}
+
+func (s *MemoryStore) UpdateAPIToken(ctx context.Context, token *model.APIToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.apiTokens[token.ID]
+	if !ok {
+		return nil
+	}
+	stored.Name = token.Name
+	stored.LastUsed = token.LastUsed
+	s.apiTokens[token.ID] = stored
+	return nil
+}
+
+func (s *MemoryStore) DeleteAPIToken(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.apiTokens, id)
+	return nil
+}
+
+func (s *MemoryStore) CreateSession(ctx context.Context, session *model.Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.sessions[session.ID]; ok {
+		return ErrDuplicate
+	}
+	s.sessions[session.ID] = *session
+	return nil
+}
+
+func (s *MemoryStore) GetSession(ctx context.Context, id string) (*model.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[id]
+	if !ok || !session.ExpiresAt.After(time.Now()) {
+		return nil, nil
+	}
+	return &session, nil
+}
+
+func (s *MemoryStore) DeleteSession(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+	return nil
+}
+
+func (s *MemoryStore) DeleteExpiredSessions(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for id, session := range s.sessions {
+		if session.ExpiresAt.Before(now) {
+			delete(s.sessions, id)
+		}
+	}
+	return nil
+}
+
+// unixNow is the current time to the second, as SQL stores keep admin
+// timestamps.
+func unixNow() time.Time {
+	return time.Unix(time.Now().Unix(), 0)
+}
+
+// Admin methods
+func (s *MemoryStore) GetAdminByUsername(ctx context.Context, username string) (*model.Admin, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, a := range s.admins {
+		if a.Username == username {
+			return &a, nil
+		}
+	}
+	return nil, nil
+}
+
+// CreateAdmin stores the admin's credentials and role and sets its ID, as
+// an INSERT with an autoincrement key does; the rest starts fresh.
+func (s *MemoryStore) CreateAdmin(ctx context.Context, admin *model.Admin) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, a := range s.admins {
+		if a.Username == admin.Username {
+			return ErrDuplicate
+		}
+	}
+	admin.ID = s.nextAdminID
+	s.nextAdminID++
+	now := unixNow()
+	s.admins[admin.ID] = model.Admin{
+		ID:        admin.ID,
+		Username:  admin.Username,
+		Password:  admin.Password,
+		Email:     admin.Email,
+		Role:      admin.Role,
+		Enabled:   admin.Enabled,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	return nil
+}
+
+// updateAdmin applies change to a stored admin, if there is one.
+func (s *MemoryStore) updateAdmin(adminID int, change func(*model.Admin)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if admin, ok := s.admins[adminID]; ok {
+		change(&admin)
+		s.admins[adminID] = admin
+	}
+	return nil
+}
+
+func (s *MemoryStore) UpdateAdminLastLogin(ctx context.Context, adminID int) error {
+	return s.updateAdmin(adminID, func(a *model.Admin) {
+		a.LastLogin = unixNow()
+		a.FailedAttempts = 0
+		a.LockedUntil = time.Time{}
+	})
+}
+
+func (s *MemoryStore) UpdateAdminFailedAttempts(ctx context.Context, adminID int, attempts int) error {
+	return s.updateAdmin(adminID, func(a *model.Admin) { a.FailedAttempts = attempts })
+}
+
+func (s *MemoryStore) LockAdmin(ctx context.Context, adminID int, until time.Time) error {
+	return s.updateAdmin(adminID, func(a *model.Admin) { a.LockedUntil = time.Unix(until.Unix(), 0) })
+}
+
+func (s *MemoryStore) CreateAdminSession(ctx context.Context, session *model.AdminSession) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.adminSessions[session.ID]; ok {
+		return ErrDuplicate
+	}
+	now := unixNow()
+	s.adminSessions[session.ID] = model.AdminSession{
+		ID:         session.ID,
+		AdminID:    session.AdminID,
+		IPAddress:  session.IPAddress,
+		UserAgent:  session.UserAgent,
+		CreatedAt:  now,
+		ExpiresAt:  time.Unix(session.ExpiresAt.Unix(), 0),
+		LastActive: now,
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetAdminSession(ctx context.Context, id string) (*model.AdminSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.adminSessions[id]
+	if !ok || session.ExpiresAt.Unix() <= time.Now().Unix() {
+		return nil, nil
+	}
+	return &session, nil
+}
+
+func (s *MemoryStore) UpdateAdminSessionActivity(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if session, ok := s.adminSessions[id]; ok {
+		session.LastActive = unixNow()
+		s.adminSessions[id] = session
+	}
+	return nil
+}
+
+func (s *MemoryStore) DeleteAdminSession(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.adminSessions, id)
+	return nil
+}
+
+func (s *MemoryStore) DeleteExpiredAdminSessions(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().Unix()
+	for id, session := range s.adminSessions {
+		if session.ExpiresAt.Unix() < now {
+			delete(s.adminSessions, id)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+package store_test
+
+import (
+	"testing"
+
+	"github.com/casapps/casspeed/src/server/store"
+	"github.com/casapps/casspeed/src/server/store/storetest"
+)
+
+func TestMemoryStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store {
+		return store.NewMemoryStore()
+	})
+}
@@ -0,0 +1,413 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schema changes ship as numbered migrations, recorded in schema_migrations
+// as they are applied. The server applies any pending ones at startup, each
+// in a transaction of its own, so a database is never left half-upgraded.
+// SQLite and PostgreSQL share one set of migrations; where their SQL
+// differs, a migration asks the dialect. New changes are appended to
+// migrations with the next version; released migrations are never edited.
+
+var (
+	ErrNoMigrationApplied    = errors.New("no migration has been applied")
+	ErrMigrationIrreversible = errors.New("migration cannot be reverted")
+)
+
+// migration is one numbered schema change. down is nil for changes that
+// can't be undone.
+type migration struct {
+	version int
+	name    string
+	up      func(ctx context.Context, tx *sql.Tx, d *dialect) error
+	down    func(ctx context.Context, tx *sql.Tx, d *dialect) error
+}
+
+// MigrationStatus reports one migration and whether it has been applied.
+// Migrations recorded by a newer build are listed as Unknown.
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt time.Time
+	Applied   bool
+	Unknown   bool
+}
+
+// dialect is what differs between the databases the migrations run on.
+type dialect struct {
+	// initialSchema creates every table as of migration 1
+	initialSchema string
+	// adopt brings databases created before migrations were versioned up
+	// to the initial schema; nil where there are none
+	adopt func(ctx context.Context, tx *sql.Tx) error
+	// numbered placeholders ($1) rather than ?
+	numbered bool
+	// lock, run first in every migration transaction, keeps servers
+	// sharing the database from migrating it at once
+	lock string
+	// times are stored as text, so comparisons only hold between times
+	// in the same zone: UTC for result timestamps
+	textTimes bool
+}
+
+// rebind rewrites a query's ? placeholders for the dialect.
+func (d *dialect) rebind(query string) string {
+	if !d.numbered {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// execSQL is a migration step that runs fixed statements, written to run
+// on every dialect.
+func execSQL(query string) func(ctx context.Context, tx *sql.Tx, d *dialect) error {
+	return func(ctx context.Context, tx *sql.Tx, d *dialect) error {
+		_, err := tx.ExecContext(ctx, query)
+		return err
+	}
+}
+
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "initial schema",
+		up: func(ctx context.Context, tx *sql.Tx, d *dialect) error {
+			if _, err := tx.ExecContext(ctx, d.initialSchema); err != nil {
+				return err
+			}
+			if d.adopt != nil {
+				return d.adopt(ctx, tx)
+			}
+			return nil
+		},
+	},
+	{
+		version: 2,
+		name:    "index parent ids",
+		up: execSQL(`
+CREATE INDEX IF NOT EXISTS idx_speed_tests_parent ON speed_tests(parent_id);
+CREATE INDEX IF NOT EXISTS idx_test_sessions_parent ON test_sessions(parent_id);
+`),
+		down: execSQL(`
+DROP INDEX IF EXISTS idx_speed_tests_parent;
+DROP INDEX IF EXISTS idx_test_sessions_parent;
+`),
+	},
+	{
+		version: 3,
+		name:    "daily result rollups",
+		up: execSQL(`
+CREATE TABLE IF NOT EXISTS speed_test_days (
+	day TEXT NOT NULL,
+	user_id TEXT NOT NULL DEFAULT '',
+	device_id TEXT NOT NULL DEFAULT '',
+	server_id TEXT NOT NULL DEFAULT '',
+	tests INTEGER NOT NULL,
+	download_sum DOUBLE PRECISION NOT NULL,
+	download_min DOUBLE PRECISION NOT NULL,
+	download_max DOUBLE PRECISION NOT NULL,
+	upload_sum DOUBLE PRECISION NOT NULL,
+	upload_min DOUBLE PRECISION NOT NULL,
+	upload_max DOUBLE PRECISION NOT NULL,
+	ping_sum DOUBLE PRECISION NOT NULL,
+	ping_min DOUBLE PRECISION NOT NULL,
+	ping_max DOUBLE PRECISION NOT NULL,
+	jitter_sum DOUBLE PRECISION NOT NULL,
+	jitter_min DOUBLE PRECISION NOT NULL,
+	jitter_max DOUBLE PRECISION NOT NULL,
+	PRIMARY KEY (day, user_id, device_id, server_id)
+);
+`),
+		down: execSQL(`DROP TABLE IF EXISTS speed_test_days;`),
+	},
+	{
+		version: 4,
+		name:    "index stats filters",
+		// The user and device indexes are prefixes of the new ones
+		up: execSQL(`
+CREATE INDEX IF NOT EXISTS idx_speed_tests_user_time ON speed_tests(user_id, timestamp);
+CREATE INDEX IF NOT EXISTS idx_speed_tests_device_time ON speed_tests(device_id, timestamp);
+CREATE INDEX IF NOT EXISTS idx_speed_tests_server_time ON speed_tests(server_id, timestamp);
+CREATE INDEX IF NOT EXISTS idx_speed_test_days_user ON speed_test_days(user_id, day);
+CREATE INDEX IF NOT EXISTS idx_speed_test_days_device ON speed_test_days(device_id, day);
+CREATE INDEX IF NOT EXISTS idx_speed_test_days_server ON speed_test_days(server_id, day);
+DROP INDEX IF EXISTS idx_speed_tests_user;
+DROP INDEX IF EXISTS idx_speed_tests_device;
+`),
+		down: execSQL(`
+CREATE INDEX IF NOT EXISTS idx_speed_tests_user ON speed_tests(user_id);
+CREATE INDEX IF NOT EXISTS idx_speed_tests_device ON speed_tests(device_id);
+DROP INDEX IF EXISTS idx_speed_tests_user_time;
+DROP INDEX IF EXISTS idx_speed_tests_device_time;
+DROP INDEX IF EXISTS idx_speed_tests_server_time;
+DROP INDEX IF EXISTS idx_speed_test_days_user;
+DROP INDEX IF EXISTS idx_speed_test_days_device;
+DROP INDEX IF EXISTS idx_speed_test_days_server;
+`),
+	},
+	{
+		version: 5,
+		name:    "result sources",
+		up:      execSQL(`ALTER TABLE speed_tests ADD COLUMN source TEXT NOT NULL DEFAULT '';`),
+		down:    execSQL(`ALTER TABLE speed_tests DROP COLUMN source;`),
+	},
+	{
+		version: 6,
+		name:    "utc result timestamps",
+		up:      utcTimestamps,
+		// The same instants read back either way
+		down: func(ctx context.Context, tx *sql.Tx, d *dialect) error { return nil },
+	},
+}
+
+// utcTimestamps rewrites result timestamps stored as text in the server's
+// local time, whose offset changes with DST, in UTC.
+func utcTimestamps(ctx context.Context, tx *sql.Tx, d *dialect) error {
+	if !d.textTimes {
+		return nil
+	}
+	rows, err := tx.QueryContext(ctx, `SELECT id, timestamp FROM speed_tests`)
+	if err != nil {
+		return err
+	}
+	stamps := make(map[string]time.Time)
+	for rows.Next() {
+		var id string
+		var ts time.Time
+		if err := rows.Scan(&id, &ts); err != nil {
+			rows.Close()
+			return err
+		}
+		stamps[id] = ts
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, ts := range stamps {
+		if _, err := tx.ExecContext(ctx, `UPDATE speed_tests SET timestamp = ? WHERE id = ?`, ts.UTC(), id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const createSchemaMigrations = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+	version INTEGER PRIMARY KEY,
+	name TEXT NOT NULL,
+	applied_at TIMESTAMP NOT NULL
+)`
+
+// migrator applies and reverts migrations on one database.
+type migrator struct {
+	db      *sql.DB
+	dialect *dialect
+}
+
+// begin starts a migration transaction, holding the dialect's lock.
+func (m *migrator) begin(ctx context.Context) (*sql.Tx, error) {
+	return beginLocked(ctx, m.db, m.dialect)
+}
+
+// beginLocked starts a transaction holding the dialect's lock, for work
+// that mustn't run on two servers at once.
+func beginLocked(ctx context.Context, db *sql.DB, d *dialect) (*sql.Tx, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	if d.lock != "" {
+		if _, err := tx.ExecContext(ctx, d.lock); err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("taking database lock: %w", err)
+		}
+	}
+	return tx, nil
+}
+
+// applied returns the recorded migrations by version.
+func (m *migrator) applied(ctx context.Context) (map[int]MigrationStatus, error) {
+	tx, err := m.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, createSchemaMigrations); err != nil {
+		return nil, fmt.Errorf("creating schema_migrations: %w", err)
+	}
+
+	rows, err := tx.QueryContext(ctx, "SELECT version, name, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]MigrationStatus)
+	for rows.Next() {
+		st := MigrationStatus{Applied: true}
+		if err := rows.Scan(&st.Version, &st.Name, &st.AppliedAt); err != nil {
+			return nil, err
+		}
+		applied[st.Version] = st
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return applied, tx.Commit()
+}
+
+// status lists every known migration in order, followed by any recorded
+// migrations this build doesn't know.
+func (m *migrator) status(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var status []MigrationStatus
+	for _, mig := range migrations {
+		st, ok := applied[mig.version]
+		if !ok {
+			st = MigrationStatus{Version: mig.version, Name: mig.name}
+		}
+		status = append(status, st)
+		delete(applied, mig.version)
+	}
+
+	var unknown []MigrationStatus
+	for _, st := range applied {
+		st.Unknown = true
+		unknown = append(unknown, st)
+	}
+	slices.SortFunc(unknown, func(a, b MigrationStatus) int { return a.Version - b.Version })
+	return append(status, unknown...), nil
+}
+
+// countKnown is how many of the applied migrations this build knows.
+func countKnown(applied map[int]MigrationStatus) int {
+	n := 0
+	for _, mig := range migrations {
+		if _, ok := applied[mig.version]; ok {
+			n++
+		}
+	}
+	return n
+}
+
+// up applies every pending migration in order and returns those it
+// applied. It refuses databases migrated by a newer build, whose schema
+// this one can't be sure of.
+func (m *migrator) up(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if n := len(applied) - countKnown(applied); n > 0 {
+		return nil, fmt.Errorf("database has %d migration(s) newer than this build; upgrade casspeed", n)
+	}
+
+	var done []MigrationStatus
+	for _, mig := range migrations {
+		if _, ok := applied[mig.version]; ok {
+			continue
+		}
+		st, ok, err := m.apply(ctx, mig)
+		if err != nil {
+			return done, err
+		}
+		if ok {
+			done = append(done, st)
+		}
+	}
+	return done, nil
+}
+
+// apply runs a migration and records it in one transaction. It reports
+// false when another server applied the migration first.
+func (m *migrator) apply(ctx context.Context, mig migration) (MigrationStatus, bool, error) {
+	st := MigrationStatus{Version: mig.version, Name: mig.name, AppliedAt: time.Now().UTC(), Applied: true}
+
+	tx, err := m.begin(ctx)
+	if err != nil {
+		return st, false, err
+	}
+	defer tx.Rollback()
+
+	var exists int
+	err = tx.QueryRowContext(ctx, m.dialect.rebind("SELECT COUNT(*) FROM schema_migrations WHERE version = ?"), mig.version).Scan(&exists)
+	if err != nil || exists > 0 {
+		return st, false, err
+	}
+
+	if err := mig.up(ctx, tx, m.dialect); err != nil {
+		return st, false, fmt.Errorf("migration %d (%s): %w", mig.version, mig.name, err)
+	}
+	if _, err := tx.ExecContext(ctx, m.dialect.rebind("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)"), st.Version, st.Name, st.AppliedAt); err != nil {
+		return st, false, fmt.Errorf("recording migration %d: %w", mig.version, err)
+	}
+	return st, true, tx.Commit()
+}
+
+// down reverts the most recently applied migration and returns it.
+func (m *migrator) down(ctx context.Context) (MigrationStatus, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return MigrationStatus{}, err
+	}
+	latest := 0
+	for version := range applied {
+		latest = max(latest, version)
+	}
+	if latest == 0 {
+		return MigrationStatus{}, ErrNoMigrationApplied
+	}
+
+	st := applied[latest]
+	var mig *migration
+	for i := range migrations {
+		if migrations[i].version == latest {
+			mig = &migrations[i]
+		}
+	}
+	if mig == nil {
+		return st, fmt.Errorf("migration %d (%s) is from a newer build; revert it with that build", st.Version, st.Name)
+	}
+	if mig.down == nil {
+		return st, fmt.Errorf("migration %d (%s): %w", mig.version, mig.name, ErrMigrationIrreversible)
+	}
+
+	tx, err := m.begin(ctx)
+	if err != nil {
+		return st, err
+	}
+	defer tx.Rollback()
+
+	if err := mig.down(ctx, tx, m.dialect); err != nil {
+		return st, fmt.Errorf("reverting migration %d (%s): %w", mig.version, mig.name, err)
+	}
+	if _, err := tx.ExecContext(ctx, m.dialect.rebind("DELETE FROM schema_migrations WHERE version = ?"), mig.version); err != nil {
+		return st, err
+	}
+	return st, tx.Commit()
+}
@@ -0,0 +1,653 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/casapps/casspeed/src/config"
+	"github.com/casapps/casspeed/src/server/model"
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// PostgresStore keeps results in a PostgreSQL database, which several
+// servers can share. Its tables mirror SQLite's, so results move between
+// the two without conversion; admin timestamps stay Unix seconds.
+type PostgresStore struct {
+	db *sql.DB
+}
+
+// NewPostgresStore connects to the database and migrates it to the
+// latest schema.
+func NewPostgresStore(dsn string) (*PostgresStore, error) {
+	store, err := OpenPostgresStore(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := store.MigrateUp(context.Background()); err != nil {
+		store.Close()
+		return nil, fmt.Errorf("migrating database: %w", err)
+	}
+
+	return store, nil
+}
+
+// OpenPostgresStore connects to the database without migrating it, for
+// maintenance of its schema.
+func OpenPostgresStore(dsn string) (*PostgresStore, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("opening database: %w", err)
+	}
+
+	db.SetMaxOpenConns(20)
+	db.SetMaxIdleConns(5)
+	db.SetConnMaxIdleTime(5 * time.Minute)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("connecting to database: %w", err)
+	}
+
+	return &PostgresStore{db: db}, nil
+}
+
+// PostgresDSN builds a connection URL from the database configuration.
+func PostgresDSN(cfg config.Database) string {
+	port := cfg.Port
+	if port == 0 {
+		port = 5432
+	}
+	u := url.URL{
+		Scheme: "postgres",
+		Host:   cfg.Host + ":" + strconv.Itoa(port),
+		Path:   "/" + cfg.Name,
+	}
+	if cfg.Username != "" {
+		u.User = url.UserPassword(cfg.Username, cfg.Password)
+	}
+	if cfg.SSLMode != "" {
+		u.RawQuery = url.Values{"sslmode": {cfg.SSLMode}}.Encode()
+	}
+	return u.String()
+}
+
+// postgresLockID keys the advisory lock migrations and pruning hold.
+const postgresLockID = 0x63617373 // "cass"
+
+var postgresDialect = &dialect{
+	initialSchema: postgresSchema,
+	numbered:      true,
+	lock:          fmt.Sprintf("SELECT pg_advisory_xact_lock(%d)", postgresLockID),
+}
+
+func (s *PostgresStore) migrator() *migrator {
+	return &migrator{db: s.db, dialect: postgresDialect}
+}
+
+// Migrations lists every known migration in order, followed by any
+// recorded migrations this build doesn't know.
+func (s *PostgresStore) Migrations(ctx context.Context) ([]MigrationStatus, error) {
+	return s.migrator().status(ctx)
+}
+
+// MigrateUp applies every pending migration and returns those it applied.
+func (s *PostgresStore) MigrateUp(ctx context.Context) ([]MigrationStatus, error) {
+	return s.migrator().up(ctx)
+}
+
+// MigrateDown reverts the most recently applied migration.
+func (s *PostgresStore) MigrateDown(ctx context.Context) (MigrationStatus, error) {
+	return s.migrator().down(ctx)
+}
+
+// postgresSchema is the SQLite schema of migration 1 in PostgreSQL types.
+const postgresSchema = `
+CREATE TABLE IF NOT EXISTS users (
+	id TEXT PRIMARY KEY,
+	username TEXT UNIQUE NOT NULL,
+	email TEXT UNIQUE NOT NULL,
+	password_hash TEXT NOT NULL,
+	share_show_username BOOLEAN NOT NULL DEFAULT FALSE,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS devices (
+	id TEXT PRIMARY KEY,
+	user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	name TEXT NOT NULL,
+	last_seen TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS speed_tests (
+	id TEXT PRIMARY KEY,
+	user_id TEXT REFERENCES users(id) ON DELETE SET NULL,
+	device_id TEXT REFERENCES devices(id) ON DELETE SET NULL,
+	status TEXT NOT NULL DEFAULT 'complete',
+	parent_id TEXT NOT NULL DEFAULT '',
+	ip_family TEXT NOT NULL DEFAULT '',
+	timestamp TIMESTAMPTZ NOT NULL,
+	download_mbps DOUBLE PRECISION NOT NULL,
+	upload_mbps DOUBLE PRECISION NOT NULL,
+	ping_ms DOUBLE PRECISION NOT NULL,
+	jitter_ms DOUBLE PRECISION NOT NULL,
+	packet_loss DOUBLE PRECISION NOT NULL,
+	ping_download_ms DOUBLE PRECISION NOT NULL DEFAULT 0,
+	ping_upload_ms DOUBLE PRECISION NOT NULL DEFAULT 0,
+	duration INTEGER NOT NULL DEFAULT 0,
+	streams INTEGER NOT NULL DEFAULT 0,
+	stages TEXT NOT NULL DEFAULT '',
+	payload_size INTEGER NOT NULL DEFAULT 0,
+	adaptive BOOLEAN NOT NULL DEFAULT FALSE,
+	transport TEXT NOT NULL DEFAULT '',
+	download_stop_reason TEXT NOT NULL DEFAULT '',
+	upload_stop_reason TEXT NOT NULL DEFAULT '',
+	download_stats TEXT NOT NULL DEFAULT '',
+	upload_stats TEXT NOT NULL DEFAULT '',
+	download_tcp TEXT NOT NULL DEFAULT '',
+	upload_tcp TEXT NOT NULL DEFAULT '',
+	udp_stats TEXT NOT NULL DEFAULT '',
+	setup_stats TEXT NOT NULL DEFAULT '',
+	client_ip_hash TEXT NOT NULL,
+	user_agent TEXT,
+	server_id TEXT,
+	share_code TEXT UNIQUE,
+	share_views INTEGER NOT NULL DEFAULT 0,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS test_sessions (
+	id TEXT PRIMARY KEY,
+	status TEXT NOT NULL,
+	parent_id TEXT NOT NULL DEFAULT '',
+	ip_family TEXT NOT NULL DEFAULT '',
+	dual_stack BOOLEAN NOT NULL DEFAULT FALSE,
+	streams INTEGER NOT NULL,
+	duration INTEGER NOT NULL,
+	stages TEXT NOT NULL DEFAULT '',
+	payload_size INTEGER NOT NULL DEFAULT 0,
+	loaded_latency BOOLEAN NOT NULL DEFAULT FALSE,
+	adaptive BOOLEAN NOT NULL DEFAULT FALSE,
+	transport TEXT NOT NULL DEFAULT '',
+	share BOOLEAN NOT NULL DEFAULT TRUE,
+	client_ip_hash TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS api_tokens (
+	id TEXT PRIMARY KEY,
+	user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	token TEXT UNIQUE NOT NULL,
+	name TEXT NOT NULL DEFAULT '',
+	last_used TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS sessions (
+	id TEXT PRIMARY KEY,
+	user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	data TEXT NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_speed_tests_user ON speed_tests(user_id);
+CREATE INDEX IF NOT EXISTS idx_speed_tests_device ON speed_tests(device_id);
+CREATE INDEX IF NOT EXISTS idx_speed_tests_share ON speed_tests(share_code);
+CREATE INDEX IF NOT EXISTS idx_speed_tests_timestamp ON speed_tests(timestamp);
+CREATE INDEX IF NOT EXISTS idx_sessions_expires ON sessions(expires_at);
+CREATE INDEX IF NOT EXISTS idx_test_sessions_expires ON test_sessions(expires_at);
+
+CREATE TABLE IF NOT EXISTS admins (
+	id SERIAL PRIMARY KEY,
+	username TEXT NOT NULL UNIQUE,
+	password TEXT NOT NULL,
+	email TEXT,
+	role TEXT NOT NULL DEFAULT 'admin',
+	enabled BOOLEAN NOT NULL DEFAULT TRUE,
+	api_token_hash TEXT,
+	created_at BIGINT NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW())::BIGINT,
+	updated_at BIGINT NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW())::BIGINT,
+	last_login BIGINT,
+	failed_attempts INTEGER NOT NULL DEFAULT 0,
+	locked_until BIGINT,
+	source TEXT NOT NULL DEFAULT 'local',
+	external_id TEXT,
+	groups TEXT,
+	last_sync BIGINT
+);
+
+CREATE TABLE IF NOT EXISTS admin_sessions (
+	id TEXT PRIMARY KEY,
+	admin_id INTEGER NOT NULL,
+	ip_address TEXT NOT NULL,
+	user_agent TEXT,
+	created_at BIGINT NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW())::BIGINT,
+	expires_at BIGINT NOT NULL,
+	last_active BIGINT NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW())::BIGINT
+);
+
+CREATE INDEX IF NOT EXISTS idx_admin_sessions_admin ON admin_sessions(admin_id);
+CREATE INDEX IF NOT EXISTS idx_admin_sessions_expires ON admin_sessions(expires_at);
+`
+
+func (s *PostgresStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *PostgresStore) CreateUser(ctx context.Context, user *model.User) error {
+	query := `INSERT INTO users (id, username, email, password_hash, share_show_username, created_at) VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err := s.db.ExecContext(ctx, query, user.ID, user.Username, user.Email, user.PasswordHash, user.ShareShowUsername, user.CreatedAt)
+	return err
+}
+
+func (s *PostgresStore) getUser(ctx context.Context, where string, arg string) (*model.User, error) {
+	user := &model.User{}
+	query := `SELECT id, username, email, password_hash, share_show_username, created_at FROM users WHERE ` + where + ` = $1`
+	err := s.db.QueryRowContext(ctx, query, arg).Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.ShareShowUsername, &user.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return user, err
+}
+
+func (s *PostgresStore) GetUser(ctx context.Context, id string) (*model.User, error) {
+	return s.getUser(ctx, "id", id)
+}
+
+func (s *PostgresStore) GetUserByUsername(ctx context.Context, username string) (*model.User, error) {
+	return s.getUser(ctx, "username", username)
+}
+
+func (s *PostgresStore) GetUserByEmail(ctx context.Context, email string) (*model.User, error) {
+	return s.getUser(ctx, "email", email)
+}
+
+func (s *PostgresStore) UpdateUser(ctx context.Context, user *model.User) error {
+	query := `UPDATE users SET username = $1, email = $2, password_hash = $3, share_show_username = $4 WHERE id = $5`
+	_, err := s.db.ExecContext(ctx, query, user.Username, user.Email, user.PasswordHash, user.ShareShowUsername, user.ID)
+	return err
+}
+
+func (s *PostgresStore) DeleteUser(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, id)
+	return err
+}
+
+func (s *PostgresStore) CreateDevice(ctx context.Context, device *model.Device) error {
+	query := `INSERT INTO devices (id, user_id, name, last_seen, created_at) VALUES ($1, $2, $3, $4, $5)`
+	_, err := s.db.ExecContext(ctx, query, device.ID, device.UserID, device.Name, device.LastSeen, device.CreatedAt)
+	return err
+}
+
+func (s *PostgresStore) GetDevice(ctx context.Context, id string) (*model.Device, error) {
+	device := &model.Device{}
+	var lastSeen sql.NullTime
+	query := `SELECT id, user_id, name, last_seen, created_at FROM devices WHERE id = $1`
+	err := s.db.QueryRowContext(ctx, query, id).Scan(&device.ID, &device.UserID, &device.Name, &lastSeen, &device.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	device.LastSeen = lastSeen.Time
+	return device, err
+}
+
+func (s *PostgresStore) GetUserDevices(ctx context.Context, userID string) ([]*model.Device, error) {
+	query := `SELECT id, user_id, name, last_seen, created_at FROM devices WHERE user_id = $1 ORDER BY created_at DESC`
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var devices []*model.Device
+	for rows.Next() {
+		device := &model.Device{}
+		var lastSeen sql.NullTime
+		if err := rows.Scan(&device.ID, &device.UserID, &device.Name, &lastSeen, &device.CreatedAt); err != nil {
+			return nil, err
+		}
+		device.LastSeen = lastSeen.Time
+		devices = append(devices, device)
+	}
+	return devices, rows.Err()
+}
+
+func (s *PostgresStore) UpdateDevice(ctx context.Context, device *model.Device) error {
+	query := `UPDATE devices SET name = $1, last_seen = $2 WHERE id = $3`
+	_, err := s.db.ExecContext(ctx, query, device.Name, device.LastSeen, device.ID)
+	return err
+}
+
+func (s *PostgresStore) DeleteDevice(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM devices WHERE id = $1`, id)
+	return err
+}
+
+func (s *PostgresStore) querySpeedTests(ctx context.Context, query string, args ...interface{}) ([]*model.SpeedTest, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tests []*model.SpeedTest
+	for rows.Next() {
+		test, err := scanSpeedTest(rows)
+		if err != nil {
+			return nil, err
+		}
+		tests = append(tests, test)
+	}
+	return tests, rows.Err()
+}
+
+// CreateSpeedTest stores anonymous results with NULL user and device ids,
+// which PostgreSQL's foreign keys require.
+func (s *PostgresStore) CreateSpeedTest(ctx context.Context, test *model.SpeedTest) error {
+	query := `INSERT INTO speed_tests (` + speedTestColumns + `)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25, $26, $27, $28, $29, $30, $31, $32, $33, $34, $35)`
+	_, err := s.db.ExecContext(ctx, query, test.ID, test.Status, test.ParentID, test.IPFamily, nullString(test.UserID), nullString(test.DeviceID), test.Timestamp, test.DownloadMbps, test.UploadMbps, test.PingMs, test.JitterMs, test.PacketLoss, test.PingDownloadMs, test.PingUploadMs, test.Duration, test.Streams, test.Stages, test.PayloadSize, test.Adaptive, test.Transport, test.DownloadStopReason, test.UploadStopReason, encodeStats(test.DownloadStats), encodeStats(test.UploadStats), encodeStats(test.DownloadTCP), encodeStats(test.UploadTCP), encodeStats(test.UDP), encodeStats(test.Setup), test.ClientIPHash, test.UserAgent, test.Source, test.ServerID, nullString(test.ShareCode), test.ShareViews, test.CreatedAt)
+	return err
+}
+
+func (s *PostgresStore) GetSpeedTest(ctx context.Context, id string) (*model.SpeedTest, error) {
+	query := `SELECT ` + speedTestColumns + ` FROM speed_tests WHERE id = $1`
+	test, err := scanSpeedTest(s.db.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return test, err
+}
+
+func (s *PostgresStore) GetSpeedTestByShareCode(ctx context.Context, shareCode string) (*model.SpeedTest, error) {
+	query := `SELECT ` + speedTestColumns + ` FROM speed_tests WHERE share_code = $1`
+	test, err := scanSpeedTest(s.db.QueryRowContext(ctx, query, shareCode))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return test, err
+}
+
+func (s *PostgresStore) GetUserSpeedTests(ctx context.Context, userID string, limit, offset int) ([]*model.SpeedTest, error) {
+	query := `SELECT ` + speedTestColumns + ` FROM speed_tests WHERE user_id = $1 ORDER BY timestamp DESC LIMIT $2 OFFSET $3`
+	return s.querySpeedTests(ctx, query, userID, limit, offset)
+}
+
+func (s *PostgresStore) GetDeviceSpeedTests(ctx context.Context, deviceID string, limit, offset int) ([]*model.SpeedTest, error) {
+	query := `SELECT ` + speedTestColumns + ` FROM speed_tests WHERE device_id = $1 ORDER BY timestamp DESC LIMIT $2 OFFSET $3`
+	return s.querySpeedTests(ctx, query, deviceID, limit, offset)
+}
+
+func (s *PostgresStore) UpdateSpeedTest(ctx context.Context, test *model.SpeedTest) error {
+	query := `UPDATE speed_tests SET share_code = $1, share_views = $2 WHERE id = $3`
+	_, err := s.db.ExecContext(ctx, query, nullString(test.ShareCode), test.ShareViews, test.ID)
+	return err
+}
+
+func (s *PostgresStore) DeleteSpeedTest(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM speed_tests WHERE id = $1`, id)
+	return err
+}
+
+func (s *PostgresStore) IncrementShareViews(ctx context.Context, shareCode string) error {
+	query := `UPDATE speed_tests SET share_views = share_views + 1 WHERE share_code = $1`
+	_, err := s.db.ExecContext(ctx, query, shareCode)
+	return err
+}
+
+func (s *PostgresStore) PruneSpeedTests(ctx context.Context, opts PruneOptions) (int, error) {
+	return pruneSpeedTests(ctx, s.db, postgresDialect, opts)
+}
+
+func (s *PostgresStore) GetSpeedTestTotals(ctx context.Context, filter StatsFilter, splits []time.Time) ([]*model.SpeedTestTotals, error) {
+	return getSpeedTestTotals(ctx, s.db, postgresDialect, filter, splits)
+}
+
+func (s *PostgresStore) GetSpeedTestDays(ctx context.Context, filter StatsFilter) ([]*model.SpeedTestDay, error) {
+	return getSpeedTestDays(ctx, s.db, postgresDialect, filter)
+}
+
+func (s *PostgresStore) GetSpeedTestDayTotals(ctx context.Context, filter StatsFilter, splits []time.Time) ([]*model.SpeedTestTotals, error) {
+	return getSpeedTestDayTotals(ctx, s.db, postgresDialect, filter, splits)
+}
+
+func (s *PostgresStore) CreateTestSession(ctx context.Context, session *model.TestSession) error {
+	query := `INSERT INTO test_sessions (` + testSessionColumns + `) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)`
+	_, err := s.db.ExecContext(ctx, query, session.ID, session.Status, session.ParentID, session.IPFamily, session.DualStack, session.Streams, session.Duration, session.Stages, session.PayloadSize, session.LoadedLatency, session.Adaptive, session.Transport, session.Share, session.ClientIPHash, session.CreatedAt, session.ExpiresAt)
+	return err
+}
+
+func (s *PostgresStore) GetTestSession(ctx context.Context, id string) (*model.TestSession, error) {
+	query := `SELECT ` + testSessionColumns + ` FROM test_sessions WHERE id = $1`
+	session, err := scanTestSession(s.db.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return session, err
+}
+
+// GetChildTestSessions returns the runs of a dual-stack test, IPv4 first.
+func (s *PostgresStore) GetChildTestSessions(ctx context.Context, parentID string) ([]*model.TestSession, error) {
+	query := `SELECT ` + testSessionColumns + ` FROM test_sessions WHERE parent_id = $1 ORDER BY ip_family`
+	rows, err := s.db.QueryContext(ctx, query, parentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []*model.TestSession
+	for rows.Next() {
+		session, err := scanTestSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+func (s *PostgresStore) UpdateTestSessionStatus(ctx context.Context, id, status string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE test_sessions SET status = $1 WHERE id = $2`, status, id)
+	return err
+}
+
+func (s *PostgresStore) CreateAPIToken(ctx context.Context, token *model.APIToken) error {
+	query := `INSERT INTO api_tokens (id, user_id, token, name, last_used, created_at) VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err := s.db.ExecContext(ctx, query, token.ID, token.UserID, token.Token, token.Name, nullTime(token.LastUsed), token.CreatedAt)
+	return err
+}
+
+func (s *PostgresStore) getAPIToken(ctx context.Context, where, arg string) (*model.APIToken, error) {
+	token := &model.APIToken{}
+	var lastUsed sql.NullTime
+	query := `SELECT id, user_id, token, name, last_used, created_at FROM api_tokens WHERE ` + where + ` = $1`
+	err := s.db.QueryRowContext(ctx, query, arg).Scan(&token.ID, &token.UserID, &token.Token, &token.Name, &lastUsed, &token.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	token.LastUsed = lastUsed.Time
+	return token, err
+}
+
+func (s *PostgresStore) GetAPIToken(ctx context.Context, id string) (*model.APIToken, error) {
+	return s.getAPIToken(ctx, "id", id)
+}
+
+func (s *PostgresStore) GetAPITokenByToken(ctx context.Context, tokenStr string) (*model.APIToken, error) {
+	return s.getAPIToken(ctx, "token", tokenStr)
+}
+
+func (s *PostgresStore) GetUserAPITokens(ctx context.Context, userID string) ([]*model.APIToken, error) {
+	query := `SELECT id, user_id, token, name, last_used, created_at FROM api_tokens WHERE user_id = $1 ORDER BY created_at DESC`
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []*model.APIToken
+	for rows.Next() {
+		token := &model.APIToken{}
+		var lastUsed sql.NullTime
+		if err := rows.Scan(&token.ID, &token.UserID, &token.Token, &token.Name, &lastUsed, &token.CreatedAt); err != nil {
+			return nil, err
+		}
+		token.LastUsed = lastUsed.Time
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+func (s *PostgresStore) UpdateAPIToken(ctx context.Context, token *model.APIToken) error {
+	query := `UPDATE api_tokens SET name = $1, last_used = $2 WHERE id = $3`
+	_, err := s.db.ExecContext(ctx, query, token.Name, nullTime(token.LastUsed), token.ID)
+	return err
+}
+
+// nullTime stores zero times as NULL.
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+func (s *PostgresStore) DeleteAPIToken(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM api_tokens WHERE id = $1`, id)
+	return err
+}
+
+func (s *PostgresStore) CreateSession(ctx context.Context, session *model.Session) error {
+	query := `INSERT INTO sessions (id, user_id, data, expires_at, created_at) VALUES ($1, $2, $3, $4, $5)`
+	_, err := s.db.ExecContext(ctx, query, session.ID, session.UserID, session.Data, session.ExpiresAt, session.CreatedAt)
+	return err
+}
+
+func (s *PostgresStore) GetSession(ctx context.Context, id string) (*model.Session, error) {
+	session := &model.Session{}
+	query := `SELECT id, user_id, data, expires_at, created_at FROM sessions WHERE id = $1 AND expires_at > $2`
+	err := s.db.QueryRowContext(ctx, query, id, time.Now()).Scan(&session.ID, &session.UserID, &session.Data, &session.ExpiresAt, &session.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return session, err
+}
+
+func (s *PostgresStore) DeleteSession(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM sessions WHERE id = $1`, id)
+	return err
+}
+
+func (s *PostgresStore) DeleteExpiredSessions(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM sessions WHERE expires_at < $1`, time.Now())
+	return err
+}
+
+// Admin methods
+func (s *PostgresStore) GetAdminByUsername(ctx context.Context, username string) (*model.Admin, error) {
+	admin := &model.Admin{}
+	var createdAt, updatedAt int64
+	var lastLogin, lockedUntil sql.NullInt64
+	var email, apiTokenHash sql.NullString
+
+	query := `SELECT id, username, password, email, role, enabled, created_at, updated_at, last_login, failed_attempts, locked_until, api_token_hash
+		FROM admins WHERE username = $1`
+	err := s.db.QueryRowContext(ctx, query, username).Scan(
+		&admin.ID, &admin.Username, &admin.Password, &email, &admin.Role, &admin.Enabled,
+		&createdAt, &updatedAt, &lastLogin, &admin.FailedAttempts, &lockedUntil, &apiTokenHash,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	admin.Email = email.String
+	admin.APITokenHash = apiTokenHash.String
+	admin.CreatedAt = time.Unix(createdAt, 0)
+	admin.UpdatedAt = time.Unix(updatedAt, 0)
+	if lastLogin.Int64 > 0 {
+		admin.LastLogin = time.Unix(lastLogin.Int64, 0)
+	}
+	if lockedUntil.Int64 > 0 {
+		admin.LockedUntil = time.Unix(lockedUntil.Int64, 0)
+	}
+
+	return admin, nil
+}
+
+func (s *PostgresStore) CreateAdmin(ctx context.Context, admin *model.Admin) error {
+	query := `INSERT INTO admins (username, password, email, role, enabled) VALUES ($1, $2, $3, $4, $5) RETURNING id`
+	return s.db.QueryRowContext(ctx, query, admin.Username, admin.Password, admin.Email, admin.Role, admin.Enabled).Scan(&admin.ID)
+}
+
+func (s *PostgresStore) UpdateAdminLastLogin(ctx context.Context, adminID int) error {
+	query := `UPDATE admins SET last_login = EXTRACT(EPOCH FROM NOW())::BIGINT, failed_attempts = 0, locked_until = NULL WHERE id = $1`
+	_, err := s.db.ExecContext(ctx, query, adminID)
+	return err
+}
+
+func (s *PostgresStore) UpdateAdminFailedAttempts(ctx context.Context, adminID int, attempts int) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE admins SET failed_attempts = $1 WHERE id = $2`, attempts, adminID)
+	return err
+}
+
+func (s *PostgresStore) LockAdmin(ctx context.Context, adminID int, until time.Time) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE admins SET locked_until = $1 WHERE id = $2`, until.Unix(), adminID)
+	return err
+}
+
+func (s *PostgresStore) CreateAdminSession(ctx context.Context, session *model.AdminSession) error {
+	query := `INSERT INTO admin_sessions (id, admin_id, ip_address, user_agent, expires_at) VALUES ($1, $2, $3, $4, $5)`
+	_, err := s.db.ExecContext(ctx, query, session.ID, session.AdminID, session.IPAddress, session.UserAgent, session.ExpiresAt.Unix())
+	return err
+}
+
+func (s *PostgresStore) GetAdminSession(ctx context.Context, id string) (*model.AdminSession, error) {
+	session := &model.AdminSession{}
+	var createdAt, expiresAt, lastActive int64
+	var userAgent sql.NullString
+
+	query := `SELECT id, admin_id, ip_address, user_agent, created_at, expires_at, last_active
+		FROM admin_sessions WHERE id = $1 AND expires_at > EXTRACT(EPOCH FROM NOW())::BIGINT`
+	err := s.db.QueryRowContext(ctx, query, id).Scan(
+		&session.ID, &session.AdminID, &session.IPAddress, &userAgent,
+		&createdAt, &expiresAt, &lastActive,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	session.UserAgent = userAgent.String
+	session.CreatedAt = time.Unix(createdAt, 0)
+	session.ExpiresAt = time.Unix(expiresAt, 0)
+	session.LastActive = time.Unix(lastActive, 0)
+
+	return session, nil
+}
+
+func (s *PostgresStore) UpdateAdminSessionActivity(ctx context.Context, id string) error {
+	query := `UPDATE admin_sessions SET last_active = EXTRACT(EPOCH FROM NOW())::BIGINT WHERE id = $1`
+	_, err := s.db.ExecContext(ctx, query, id)
+	return err
+}
+
+func (s *PostgresStore) DeleteAdminSession(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM admin_sessions WHERE id = $1`, id)
+	return err
+}
+
+func (s *PostgresStore) DeleteExpiredAdminSessions(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM admin_sessions WHERE expires_at < EXTRACT(EPOCH FROM NOW())::BIGINT`)
+	return err
+}
@@ -0,0 +1,187 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/casapps/casspeed/src/config"
+	"github.com/casapps/casspeed/src/server/model"
+)
+
+// Results older than test.results_retention days are deleted by
+// PruneSpeedTests, which the scheduler runs daily and --maintenance prune
+// runs on demand. With rollups on, complete results are first added to
+// speed_test_days, one row per day, user, device and server. Results with
+// a source, reported by the client, are not rolled up: the aggregates only
+// hold figures the server measured.
+
+// PruneOptions selects the results PruneSpeedTests deletes.
+type PruneOptions struct {
+	Before     time.Time // Results timestamped before this go
+	KeepShared bool      // Keep results with a share code, so links keep working
+	Rollup     bool      // Add complete results to the daily aggregates first
+}
+
+// RetentionOptions is the pruning cfg asks for at now. It reports false
+// when results are kept forever.
+func RetentionOptions(cfg config.TestConfig, now time.Time) (PruneOptions, bool) {
+	if cfg.ResultsRetention <= 0 {
+		return PruneOptions{}, false
+	}
+	return PruneOptions{
+		Before:     now.AddDate(0, 0, -cfg.ResultsRetention),
+		KeepShared: cfg.RetentionKeepShared,
+		Rollup:     cfg.RetentionRollup,
+	}, true
+}
+
+// prunable reports whether opts selects a result.
+func (opts PruneOptions) prunable(test *model.SpeedTest) bool {
+	return test.Timestamp.Before(opts.Before) && !(opts.KeepShared && test.ShareCode != "")
+}
+
+const dayFormat = "2006-01-02"
+
+// dayKey identifies one row of speed_test_days.
+type dayKey struct {
+	day, userID, deviceID, serverID string
+}
+
+func (k dayKey) args() []any {
+	return []any{k.day, k.userID, k.deviceID, k.serverID}
+}
+
+// rollUp adds a complete result to the aggregates in days.
+func rollUp(days map[dayKey]*model.SpeedTestDay, test *model.SpeedTest) {
+	ts := test.Timestamp.UTC()
+	one := &model.SpeedTestDay{
+		Day:      time.Date(ts.Year(), ts.Month(), ts.Day(), 0, 0, 0, 0, time.UTC),
+		UserID:   test.UserID,
+		DeviceID: test.DeviceID,
+		ServerID: test.ServerID,
+		Tests:    1,
+		Download: model.Aggregate{Sum: test.DownloadMbps, Min: test.DownloadMbps, Max: test.DownloadMbps},
+		Upload:   model.Aggregate{Sum: test.UploadMbps, Min: test.UploadMbps, Max: test.UploadMbps},
+		Ping:     model.Aggregate{Sum: test.PingMs, Min: test.PingMs, Max: test.PingMs},
+		Jitter:   model.Aggregate{Sum: test.JitterMs, Min: test.JitterMs, Max: test.JitterMs},
+	}
+	key := dayKey{one.Day.Format(dayFormat), one.UserID, one.DeviceID, one.ServerID}
+	if day, ok := days[key]; ok {
+		mergeDay(day, one)
+	} else {
+		days[key] = one
+	}
+}
+
+// mergeDay adds the results summed up in from to into.
+func mergeDay(into, from *model.SpeedTestDay) {
+	into.Tests += from.Tests
+	mergeAggregate(&into.Download, from.Download)
+	mergeAggregate(&into.Upload, from.Upload)
+	mergeAggregate(&into.Ping, from.Ping)
+	mergeAggregate(&into.Jitter, from.Jitter)
+}
+
+func mergeAggregate(into *model.Aggregate, from model.Aggregate) {
+	into.Sum += from.Sum
+	into.Min = min(into.Min, from.Min)
+	into.Max = max(into.Max, from.Max)
+}
+
+const speedTestDayColumns = `day, user_id, device_id, server_id, tests, download_sum, download_min, download_max, upload_sum, upload_min, upload_max, ping_sum, ping_min, ping_max, jitter_sum, jitter_min, jitter_max`
+
+func scanSpeedTestDay(row rowScanner) (*model.SpeedTestDay, error) {
+	d := &model.SpeedTestDay{}
+	var day string
+	err := row.Scan(&day, &d.UserID, &d.DeviceID, &d.ServerID, &d.Tests, &d.Download.Sum, &d.Download.Min, &d.Download.Max, &d.Upload.Sum, &d.Upload.Min, &d.Upload.Max, &d.Ping.Sum, &d.Ping.Min, &d.Ping.Max, &d.Jitter.Sum, &d.Jitter.Min, &d.Jitter.Max)
+	if err != nil {
+		return nil, err
+	}
+	d.Day, err = time.Parse(dayFormat, day)
+	return d, err
+}
+
+// dayValues are a day's aggregates in speedTestDayColumns order, after
+// the key.
+func dayValues(d *model.SpeedTestDay) []any {
+	return []any{d.Tests, d.Download.Sum, d.Download.Min, d.Download.Max, d.Upload.Sum, d.Upload.Min, d.Upload.Max, d.Ping.Sum, d.Ping.Min, d.Ping.Max, d.Jitter.Sum, d.Jitter.Min, d.Jitter.Max}
+}
+
+// pruneSpeedTests is PruneSpeedTests for the SQL stores. It holds the
+// dialect's lock, so servers sharing a database never roll up the same
+// results twice.
+func pruneSpeedTests(ctx context.Context, db *sql.DB, d *dialect, opts PruneOptions) (int, error) {
+	tx, err := beginLocked(ctx, db, d)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Compared in UTC, as SQLite stores result timestamps
+	before := opts.Before.UTC()
+	where := `timestamp < ?`
+	if opts.KeepShared {
+		where += ` AND COALESCE(share_code, '') = ''`
+	}
+	if opts.Rollup {
+		if err := rollUpSpeedTests(ctx, tx, d, where, before); err != nil {
+			return 0, err
+		}
+	}
+
+	res, err := tx.ExecContext(ctx, d.rebind(`DELETE FROM speed_tests WHERE `+where), before)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(n), tx.Commit()
+}
+
+// rollUpSpeedTests adds the complete results where selects to
+// speed_test_days.
+func rollUpSpeedTests(ctx context.Context, tx *sql.Tx, d *dialect, where string, before time.Time) error {
+	rows, err := tx.QueryContext(ctx, d.rebind(`SELECT user_id, device_id, server_id, timestamp, download_mbps, upload_mbps, ping_ms, jitter_ms
+		FROM speed_tests WHERE status = ? AND source = '' AND `+where), model.TestStatusComplete, before)
+	if err != nil {
+		return err
+	}
+	days := make(map[dayKey]*model.SpeedTestDay)
+	for rows.Next() {
+		var test model.SpeedTest
+		var userID, deviceID, serverID sql.NullString
+		if err := rows.Scan(&userID, &deviceID, &serverID, &test.Timestamp, &test.DownloadMbps, &test.UploadMbps, &test.PingMs, &test.JitterMs); err != nil {
+			rows.Close()
+			return err
+		}
+		test.UserID, test.DeviceID, test.ServerID = userID.String, deviceID.String, serverID.String
+		rollUp(days, &test)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for key, day := range days {
+		stored, err := scanSpeedTestDay(tx.QueryRowContext(ctx, d.rebind(`SELECT `+speedTestDayColumns+` FROM speed_test_days
+			WHERE day = ? AND user_id = ? AND device_id = ? AND server_id = ?`), key.args()...))
+		switch {
+		case err == sql.ErrNoRows:
+			_, err = tx.ExecContext(ctx, d.rebind(`INSERT INTO speed_test_days (`+speedTestDayColumns+`)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`), append(key.args(), dayValues(day)...)...)
+		case err == nil:
+			mergeDay(stored, day)
+			_, err = tx.ExecContext(ctx, d.rebind(`UPDATE speed_test_days SET tests = ?,
+				download_sum = ?, download_min = ?, download_max = ?, upload_sum = ?, upload_min = ?, upload_max = ?,
+				ping_sum = ?, ping_min = ?, ping_max = ?, jitter_sum = ?, jitter_min = ?, jitter_max = ?
+				WHERE day = ? AND user_id = ? AND device_id = ? AND server_id = ?`), append(dayValues(stored), key.args()...)...)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
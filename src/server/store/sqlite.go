@@ -3,6 +3,7 @@ package store
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"time"
 
@@ -15,25 +16,68 @@ type SQLiteStore struct {
 }
 
 func NewSQLiteStore(dbPath string) (*SQLiteStore, error) {
-	db, err := sql.Open("sqlite", dbPath)
+	store, err := OpenSQLiteStore(dbPath)
 	if err != nil {
-		return nil, fmt.Errorf("opening database: %w", err)
+		return nil, err
 	}
 
-	db.SetMaxOpenConns(1)
-	db.SetMaxIdleConns(1)
-
-	store := &SQLiteStore{db: db}
 	if err := store.migrate(); err != nil {
-		db.Close()
+		store.Close()
 		return nil, fmt.Errorf("migrating database: %w", err)
 	}
 
 	return store, nil
 }
 
+// OpenSQLiteStore opens the database without migrating it, for
+// maintenance of its schema.
+func OpenSQLiteStore(dbPath string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("opening database: %w", err)
+	}
+
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(1)
+
+	return &SQLiteStore{db: db}, nil
+}
+
+// migrate brings the database up to the latest schema.
 func (s *SQLiteStore) migrate() error {
-	schema := `
+	_, err := s.MigrateUp(context.Background())
+	return err
+}
+
+var sqliteDialect = &dialect{
+	initialSchema: sqliteSchema,
+	adopt:         adoptSQLiteSchema,
+	textTimes:     true,
+}
+
+func (s *SQLiteStore) migrator() *migrator {
+	return &migrator{db: s.db, dialect: sqliteDialect}
+}
+
+// Migrations lists every known migration in order, followed by any
+// recorded migrations this build doesn't know.
+func (s *SQLiteStore) Migrations(ctx context.Context) ([]MigrationStatus, error) {
+	return s.migrator().status(ctx)
+}
+
+// MigrateUp applies every pending migration and returns those it applied.
+func (s *SQLiteStore) MigrateUp(ctx context.Context) ([]MigrationStatus, error) {
+	return s.migrator().up(ctx)
+}
+
+// MigrateDown reverts the most recently applied migration.
+func (s *SQLiteStore) MigrateDown(ctx context.Context) (MigrationStatus, error) {
+	return s.migrator().down(ctx)
+}
+
+// sqliteSchema is the schema as it stood when migrations were first
+// versioned. Later changes are migrations of their own.
+const sqliteSchema = `
 CREATE TABLE IF NOT EXISTS users (
 	id TEXT PRIMARY KEY,
 	username TEXT UNIQUE NOT NULL,
@@ -56,12 +100,28 @@ CREATE TABLE IF NOT EXISTS speed_tests (
 	id TEXT PRIMARY KEY,
 	user_id TEXT,
 	device_id TEXT,
+	status TEXT NOT NULL DEFAULT 'complete',
 	timestamp TIMESTAMP NOT NULL,
 	download_mbps REAL NOT NULL,
 	upload_mbps REAL NOT NULL,
 	ping_ms REAL NOT NULL,
 	jitter_ms REAL NOT NULL,
 	packet_loss REAL NOT NULL,
+	ping_download_ms REAL NOT NULL DEFAULT 0,
+	ping_upload_ms REAL NOT NULL DEFAULT 0,
+	duration INTEGER NOT NULL DEFAULT 0,
+	streams INTEGER NOT NULL DEFAULT 0,
+	stages TEXT NOT NULL DEFAULT '',
+	payload_size INTEGER NOT NULL DEFAULT 0,
+	adaptive INTEGER NOT NULL DEFAULT 0,
+	download_stop_reason TEXT NOT NULL DEFAULT '',
+	upload_stop_reason TEXT NOT NULL DEFAULT '',
+	download_stats TEXT NOT NULL DEFAULT '',
+	upload_stats TEXT NOT NULL DEFAULT '',
+	download_tcp TEXT NOT NULL DEFAULT '',
+	upload_tcp TEXT NOT NULL DEFAULT '',
+	udp_stats TEXT NOT NULL DEFAULT '',
+	setup_stats TEXT NOT NULL DEFAULT '',
 	client_ip_hash TEXT NOT NULL,
 	user_agent TEXT,
 	server_id TEXT,
@@ -72,6 +132,21 @@ CREATE TABLE IF NOT EXISTS speed_tests (
 	FOREIGN KEY (device_id) REFERENCES devices(id) ON DELETE SET NULL
 );
 
+CREATE TABLE IF NOT EXISTS test_sessions (
+	id TEXT PRIMARY KEY,
+	status TEXT NOT NULL,
+	streams INTEGER NOT NULL,
+	duration INTEGER NOT NULL,
+	stages TEXT NOT NULL DEFAULT '',
+	payload_size INTEGER NOT NULL DEFAULT 0,
+	loaded_latency INTEGER NOT NULL DEFAULT 0,
+	adaptive INTEGER NOT NULL DEFAULT 0,
+	share INTEGER NOT NULL DEFAULT 1,
+	client_ip_hash TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	expires_at TIMESTAMP NOT NULL
+);
+
 CREATE TABLE IF NOT EXISTS api_tokens (
 	id TEXT PRIMARY KEY,
 	user_id TEXT NOT NULL,
@@ -96,6 +171,7 @@ CREATE INDEX IF NOT EXISTS idx_speed_tests_device ON speed_tests(device_id);
 CREATE INDEX IF NOT EXISTS idx_speed_tests_share ON speed_tests(share_code);
 CREATE INDEX IF NOT EXISTS idx_speed_tests_timestamp ON speed_tests(timestamp);
 CREATE INDEX IF NOT EXISTS idx_sessions_expires ON sessions(expires_at);
+CREATE INDEX IF NOT EXISTS idx_test_sessions_expires ON test_sessions(expires_at);
 
 CREATE TABLE IF NOT EXISTS admins (
 	id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -132,8 +208,82 @@ CREATE INDEX IF NOT EXISTS idx_admin_sessions_admin ON admin_sessions(admin_id);
 CREATE INDEX IF NOT EXISTS idx_admin_sessions_expires ON admin_sessions(expires_at);
 `
 
-	_, err := s.db.Exec(schema)
-	return err
+// speedTestAddedColumns lists columns added to speed_tests before
+// migrations were versioned, so databases from then pick them up.
+var speedTestAddedColumns = []struct{ name, definition string }{
+	{"ping_download_ms", "REAL NOT NULL DEFAULT 0"},
+	{"ping_upload_ms", "REAL NOT NULL DEFAULT 0"},
+	{"duration", "INTEGER NOT NULL DEFAULT 0"},
+	{"streams", "INTEGER NOT NULL DEFAULT 0"},
+	{"stages", "TEXT NOT NULL DEFAULT ''"},
+	{"payload_size", "INTEGER NOT NULL DEFAULT 0"},
+	{"adaptive", "INTEGER NOT NULL DEFAULT 0"},
+	{"download_stop_reason", "TEXT NOT NULL DEFAULT ''"},
+	{"upload_stop_reason", "TEXT NOT NULL DEFAULT ''"},
+	{"download_stats", "TEXT NOT NULL DEFAULT ''"},
+	{"upload_stats", "TEXT NOT NULL DEFAULT ''"},
+	{"download_tcp", "TEXT NOT NULL DEFAULT ''"},
+	{"upload_tcp", "TEXT NOT NULL DEFAULT ''"},
+	{"udp_stats", "TEXT NOT NULL DEFAULT ''"},
+	{"status", "TEXT NOT NULL DEFAULT 'complete'"},
+	{"parent_id", "TEXT NOT NULL DEFAULT ''"},
+	{"ip_family", "TEXT NOT NULL DEFAULT ''"},
+	{"transport", "TEXT NOT NULL DEFAULT ''"},
+	{"setup_stats", "TEXT NOT NULL DEFAULT ''"},
+}
+
+// testSessionAddedColumns does the same for test_sessions.
+var testSessionAddedColumns = []struct{ name, definition string }{
+	{"stages", "TEXT NOT NULL DEFAULT ''"},
+	{"payload_size", "INTEGER NOT NULL DEFAULT 0"},
+	{"loaded_latency", "INTEGER NOT NULL DEFAULT 0"},
+	{"adaptive", "INTEGER NOT NULL DEFAULT 0"},
+	{"parent_id", "TEXT NOT NULL DEFAULT ''"},
+	{"ip_family", "TEXT NOT NULL DEFAULT ''"},
+	{"dual_stack", "INTEGER NOT NULL DEFAULT 0"},
+	{"transport", "TEXT NOT NULL DEFAULT ''"},
+}
+
+// adoptSQLiteSchema adds the columns databases from before versioned
+// migrations lack.
+func adoptSQLiteSchema(ctx context.Context, tx *sql.Tx) error {
+	if err := addMissingColumns(ctx, tx, "speed_tests", speedTestAddedColumns); err != nil {
+		return err
+	}
+	return addMissingColumns(ctx, tx, "test_sessions", testSessionAddedColumns)
+}
+
+// addMissingColumns adds any of the given columns not yet present on table.
+func addMissingColumns(ctx context.Context, tx *sql.Tx, table string, columns []struct{ name, definition string }) error {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, col := range columns {
+		if existing[col.name] {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, col.name, col.definition)); err != nil {
+			return fmt.Errorf("adding column %s.%s: %w", table, col.name, err)
+		}
+	}
+	return nil
 }
 
 func (s *SQLiteStore) Close() error {
@@ -234,55 +384,58 @@ func (s *SQLiteStore) DeleteDevice(ctx context.Context, id string) error {
 	return err
 }
 
-func (s *SQLiteStore) CreateSpeedTest(ctx context.Context, test *model.SpeedTest) error {
-	query := `INSERT INTO speed_tests (id, user_id, device_id, timestamp, download_mbps, upload_mbps, ping_ms, jitter_ms, packet_loss, client_ip_hash, user_agent, server_id, share_code, share_views, created_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
-	_, err := s.db.ExecContext(ctx, query, test.ID, test.UserID, test.DeviceID, test.Timestamp, test.DownloadMbps, test.UploadMbps, test.PingMs, test.JitterMs, test.PacketLoss, test.ClientIPHash, test.UserAgent, test.ServerID, test.ShareCode, test.ShareViews, test.CreatedAt)
-	return err
-}
+const speedTestColumns = `id, status, parent_id, ip_family, user_id, device_id, timestamp, download_mbps, upload_mbps, ping_ms, jitter_ms, packet_loss, ping_download_ms, ping_upload_ms, duration, streams, stages, payload_size, adaptive, transport, download_stop_reason, upload_stop_reason, download_stats, upload_stats, download_tcp, upload_tcp, udp_stats, setup_stats, client_ip_hash, user_agent, source, server_id, share_code, share_views, created_at`
 
-func (s *SQLiteStore) GetSpeedTest(ctx context.Context, id string) (*model.SpeedTest, error) {
-	test := &model.SpeedTest{}
-	query := `SELECT id, user_id, device_id, timestamp, download_mbps, upload_mbps, ping_ms, jitter_ms, packet_loss, client_ip_hash, user_agent, server_id, share_code, share_views, created_at FROM speed_tests WHERE id = ?`
-	err := s.db.QueryRowContext(ctx, query, id).Scan(&test.ID, &test.UserID, &test.DeviceID, &test.Timestamp, &test.DownloadMbps, &test.UploadMbps, &test.PingMs, &test.JitterMs, &test.PacketLoss, &test.ClientIPHash, &test.UserAgent, &test.ServerID, &test.ShareCode, &test.ShareViews, &test.CreatedAt)
-	if err == sql.ErrNoRows {
-		return nil, nil
-	}
-	return test, err
+type rowScanner interface {
+	Scan(dest ...interface{}) error
 }
 
-func (s *SQLiteStore) GetSpeedTestByShareCode(ctx context.Context, shareCode string) (*model.SpeedTest, error) {
+func scanSpeedTest(row rowScanner) (*model.SpeedTest, error) {
 	test := &model.SpeedTest{}
-	query := `SELECT id, user_id, device_id, timestamp, download_mbps, upload_mbps, ping_ms, jitter_ms, packet_loss, client_ip_hash, user_agent, server_id, share_code, share_views, created_at FROM speed_tests WHERE share_code = ?`
-	
-	var userID, deviceID, userAgent, serverID sql.NullString
-	err := s.db.QueryRowContext(ctx, query, shareCode).Scan(&test.ID, &userID, &deviceID, &test.Timestamp, &test.DownloadMbps, &test.UploadMbps, &test.PingMs, &test.JitterMs, &test.PacketLoss, &test.ClientIPHash, &userAgent, &serverID, &test.ShareCode, &test.ShareViews, &test.CreatedAt)
-	if err == sql.ErrNoRows {
-		return nil, nil
-	}
+	var userID, deviceID, userAgent, serverID, shareCode sql.NullString
+	var downloadStats, uploadStats, downloadTCP, uploadTCP, udpStats, setupStats string
+	err := row.Scan(&test.ID, &test.Status, &test.ParentID, &test.IPFamily, &userID, &deviceID, &test.Timestamp, &test.DownloadMbps, &test.UploadMbps, &test.PingMs, &test.JitterMs, &test.PacketLoss, &test.PingDownloadMs, &test.PingUploadMs, &test.Duration, &test.Streams, &test.Stages, &test.PayloadSize, &test.Adaptive, &test.Transport, &test.DownloadStopReason, &test.UploadStopReason, &downloadStats, &uploadStats, &downloadTCP, &uploadTCP, &udpStats, &setupStats, &test.ClientIPHash, &userAgent, &test.Source, &serverID, &shareCode, &test.ShareViews, &test.CreatedAt)
 	if err != nil {
 		return nil, err
 	}
-	
-	if userID.Valid {
-		test.UserID = userID.String
-	}
-	if deviceID.Valid {
-		test.DeviceID = deviceID.String
+
+	test.UserID = userID.String
+	test.DeviceID = deviceID.String
+	test.UserAgent = userAgent.String
+	test.ServerID = serverID.String
+	test.ShareCode = shareCode.String
+	test.DownloadStats = decodeStats[model.ThroughputStats](downloadStats)
+	test.UploadStats = decodeStats[model.ThroughputStats](uploadStats)
+	test.DownloadTCP = decodeStats[model.TCPStats](downloadTCP)
+	test.UploadTCP = decodeStats[model.TCPStats](uploadTCP)
+	test.UDP = decodeStats[model.UDPStats](udpStats)
+	test.Setup = decodeStats[model.SetupStats](setupStats)
+
+	return test, nil
+}
+
+// encodeStats stores a stats summary as JSON, or "" when there is none.
+func encodeStats[T any](stats *T) string {
+	if stats == nil {
+		return ""
 	}
-	if userAgent.Valid {
-		test.UserAgent = userAgent.String
+	data, _ := json.Marshal(stats)
+	return string(data)
+}
+
+func decodeStats[T any](data string) *T {
+	if data == "" {
+		return nil
 	}
-	if serverID.Valid {
-		test.ServerID = serverID.String
+	stats := new(T)
+	if err := json.Unmarshal([]byte(data), stats); err != nil {
+		return nil
 	}
-	
-	return test, nil
+	return stats
 }
 
-func (s *SQLiteStore) GetUserSpeedTests(ctx context.Context, userID string, limit, offset int) ([]*model.SpeedTest, error) {
-	query := `SELECT id, user_id, device_id, timestamp, download_mbps, upload_mbps, ping_ms, jitter_ms, packet_loss, client_ip_hash, user_agent, server_id, share_code, share_views, created_at FROM speed_tests WHERE user_id = ? ORDER BY timestamp DESC LIMIT ? OFFSET ?`
-	rows, err := s.db.QueryContext(ctx, query, userID, limit, offset)
+func (s *SQLiteStore) querySpeedTests(ctx context.Context, query string, args ...interface{}) ([]*model.SpeedTest, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -290,8 +443,8 @@ func (s *SQLiteStore) GetUserSpeedTests(ctx context.Context, userID string, limi
 
 	var tests []*model.SpeedTest
 	for rows.Next() {
-		test := &model.SpeedTest{}
-		if err := rows.Scan(&test.ID, &test.UserID, &test.DeviceID, &test.Timestamp, &test.DownloadMbps, &test.UploadMbps, &test.PingMs, &test.JitterMs, &test.PacketLoss, &test.ClientIPHash, &test.UserAgent, &test.ServerID, &test.ShareCode, &test.ShareViews, &test.CreatedAt); err != nil {
+		test, err := scanSpeedTest(rows)
+		if err != nil {
 			return nil, err
 		}
 		tests = append(tests, test)
@@ -299,28 +452,52 @@ func (s *SQLiteStore) GetUserSpeedTests(ctx context.Context, userID string, limi
 	return tests, rows.Err()
 }
 
-func (s *SQLiteStore) GetDeviceSpeedTests(ctx context.Context, deviceID string, limit, offset int) ([]*model.SpeedTest, error) {
-	query := `SELECT id, user_id, device_id, timestamp, download_mbps, upload_mbps, ping_ms, jitter_ms, packet_loss, client_ip_hash, user_agent, server_id, share_code, share_views, created_at FROM speed_tests WHERE device_id = ? ORDER BY timestamp DESC LIMIT ? OFFSET ?`
-	rows, err := s.db.QueryContext(ctx, query, deviceID, limit, offset)
-	if err != nil {
-		return nil, err
+// CreateSpeedTest stores the result's timestamp in UTC, so that it compares
+// as text with every other one.
+func (s *SQLiteStore) CreateSpeedTest(ctx context.Context, test *model.SpeedTest) error {
+	query := `INSERT INTO speed_tests (` + speedTestColumns + `)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err := s.db.ExecContext(ctx, query, test.ID, test.Status, test.ParentID, test.IPFamily, test.UserID, test.DeviceID, test.Timestamp.UTC(), test.DownloadMbps, test.UploadMbps, test.PingMs, test.JitterMs, test.PacketLoss, test.PingDownloadMs, test.PingUploadMs, test.Duration, test.Streams, test.Stages, test.PayloadSize, test.Adaptive, test.Transport, test.DownloadStopReason, test.UploadStopReason, encodeStats(test.DownloadStats), encodeStats(test.UploadStats), encodeStats(test.DownloadTCP), encodeStats(test.UploadTCP), encodeStats(test.UDP), encodeStats(test.Setup), test.ClientIPHash, test.UserAgent, test.Source, test.ServerID, nullString(test.ShareCode), test.ShareViews, test.CreatedAt)
+	return err
+}
+
+// nullString stores empty strings as NULL so optional UNIQUE columns such
+// as share_code don't collide on "".
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func (s *SQLiteStore) GetSpeedTest(ctx context.Context, id string) (*model.SpeedTest, error) {
+	query := `SELECT ` + speedTestColumns + ` FROM speed_tests WHERE id = ?`
+	test, err := scanSpeedTest(s.db.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
 	}
-	defer rows.Close()
+	return test, err
+}
 
-	var tests []*model.SpeedTest
-	for rows.Next() {
-		test := &model.SpeedTest{}
-		if err := rows.Scan(&test.ID, &test.UserID, &test.DeviceID, &test.Timestamp, &test.DownloadMbps, &test.UploadMbps, &test.PingMs, &test.JitterMs, &test.PacketLoss, &test.ClientIPHash, &test.UserAgent, &test.ServerID, &test.ShareCode, &test.ShareViews, &test.CreatedAt); err != nil {
-			return nil, err
-		}
-		tests = append(tests, test)
+func (s *SQLiteStore) GetSpeedTestByShareCode(ctx context.Context, shareCode string) (*model.SpeedTest, error) {
+	query := `SELECT ` + speedTestColumns + ` FROM speed_tests WHERE share_code = ?`
+	test, err := scanSpeedTest(s.db.QueryRowContext(ctx, query, shareCode))
+	if err == sql.ErrNoRows {
+		return nil, nil
 	}
-	return tests, rows.Err()
+	return test, err
+}
+
+func (s *SQLiteStore) GetUserSpeedTests(ctx context.Context, userID string, limit, offset int) ([]*model.SpeedTest, error) {
+	query := `SELECT ` + speedTestColumns + ` FROM speed_tests WHERE user_id = ? ORDER BY timestamp DESC LIMIT ? OFFSET ?`
+	return s.querySpeedTests(ctx, query, userID, limit, offset)
+}
+
+func (s *SQLiteStore) GetDeviceSpeedTests(ctx context.Context, deviceID string, limit, offset int) ([]*model.SpeedTest, error) {
+	query := `SELECT ` + speedTestColumns + ` FROM speed_tests WHERE device_id = ? ORDER BY timestamp DESC LIMIT ? OFFSET ?`
+	return s.querySpeedTests(ctx, query, deviceID, limit, offset)
 }
 
 func (s *SQLiteStore) UpdateSpeedTest(ctx context.Context, test *model.SpeedTest) error {
 	query := `UPDATE speed_tests SET share_code = ?, share_views = ? WHERE id = ?`
-	_, err := s.db.ExecContext(ctx, query, test.ShareCode, test.ShareViews, test.ID)
+	_, err := s.db.ExecContext(ctx, query, nullString(test.ShareCode), test.ShareViews, test.ID)
 	return err
 }
 
@@ -335,6 +512,70 @@ func (s *SQLiteStore) IncrementShareViews(ctx context.Context, shareCode string)
 	return err
 }
 
+func (s *SQLiteStore) PruneSpeedTests(ctx context.Context, opts PruneOptions) (int, error) {
+	return pruneSpeedTests(ctx, s.db, sqliteDialect, opts)
+}
+
+func (s *SQLiteStore) GetSpeedTestTotals(ctx context.Context, filter StatsFilter, splits []time.Time) ([]*model.SpeedTestTotals, error) {
+	return getSpeedTestTotals(ctx, s.db, sqliteDialect, filter, splits)
+}
+
+func (s *SQLiteStore) GetSpeedTestDays(ctx context.Context, filter StatsFilter) ([]*model.SpeedTestDay, error) {
+	return getSpeedTestDays(ctx, s.db, sqliteDialect, filter)
+}
+
+func (s *SQLiteStore) GetSpeedTestDayTotals(ctx context.Context, filter StatsFilter, splits []time.Time) ([]*model.SpeedTestTotals, error) {
+	return getSpeedTestDayTotals(ctx, s.db, sqliteDialect, filter, splits)
+}
+
+func (s *SQLiteStore) CreateTestSession(ctx context.Context, session *model.TestSession) error {
+	query := `INSERT INTO test_sessions (` + testSessionColumns + `) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err := s.db.ExecContext(ctx, query, session.ID, session.Status, session.ParentID, session.IPFamily, session.DualStack, session.Streams, session.Duration, session.Stages, session.PayloadSize, session.LoadedLatency, session.Adaptive, session.Transport, session.Share, session.ClientIPHash, session.CreatedAt, session.ExpiresAt)
+	return err
+}
+
+const testSessionColumns = `id, status, parent_id, ip_family, dual_stack, streams, duration, stages, payload_size, loaded_latency, adaptive, transport, share, client_ip_hash, created_at, expires_at`
+
+func scanTestSession(row rowScanner) (*model.TestSession, error) {
+	session := &model.TestSession{}
+	err := row.Scan(&session.ID, &session.Status, &session.ParentID, &session.IPFamily, &session.DualStack, &session.Streams, &session.Duration, &session.Stages, &session.PayloadSize, &session.LoadedLatency, &session.Adaptive, &session.Transport, &session.Share, &session.ClientIPHash, &session.CreatedAt, &session.ExpiresAt)
+	return session, err
+}
+
+func (s *SQLiteStore) GetTestSession(ctx context.Context, id string) (*model.TestSession, error) {
+	query := `SELECT ` + testSessionColumns + ` FROM test_sessions WHERE id = ?`
+	session, err := scanTestSession(s.db.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return session, err
+}
+
+// GetChildTestSessions returns the runs of a dual-stack test, IPv4 first.
+func (s *SQLiteStore) GetChildTestSessions(ctx context.Context, parentID string) ([]*model.TestSession, error) {
+	query := `SELECT ` + testSessionColumns + ` FROM test_sessions WHERE parent_id = ? ORDER BY ip_family`
+	rows, err := s.db.QueryContext(ctx, query, parentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []*model.TestSession
+	for rows.Next() {
+		session, err := scanTestSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+func (s *SQLiteStore) UpdateTestSessionStatus(ctx context.Context, id, status string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE test_sessions SET status = ? WHERE id = ?`, status, id)
+	return err
+}
+
 func (s *SQLiteStore) CreateAPIToken(ctx context.Context, token *model.APIToken) error {
 	query := `INSERT INTO api_tokens (id, user_id, token, name, last_used, created_at) VALUES (?, ?, ?, ?, ?, ?)`
 	_, err := s.db.ExecContext(ctx, query, token.ID, token.UserID, token.Token, token.Name, token.LastUsed, token.CreatedAt)
@@ -433,10 +674,10 @@ func (s *SQLiteStore) DeleteExpiredSessions(ctx context.Context) error {
 // Admin methods
 func (s *SQLiteStore) GetAdminByUsername(ctx context.Context, username string) (*model.Admin, error) {
 	admin := &model.Admin{}
-	var createdAt, updatedAt, lastLogin, lockedUntil int64
+	var createdAt, updatedAt int64
+	var lastLogin, lockedUntil sql.NullInt64
 	var email sql.NullString
 	var apiTokenHash sql.NullString
-	var lastLoginValid, lockedUntilValid bool
 	var enabled int
 
 	query := `SELECT id, username, password, email, role, enabled, created_at, updated_at, last_login, failed_attempts, locked_until, api_token_hash 
@@ -463,11 +704,11 @@ func (s *SQLiteStore) GetAdminByUsername(ctx context.Context, username string) (
 	if apiTokenHash.Valid {
 		admin.APITokenHash = apiTokenHash.String
 	}
-	if lastLoginValid && lastLogin > 0 {
-		admin.LastLogin = time.Unix(lastLogin, 0)
+	if lastLogin.Valid && lastLogin.Int64 > 0 {
+		admin.LastLogin = time.Unix(lastLogin.Int64, 0)
 	}
-	if lockedUntilValid && lockedUntil > 0 {
-		admin.LockedUntil = time.Unix(lockedUntil, 0)
+	if lockedUntil.Valid && lockedUntil.Int64 > 0 {
+		admin.LockedUntil = time.Unix(lockedUntil.Int64, 0)
 	}
 
 	return admin, nil
@@ -0,0 +1,261 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/casapps/casspeed/src/server/model"
+)
+
+// StatsFilter selects the results statistics are computed from. Empty IDs
+// match any.
+type StatsFilter struct {
+	UserID   string
+	DeviceID string
+	ServerID string
+	// From and To bound result timestamps, To excluded. Daily aggregates
+	// are selected from From's day (UTC) up to but not including To's.
+	From time.Time
+	To   time.Time
+	// Unverified includes results with a source, whose figures the
+	// client reported rather than the server measured.
+	Unverified bool
+}
+
+// matches reports whether the filter selects a result with these IDs.
+func (f StatsFilter) matches(userID, deviceID, serverID string) bool {
+	return (f.UserID == "" || f.UserID == userID) &&
+		(f.DeviceID == "" || f.DeviceID == deviceID) &&
+		(f.ServerID == "" || f.ServerID == serverID)
+}
+
+// idConditions adds the filter's ID conditions to a WHERE clause.
+func (f StatsFilter) idConditions(where string, args []any) (string, []any) {
+	for _, c := range []struct{ column, id string }{
+		{"user_id", f.UserID},
+		{"device_id", f.DeviceID},
+		{"server_id", f.ServerID},
+	} {
+		if c.id != "" {
+			where += ` AND ` + c.column + ` = ?`
+			args = append(args, c.id)
+		}
+	}
+	return where, args
+}
+
+// bounds are the edges of the buckets the filter's range is split into
+// at splits: From, each split, then To. Bucket i spans bounds[i] up to
+// bounds[i+1].
+func (f StatsFilter) bounds(splits []time.Time) []time.Time {
+	return append(append([]time.Time{f.From}, splits...), f.To)
+}
+
+// bucketIndex is a CASE expression giving the index of the bucket column
+// falls in, for values within bounds. It halves the bounds at each step,
+// so a value takes a handful of comparisons however many buckets there
+// are. Its arguments are the inner bounds, as arg gives them.
+func bucketIndex(column string, bounds []time.Time, arg func(time.Time) any) (string, []any) {
+	var args []any
+	var index func(lo, hi int) string
+	index = func(lo, hi int) string {
+		if hi-lo == 1 {
+			return strconv.Itoa(lo)
+		}
+		mid := (lo + hi) / 2
+		args = append(args, arg(bounds[mid]))
+		return `CASE WHEN ` + column + ` < ? THEN ` + index(lo, mid) + ` ELSE ` + index(mid, hi) + ` END`
+	}
+	return index(0, len(bounds)-1), args
+}
+
+// percentileRanks gives the ranks (from 0) of the two values among n
+// sorted ones that the p-th percentile lies between, and how far between.
+// The SQL stores compute the same ranks in rankConditions.
+func percentileRanks(n, p int) (lo, hi int, frac float64) {
+	return (n - 1) * p / 100, ((n-1)*p + 99) / 100, float64((n-1)*p%100) / 100
+}
+
+// setPercentiles sets a figure's median and 95th percentile over n results
+// from value, which gives the value of each rank percentileRanks asks for.
+func setPercentiles(f *model.FigureTotals, n int, value func(rank int) float64) {
+	for _, p := range []struct {
+		p   int
+		dst *float64
+	}{{50, &f.Median}, {95, &f.P95}} {
+		lo, hi, frac := percentileRanks(n, p.p)
+		*p.dst = value(lo) + (value(hi)-value(lo))*frac
+	}
+}
+
+// figures are a bucket's totals of each figure, in statsFigures order.
+func figures(t *model.SpeedTestTotals) []*model.FigureTotals {
+	return []*model.FigureTotals{&t.Download, &t.Upload, &t.Ping, &t.Jitter}
+}
+
+// statsFigures are the speed_tests columns statistics cover, and the
+// speed_test_days column prefixes of their aggregates.
+var statsFigures = []struct{ column, day string }{
+	{"download_mbps", "download"},
+	{"upload_mbps", "upload"},
+	{"ping_ms", "ping"},
+	{"jitter_ms", "jitter"},
+}
+
+// rankConditions selects, of the rows numbered by rank (from 0) within a
+// bucket of n, those the median and 95th percentile are interpolated from.
+func rankConditions(rank string) string {
+	var ranks []string
+	for _, p := range []int{50, 95} {
+		ranks = append(ranks, fmt.Sprintf(`(n - 1) * %d / 100`, p), fmt.Sprintf(`((n - 1) * %d + 99) / 100`, p))
+	}
+	return rank + ` IN (` + strings.Join(ranks, `, `) + `)`
+}
+
+// getSpeedTestTotals is GetSpeedTestTotals for the SQL stores. The
+// database does the work: window functions sum up each bucket and rank its
+// values, and only the rows holding the values percentiles need come back,
+// so at most a few per bucket however many results there are. SQLite
+// compares timestamps as text, so bounds are given in UTC, as results are
+// stored.
+func getSpeedTestTotals(ctx context.Context, db *sql.DB, d *dialect, f StatsFilter, splits []time.Time) ([]*model.SpeedTestTotals, error) {
+	bounds := f.bounds(splits)
+	index, args := bucketIndex(`timestamp`, bounds, func(t time.Time) any { return t.UTC() })
+	where, args := f.idConditions(`status = ? AND timestamp >= ? AND timestamp < ?`,
+		append(args, model.TestStatusComplete, f.From.UTC(), f.To.UTC()))
+	if !f.Unverified {
+		where += ` AND source = ''`
+	}
+
+	var columns, windows, ranked []string
+	for i, fig := range statsFigures {
+		columns = append(columns, fmt.Sprintf(`sum_%[1]d, min_%[1]d, max_%[1]d, rank_%[1]d, %[2]s`, i, fig.column))
+		windows = append(windows, fmt.Sprintf(`SUM(%[2]s) OVER b AS sum_%[1]d, MIN(%[2]s) OVER b AS min_%[1]d, MAX(%[2]s) OVER b AS max_%[1]d,
+			ROW_NUMBER() OVER (PARTITION BY bucket ORDER BY %[2]s) - 1 AS rank_%[1]d`, i, fig.column))
+		ranked = append(ranked, rankConditions(fmt.Sprintf(`rank_%d`, i)))
+	}
+	query := `SELECT bucket, n, ` + strings.Join(columns, `, `) + ` FROM (
+		SELECT bucket, download_mbps, upload_mbps, ping_ms, jitter_ms, COUNT(*) OVER b AS n,
+			` + strings.Join(windows, `,
+			`) + `
+		FROM (SELECT ` + index + ` AS bucket, download_mbps, upload_mbps, ping_ms, jitter_ms
+			FROM speed_tests WHERE ` + where + `) results
+		WINDOW b AS (PARTITION BY bucket)
+	) ranked WHERE ` + strings.Join(ranked, ` OR `) + ` ORDER BY bucket`
+	rows, err := db.QueryContext(ctx, d.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type bucketValues struct {
+		totals *model.SpeedTestTotals
+		ranked [4]map[int]float64 // Value of each rank, by figure
+	}
+	var buckets []*bucketValues
+	for rows.Next() {
+		var bucket, n int
+		var ranks [4]int
+		var values [4]float64
+		var aggregates [4]model.Aggregate
+		dest := []any{&bucket, &n}
+		for i := range statsFigures {
+			dest = append(dest, &aggregates[i].Sum, &aggregates[i].Min, &aggregates[i].Max, &ranks[i], &values[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		if len(buckets) == 0 || buckets[len(buckets)-1].totals.Start != bounds[bucket] {
+			b := &bucketValues{totals: &model.SpeedTestTotals{Start: bounds[bucket], Tests: n}}
+			for i, fig := range figures(b.totals) {
+				fig.Aggregate = aggregates[i]
+				b.ranked[i] = make(map[int]float64)
+			}
+			buckets = append(buckets, b)
+		}
+		b := buckets[len(buckets)-1]
+		for i := range statsFigures {
+			b.ranked[i][ranks[i]] = values[i]
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	totals := make([]*model.SpeedTestTotals, len(buckets))
+	for i, b := range buckets {
+		for j, fig := range figures(b.totals) {
+			setPercentiles(fig, b.totals.Tests, func(rank int) float64 { return b.ranked[j][rank] })
+		}
+		totals[i] = b.totals
+	}
+	return totals, nil
+}
+
+// getSpeedTestDays is GetSpeedTestDays for the SQL stores.
+func getSpeedTestDays(ctx context.Context, db *sql.DB, d *dialect, f StatsFilter) ([]*model.SpeedTestDay, error) {
+	where, args := f.dayConditions()
+	rows, err := db.QueryContext(ctx, d.rebind(`SELECT `+speedTestDayColumns+` FROM speed_test_days
+		WHERE `+where+` ORDER BY day, user_id, device_id, server_id`), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var days []*model.SpeedTestDay
+	for rows.Next() {
+		day, err := scanSpeedTestDay(rows)
+		if err != nil {
+			return nil, err
+		}
+		days = append(days, day)
+	}
+	return days, rows.Err()
+}
+
+// dayConditions is the WHERE clause selecting the filter's daily
+// aggregates.
+func (f StatsFilter) dayConditions() (string, []any) {
+	return f.idConditions(`day >= ? AND day < ?`,
+		[]any{f.From.UTC().Format(dayFormat), f.To.UTC().Format(dayFormat)})
+}
+
+// getSpeedTestDayTotals is GetSpeedTestDayTotals for the SQL stores.
+func getSpeedTestDayTotals(ctx context.Context, db *sql.DB, d *dialect, f StatsFilter, splits []time.Time) ([]*model.SpeedTestTotals, error) {
+	bounds := f.bounds(splits)
+	index, args := bucketIndex(`day`, bounds, func(t time.Time) any { return t.UTC().Format(dayFormat) })
+	where, whereArgs := f.dayConditions()
+
+	columns := []string{`SUM(tests)`}
+	for _, fig := range statsFigures {
+		columns = append(columns, fmt.Sprintf(`SUM(%[1]s_sum), MIN(%[1]s_min), MAX(%[1]s_max)`, fig.day))
+	}
+	rows, err := db.QueryContext(ctx, d.rebind(`SELECT bucket, `+strings.Join(columns, `, `)+`
+		FROM (SELECT `+index+` AS bucket, `+speedTestDayColumns+` FROM speed_test_days WHERE `+where+`) days
+		GROUP BY bucket ORDER BY bucket`), append(args, whereArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var totals []*model.SpeedTestTotals
+	for rows.Next() {
+		var bucket int
+		t := &model.SpeedTestTotals{}
+		dest := []any{&bucket, &t.Tests}
+		for _, fig := range figures(t) {
+			dest = append(dest, &fig.Sum, &fig.Min, &fig.Max)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		t.Start = bounds[bucket]
+		totals = append(totals, t)
+	}
+	return totals, rows.Err()
+}
@@ -2,8 +2,11 @@ package store
 
 import (
 	"context"
+	"fmt"
+	"path/filepath"
 	"time"
 
+	"github.com/casapps/casspeed/src/config"
 	"github.com/casapps/casspeed/src/server/model"
 )
 
@@ -31,6 +34,24 @@ type Store interface {
 	UpdateSpeedTest(ctx context.Context, test *model.SpeedTest) error
 	DeleteSpeedTest(ctx context.Context, id string) error
 	IncrementShareViews(ctx context.Context, shareCode string) error
+	// PruneSpeedTests deletes the results opts selects and returns how
+	// many went
+	PruneSpeedTests(ctx context.Context, opts PruneOptions) (int, error)
+	// GetSpeedTestTotals sums up the complete results the filter selects
+	// in each bucket its range is split into at splits, oldest first;
+	// buckets without results are left out
+	GetSpeedTestTotals(ctx context.Context, filter StatsFilter, splits []time.Time) ([]*model.SpeedTestTotals, error)
+	// GetSpeedTestDays returns the daily aggregates the filter selects,
+	// by day
+	GetSpeedTestDays(ctx context.Context, filter StatsFilter) ([]*model.SpeedTestDay, error)
+	// GetSpeedTestDayTotals sums up the daily aggregates the filter
+	// selects like GetSpeedTestTotals; splits must fall on UTC midnight
+	GetSpeedTestDayTotals(ctx context.Context, filter StatsFilter, splits []time.Time) ([]*model.SpeedTestTotals, error)
+
+	CreateTestSession(ctx context.Context, session *model.TestSession) error
+	GetTestSession(ctx context.Context, id string) (*model.TestSession, error)
+	UpdateTestSessionStatus(ctx context.Context, id, status string) error
+	GetChildTestSessions(ctx context.Context, parentID string) ([]*model.TestSession, error)
 
 	CreateAPIToken(ctx context.Context, token *model.APIToken) error
 	GetAPIToken(ctx context.Context, id string) (*model.APIToken, error)
@@ -58,3 +79,38 @@ type Store interface {
 	DeleteAdminSession(ctx context.Context, id string) error
 	DeleteExpiredAdminSessions(ctx context.Context) error
 }
+
+// VersionedStore is a Store whose schema is kept by migrations.
+type VersionedStore interface {
+	Store
+
+	Migrations(ctx context.Context) ([]MigrationStatus, error)
+	MigrateUp(ctx context.Context) ([]MigrationStatus, error)
+	MigrateDown(ctx context.Context) (MigrationStatus, error)
+}
+
+// Open opens the store the database configuration selects, without
+// migrating it: SQLite in dataDir's db directory, or PostgreSQL.
+func Open(cfg config.Database, dataDir string) (VersionedStore, error) {
+	switch cfg.Driver {
+	case "", "file", "sqlite":
+		return OpenSQLiteStore(filepath.Join(dataDir, "db", "speedtest.db"))
+	case "postgres":
+		return OpenPostgresStore(PostgresDSN(cfg))
+	default:
+		return nil, fmt.Errorf("unsupported database driver: %s", cfg.Driver)
+	}
+}
+
+// New opens the configured store and migrates it to the latest schema.
+func New(cfg config.Database, dataDir string) (Store, error) {
+	s, err := Open(cfg, dataDir)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.MigrateUp(context.Background()); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("migrating database: %w", err)
+	}
+	return s, nil
+}
@@ -0,0 +1,1076 @@
+// Package storetest is a conformance suite for store.Store
+// implementations. Each backend's tests call Run with a function that
+// opens an empty, migrated store.
+package storetest
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/casapps/casspeed/src/server/model"
+	"github.com/casapps/casspeed/src/server/store"
+)
+
+// Opener returns an empty store for one test, closed by the test's
+// cleanup.
+type Opener func(t *testing.T) store.Store
+
+// Execer runs statements straight against a SQL store's database, so the
+// suite can set up rows the Store API no longer writes.
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// WithDB pairs a SQL store with a second handle on its database, for
+// openers whose stores should also run the cases that need an Execer.
+func WithDB(s store.VersionedStore, db *sql.DB) store.VersionedStore {
+	return sqlStore{s, db}
+}
+
+type sqlStore struct {
+	store.VersionedStore
+	db *sql.DB
+}
+
+func (s sqlStore) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return s.db.ExecContext(ctx, query, args...)
+}
+
+// Run runs the suite against the stores open returns.
+func Run(t *testing.T, open Opener) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, s store.Store)
+	}{
+		{"Users", testUsers},
+		{"Devices", testDevices},
+		{"SpeedTests", testSpeedTests},
+		{"SpeedTestLists", testSpeedTestLists},
+		{"ShareCodes", testShareCodes},
+		{"Prune", testPrune},
+		{"StatsFilters", testStatsFilters},
+		{"StatsTotals", testStatsTotals},
+		{"StatsZones", testStatsZones},
+		{"TestSessions", testTestSessions},
+		{"APITokens", testAPITokens},
+		{"Sessions", testSessions},
+		{"Admins", testAdmins},
+		{"AdminSessions", testAdminSessions},
+		{"NotFound", testNotFound},
+		{"MissingRows", testMissingRows},
+		{"Duplicates", testDuplicates},
+		{"Copies", testCopies},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, open(t))
+		})
+	}
+
+	t.Run("Migrations", func(t *testing.T) {
+		s, ok := open(t).(store.VersionedStore)
+		if !ok {
+			t.Skip("store has no migrations")
+		}
+		testMigrations(t, s)
+	})
+}
+
+// now is a time every backend stores exactly.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+func check(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// sameTime compares times by instant, as backends return them in
+// different locations.
+func sameTime(t *testing.T, field string, got, want time.Time) {
+	t.Helper()
+	if !got.Equal(want) {
+		t.Errorf("%s = %v, want %v", field, got, want)
+	}
+}
+
+// mustBeMissing checks a lookup found nothing: nil, and no error.
+func mustBeMissing[T any](t *testing.T, what string, got *T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %v, want no error", what, err)
+	}
+	if got != nil {
+		t.Fatalf("%s = %+v, want nil", what, got)
+	}
+}
+
+func newUser(t *testing.T, s store.Store, id string) *model.User {
+	t.Helper()
+	user := &model.User{
+		ID:           id,
+		Username:     "user-" + id,
+		Email:        id + "@example.com",
+		PasswordHash: "hash-" + id,
+		CreatedAt:    now(),
+	}
+	check(t, s.CreateUser(context.Background(), user))
+	return user
+}
+
+func newDevice(t *testing.T, s store.Store, id, userID string, created time.Time) *model.Device {
+	t.Helper()
+	device := &model.Device{ID: id, UserID: userID, Name: "device " + id, LastSeen: created, CreatedAt: created}
+	check(t, s.CreateDevice(context.Background(), device))
+	return device
+}
+
+func testUsers(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	user := newUser(t, s, "u1")
+
+	for name, get := range map[string]func() (*model.User, error){
+		"GetUser":           func() (*model.User, error) { return s.GetUser(ctx, "u1") },
+		"GetUserByUsername": func() (*model.User, error) { return s.GetUserByUsername(ctx, "user-u1") },
+		"GetUserByEmail":    func() (*model.User, error) { return s.GetUserByEmail(ctx, "u1@example.com") },
+	} {
+		got, err := get()
+		check(t, err)
+		if got == nil || got.ID != user.ID || got.Username != user.Username || got.Email != user.Email || got.PasswordHash != user.PasswordHash {
+			t.Fatalf("%s = %+v, want %+v", name, got, user)
+		}
+		sameTime(t, name+" CreatedAt", got.CreatedAt, user.CreatedAt)
+	}
+
+	user.Username = "renamed"
+	user.ShareShowUsername = true
+	check(t, s.UpdateUser(ctx, user))
+	got, err := s.GetUser(ctx, "u1")
+	check(t, err)
+	if got.Username != "renamed" || !got.ShareShowUsername {
+		t.Errorf("after UpdateUser got %+v", got)
+	}
+
+	if err := s.CreateUser(ctx, &model.User{ID: "u2", Username: "renamed", Email: "other@example.com", CreatedAt: now()}); err == nil {
+		t.Error("CreateUser with a taken username succeeded")
+	}
+
+	check(t, s.DeleteUser(ctx, "u1"))
+	got, err = s.GetUser(ctx, "u1")
+	mustBeMissing(t, "GetUser after DeleteUser", got, err)
+	got, err = s.GetUserByUsername(ctx, "nobody")
+	mustBeMissing(t, "GetUserByUsername(unknown)", got, err)
+	got, err = s.GetUserByEmail(ctx, "nobody@example.com")
+	mustBeMissing(t, "GetUserByEmail(unknown)", got, err)
+}
+
+func testDevices(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	newUser(t, s, "u1")
+	base := now()
+	newDevice(t, s, "d1", "u1", base.Add(-time.Hour))
+	newDevice(t, s, "d2", "u1", base)
+
+	devices, err := s.GetUserDevices(ctx, "u1")
+	check(t, err)
+	if len(devices) != 2 || devices[0].ID != "d2" || devices[1].ID != "d1" {
+		t.Fatalf("GetUserDevices = %v, want d2, d1 (newest first)", deviceIDs(devices))
+	}
+
+	seen := base.Add(time.Minute)
+	check(t, s.UpdateDevice(ctx, &model.Device{ID: "d1", Name: "laptop", LastSeen: seen}))
+	got, err := s.GetDevice(ctx, "d1")
+	check(t, err)
+	if got == nil || got.Name != "laptop" || got.UserID != "u1" {
+		t.Fatalf("GetDevice after UpdateDevice = %+v", got)
+	}
+	sameTime(t, "LastSeen", got.LastSeen, seen)
+
+	check(t, s.DeleteDevice(ctx, "d1"))
+	got, err = s.GetDevice(ctx, "d1")
+	mustBeMissing(t, "GetDevice after DeleteDevice", got, err)
+
+	devices, err = s.GetUserDevices(ctx, "nobody")
+	check(t, err)
+	if len(devices) != 0 {
+		t.Errorf("GetUserDevices(unknown) = %v, want none", deviceIDs(devices))
+	}
+}
+
+func deviceIDs(devices []*model.Device) []string {
+	var ids []string
+	for _, d := range devices {
+		ids = append(ids, d.ID)
+	}
+	return ids
+}
+
+// fullSpeedTest sets every field a result can carry.
+func fullSpeedTest(id string, ts time.Time) *model.SpeedTest {
+	return &model.SpeedTest{
+		ID:                 id,
+		Status:             model.TestStatusComplete,
+		ParentID:           "parent-" + id,
+		IPFamily:           "ipv6",
+		Timestamp:          ts,
+		DownloadMbps:       941.25,
+		UploadMbps:         87.5,
+		PingMs:             12.3,
+		JitterMs:           1.7,
+		PacketLoss:         0.25,
+		PingDownloadMs:     45.6,
+		PingUploadMs:       38.9,
+		Duration:           10,
+		Streams:            4,
+		Stages:             "ping,setup,udp,download,upload",
+		PayloadSize:        1048576,
+		Adaptive:           true,
+		Transport:          "h3",
+		DownloadStopReason: "stable",
+		UploadStopReason:   "duration",
+		DownloadStats:      &model.ThroughputStats{P10: 900, P50: 940, P90: 960, Max: 990, IntervalMs: 250, WarmupIntervals: 2, Series: []float64{880, 940, 960}},
+		UploadStats:        &model.ThroughputStats{P10: 80, P50: 87, P90: 90, Max: 95, IntervalMs: 250, Series: []float64{85, 90}},
+		DownloadTCP:        &model.TCPStats{Limit: model.TCPLimitNetwork, Retransmits: 3, RetransmitPct: 0.01, RTTMs: 14.5, DeliveryRateMbps: 950, Streams: []model.TCPStreamStats{{Retransmits: 3, Cwnd: 120, Samples: 40}}},
+		UploadTCP:          &model.TCPStats{Limit: model.TCPLimitReceiver, RwndLimitedPct: 42},
+		UDP:                &model.UDPStats{Sent: 500, Received: 498, LossPct: 0.4, Reordered: 1, JitterMs: 0.8, Rate: 50, PacketSize: 200},
+		Setup:              &model.SetupStats{DNSMs: 4.2, ConnectMs: 11.9, TLSMs: 23.1, TTFBMs: 13.4, Samples: 5},
+		ClientIPHash:       "iphash",
+		UserAgent:          "casspeed-cli/1.0",
+		ServerID:           "eu-west",
+		ShareCode:          "share-" + id,
+		ShareViews:         3,
+		CreatedAt:          ts,
+	}
+}
+
+// sameSpeedTest compares two results field by field, times by instant.
+func sameSpeedTest(t *testing.T, got, want *model.SpeedTest) {
+	t.Helper()
+	if got == nil {
+		t.Fatalf("got no result, want %s", want.ID)
+	}
+	sameTime(t, "Timestamp", got.Timestamp, want.Timestamp)
+	sameTime(t, "CreatedAt", got.CreatedAt, want.CreatedAt)
+	g, w := *got, *want
+	g.Timestamp, w.Timestamp = time.Time{}, time.Time{}
+	g.CreatedAt, w.CreatedAt = time.Time{}, time.Time{}
+	if !reflect.DeepEqual(g, w) {
+		t.Errorf("got  %+v\nwant %+v", g, w)
+	}
+}
+
+func testSpeedTests(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	newUser(t, s, "u1")
+	newDevice(t, s, "d1", "u1", now())
+
+	test := fullSpeedTest("t1", now())
+	test.UserID, test.DeviceID = "u1", "d1"
+	check(t, s.CreateSpeedTest(ctx, test))
+	got, err := s.GetSpeedTest(ctx, "t1")
+	check(t, err)
+	sameSpeedTest(t, got, test)
+
+	// An anonymous result with nothing optional set
+	bare := &model.SpeedTest{ID: "t2", Status: model.TestStatusComplete, Timestamp: now(), ClientIPHash: "iphash", CreatedAt: now()}
+	check(t, s.CreateSpeedTest(ctx, bare))
+	got, err = s.GetSpeedTest(ctx, "t2")
+	check(t, err)
+	sameSpeedTest(t, got, bare)
+
+	// A result a client reported keeps its source
+	reported := fullSpeedTest("t3", now())
+	reported.Source = model.SourceLibreSpeed
+	check(t, s.CreateSpeedTest(ctx, reported))
+	got, err = s.GetSpeedTest(ctx, "t3")
+	check(t, err)
+	sameSpeedTest(t, got, reported)
+
+	check(t, s.DeleteSpeedTest(ctx, "t1"))
+	got, err = s.GetSpeedTest(ctx, "t1")
+	mustBeMissing(t, "GetSpeedTest after DeleteSpeedTest", got, err)
+}
+
+func testSpeedTestLists(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	newUser(t, s, "u1")
+	newUser(t, s, "u2")
+	newDevice(t, s, "d1", "u1", now())
+
+	base := now()
+	for i := 0; i < 5; i++ {
+		test := fullSpeedTest(fmt.Sprintf("t%d", i), base.Add(time.Duration(i)*time.Minute))
+		test.ShareCode = ""
+		test.UserID = "u1"
+		if i%2 == 0 {
+			test.DeviceID = "d1"
+		}
+		check(t, s.CreateSpeedTest(ctx, test))
+	}
+	other := fullSpeedTest("other", base)
+	other.UserID, other.ShareCode = "u2", ""
+	check(t, s.CreateSpeedTest(ctx, other))
+
+	for _, tt := range []struct {
+		name          string
+		list          func(limit, offset int) ([]*model.SpeedTest, error)
+		limit, offset int
+		want          []string
+	}{
+		{"user", userTests(s, "u1"), 10, 0, []string{"t4", "t3", "t2", "t1", "t0"}},
+		{"user page 2", userTests(s, "u1"), 2, 2, []string{"t2", "t1"}},
+		{"user past the end", userTests(s, "u1"), 10, 5, nil},
+		{"unknown user", userTests(s, "nobody"), 10, 0, nil},
+		{"device", deviceTests(s, "d1"), 10, 0, []string{"t4", "t2", "t0"}},
+		{"device page", deviceTests(s, "d1"), 1, 1, []string{"t2"}},
+	} {
+		tests, err := tt.list(tt.limit, tt.offset)
+		check(t, err)
+		var ids []string
+		for _, test := range tests {
+			ids = append(ids, test.ID)
+		}
+		if !reflect.DeepEqual(ids, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, ids, tt.want)
+		}
+	}
+}
+
+func userTests(s store.Store, userID string) func(limit, offset int) ([]*model.SpeedTest, error) {
+	return func(limit, offset int) ([]*model.SpeedTest, error) {
+		return s.GetUserSpeedTests(context.Background(), userID, limit, offset)
+	}
+}
+
+func deviceTests(s store.Store, deviceID string) func(limit, offset int) ([]*model.SpeedTest, error) {
+	return func(limit, offset int) ([]*model.SpeedTest, error) {
+		return s.GetDeviceSpeedTests(context.Background(), deviceID, limit, offset)
+	}
+}
+
+func testShareCodes(t *testing.T, s store.Store) {
+	ctx := context.Background()
+
+	// Results without share codes mustn't collide on an empty one
+	for _, id := range []string{"t1", "t2"} {
+		test := fullSpeedTest(id, now())
+		test.ShareCode = ""
+		check(t, s.CreateSpeedTest(ctx, test))
+	}
+
+	test, err := s.GetSpeedTest(ctx, "t1")
+	check(t, err)
+	test.ShareCode = "abc123"
+	test.ShareViews = 0
+	check(t, s.UpdateSpeedTest(ctx, test))
+	check(t, s.IncrementShareViews(ctx, "abc123"))
+	check(t, s.IncrementShareViews(ctx, "abc123"))
+
+	got, err := s.GetSpeedTestByShareCode(ctx, "abc123")
+	check(t, err)
+	if got == nil || got.ID != "t1" || got.ShareViews != 2 {
+		t.Fatalf("GetSpeedTestByShareCode = %+v, want t1 with 2 views", got)
+	}
+
+	dup := fullSpeedTest("t3", now())
+	dup.ShareCode = "abc123"
+	if err := s.CreateSpeedTest(ctx, dup); err == nil {
+		t.Error("CreateSpeedTest with a taken share code succeeded")
+	}
+
+	got, err = s.GetSpeedTestByShareCode(ctx, "missing")
+	mustBeMissing(t, "GetSpeedTestByShareCode(unknown)", got, err)
+	check(t, s.IncrementShareViews(ctx, "missing"))
+}
+
+func testPrune(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	day := time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)
+	cutoff := day.AddDate(0, 0, 2)
+
+	for _, r := range []struct {
+		id, server, shareCode, status, source string
+		at                                    time.Time
+		download, ping                        float64
+	}{
+		{"old1", "eu", "", model.TestStatusComplete, "", day.Add(9 * time.Hour), 100, 20},
+		{"old2", "eu", "", model.TestStatusComplete, "", day.Add(15 * time.Hour), 300, 10},
+		{"old3", "us", "", model.TestStatusComplete, "", day.Add(15 * time.Hour), 50, 90},
+		{"aborted", "eu", "", model.TestStatusAborted, "", day.Add(16 * time.Hour), 1, 1},
+		{"reported", "eu", "", model.TestStatusComplete, model.SourceLibreSpeed, day.Add(16 * time.Hour), 5000, 1},
+		{"shared", "eu", "keep-me", model.TestStatusComplete, "", day.Add(17 * time.Hour), 1000, 5},
+		{"blank", "us", "", model.TestStatusComplete, "", day.Add(18 * time.Hour), 70, 80},
+		{"next day", "eu", "", model.TestStatusComplete, "", day.Add(25 * time.Hour), 200, 30},
+		{"recent", "eu", "", model.TestStatusComplete, "", cutoff.Add(time.Hour), 500, 15},
+	} {
+		test := fullSpeedTest(r.id, r.at)
+		test.Status, test.ServerID, test.ShareCode, test.Source = r.status, r.server, r.shareCode, r.source
+		test.DownloadMbps, test.PingMs = r.download, r.ping
+		check(t, s.CreateSpeedTest(ctx, test))
+	}
+	// Older releases stored an empty share code rather than NULL; such
+	// results aren't shared
+	if db, ok := s.(Execer); ok {
+		_, err := db.ExecContext(ctx, `UPDATE speed_tests SET share_code = '' WHERE id = 'blank'`)
+		check(t, err)
+	}
+
+	// Reported results are pruned but not rolled up
+	n, err := s.PruneSpeedTests(ctx, store.PruneOptions{Before: cutoff, KeepShared: true, Rollup: true})
+	check(t, err)
+	if n != 7 {
+		t.Errorf("PruneSpeedTests pruned %d results, want 7", n)
+	}
+	for id, want := range map[string]bool{"old1": false, "aborted": false, "reported": false, "blank": false, "next day": false, "shared": true, "recent": true} {
+		got, err := s.GetSpeedTest(ctx, id)
+		check(t, err)
+		if (got != nil) != want {
+			t.Errorf("after PruneSpeedTests, %s kept = %v, want %v", id, got != nil, want)
+		}
+	}
+
+	days, err := s.GetSpeedTestDays(ctx, store.StatsFilter{From: day, To: cutoff})
+	check(t, err)
+	want := []model.SpeedTestDay{
+		{Day: day, ServerID: "eu", Tests: 2, Download: model.Aggregate{Sum: 400, Min: 100, Max: 300}, Ping: model.Aggregate{Sum: 30, Min: 10, Max: 20}},
+		{Day: day, ServerID: "us", Tests: 2, Download: model.Aggregate{Sum: 120, Min: 50, Max: 70}, Ping: model.Aggregate{Sum: 170, Min: 80, Max: 90}},
+		{Day: day.AddDate(0, 0, 1), ServerID: "eu", Tests: 1, Download: model.Aggregate{Sum: 200, Min: 200, Max: 200}, Ping: model.Aggregate{Sum: 30, Min: 30, Max: 30}},
+	}
+	sameDays(t, days, want)
+
+	// A later prune adds to the days already summed up
+	late := fullSpeedTest("late", day.Add(20*time.Hour))
+	late.ServerID, late.ShareCode, late.DownloadMbps, late.PingMs = "eu", "", 600, 5
+	check(t, s.CreateSpeedTest(ctx, late))
+	n, err = s.PruneSpeedTests(ctx, store.PruneOptions{Before: cutoff, Rollup: true})
+	check(t, err)
+	if n != 2 {
+		t.Errorf("second PruneSpeedTests pruned %d results, want late and shared", n)
+	}
+	days, err = s.GetSpeedTestDays(ctx, store.StatsFilter{From: day, To: day.AddDate(0, 0, 1)})
+	check(t, err)
+	want[0].Tests = 4
+	want[0].Download = model.Aggregate{Sum: 2000, Min: 100, Max: 1000}
+	want[0].Ping = model.Aggregate{Sum: 40, Min: 5, Max: 20}
+	sameDays(t, days, want[:2])
+
+	// Without rollups results just go
+	n, err = s.PruneSpeedTests(ctx, store.PruneOptions{Before: cutoff.AddDate(0, 0, 1)})
+	check(t, err)
+	if n != 1 {
+		t.Errorf("third PruneSpeedTests pruned %d results, want 1", n)
+	}
+	days, err = s.GetSpeedTestDays(ctx, store.StatsFilter{From: cutoff, To: cutoff.AddDate(0, 0, 1)})
+	check(t, err)
+	sameDays(t, days, nil)
+}
+
+func testStatsFilters(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	newUser(t, s, "u1")
+	newDevice(t, s, "d1", "u1", now())
+	day := time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)
+
+	for _, r := range []struct {
+		id, user, device, server, status string
+		at                               time.Time
+	}{
+		{"a", "u1", "d1", "eu", model.TestStatusComplete, day.Add(time.Hour)},
+		{"b", "u1", "", "us", model.TestStatusComplete, day.Add(2 * time.Hour)},
+		{"c", "", "", "eu", model.TestStatusComplete, day.Add(3 * time.Hour)},
+		{"aborted", "u1", "d1", "eu", model.TestStatusAborted, day.Add(4 * time.Hour)},
+		{"old", "u1", "d1", "eu", model.TestStatusComplete, day.Add(-time.Hour)},
+		{"next day", "u1", "d1", "eu", model.TestStatusComplete, day.Add(24 * time.Hour)},
+	} {
+		test := fullSpeedTest(r.id, r.at)
+		test.UserID, test.DeviceID, test.ServerID, test.Status, test.ShareCode = r.user, r.device, r.server, r.status, ""
+		test.DownloadMbps = float64(r.at.Hour())
+		check(t, s.CreateSpeedTest(ctx, test))
+	}
+	reported := fullSpeedTest("reported", day.Add(5*time.Hour))
+	reported.ServerID, reported.ShareCode, reported.Source, reported.DownloadMbps = "eu", "", model.SourceLibreSpeed, 5
+	check(t, s.CreateSpeedTest(ctx, reported))
+	// Rolls up "old" into the day before
+	_, err := s.PruneSpeedTests(ctx, store.PruneOptions{Before: day, Rollup: true})
+	check(t, err)
+
+	for _, tt := range []struct {
+		name   string
+		filter store.StatsFilter
+		want   []float64 // download of each result, by hour of the day
+		days   int
+	}{
+		{"day", store.StatsFilter{From: day, To: day.AddDate(0, 0, 1)}, []float64{1, 2, 3}, 0},
+		{"user", store.StatsFilter{UserID: "u1", From: day, To: day.AddDate(0, 0, 1)}, []float64{1, 2}, 0},
+		{"device", store.StatsFilter{DeviceID: "d1", From: day, To: day.AddDate(0, 0, 2)}, []float64{1, 0}, 0},
+		{"server", store.StatsFilter{ServerID: "eu", From: day, To: day.AddDate(0, 0, 1)}, []float64{1, 3}, 0},
+		{"hours", store.StatsFilter{From: day.Add(2 * time.Hour), To: day.Add(3 * time.Hour)}, []float64{2}, 0},
+		{"unverified", store.StatsFilter{ServerID: "eu", From: day, To: day.AddDate(0, 0, 1), Unverified: true}, []float64{1, 3, 5}, 0},
+		{"rolled up", store.StatsFilter{UserID: "u1", From: day.AddDate(0, 0, -1), To: day}, nil, 1},
+		{"other server", store.StatsFilter{ServerID: "us", From: day.AddDate(0, 0, -1), To: day}, nil, 0},
+	} {
+		totals, err := s.GetSpeedTestTotals(ctx, tt.filter, nil)
+		check(t, err)
+		var want []*model.SpeedTestTotals
+		if len(tt.want) > 0 {
+			sum := 0.0
+			for _, v := range tt.want {
+				sum += v
+			}
+			want = []*model.SpeedTestTotals{{Start: tt.filter.From, Tests: len(tt.want),
+				Download: model.FigureTotals{Aggregate: model.Aggregate{Sum: sum, Min: slices.Min(tt.want), Max: slices.Max(tt.want)}}}}
+		}
+		sameTotals(t, tt.name, totals, want, false)
+		days, err := s.GetSpeedTestDays(ctx, tt.filter)
+		check(t, err)
+		if len(days) != tt.days {
+			t.Errorf("%s: %d days, want %d", tt.name, len(days), tt.days)
+		}
+	}
+}
+
+func testStatsTotals(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	day := time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC) // A Monday
+	for i, r := range []struct {
+		at       time.Duration
+		download float64
+	}{
+		{1 * time.Hour, 10}, {2 * time.Hour, 40}, {3 * time.Hour, 20}, {4 * time.Hour, 30},
+		{25 * time.Hour, 100},
+		{49 * time.Hour, 7}, {50 * time.Hour, 7},
+	} {
+		test := fullSpeedTest(fmt.Sprintf("t%d", i), day.Add(r.at))
+		test.ShareCode, test.DownloadMbps, test.PingMs = "", r.download, r.download/10
+		check(t, s.CreateSpeedTest(ctx, test))
+	}
+
+	// Three days split at midnight, the first starting after one result
+	filter := store.StatsFilter{From: day.Add(90 * time.Minute), To: day.AddDate(0, 0, 3)}
+	splits := []time.Time{day.AddDate(0, 0, 1), day.AddDate(0, 0, 2)}
+	totals, err := s.GetSpeedTestTotals(ctx, filter, splits)
+	check(t, err)
+	sameTotals(t, "days", totals, []*model.SpeedTestTotals{
+		{Start: filter.From, Tests: 3, Download: model.FigureTotals{Aggregate: model.Aggregate{Sum: 90, Min: 20, Max: 40}, Median: 30, P95: 39}},
+		{Start: splits[0], Tests: 1, Download: model.FigureTotals{Aggregate: model.Aggregate{Sum: 100, Min: 100, Max: 100}, Median: 100, P95: 100}},
+		{Start: splits[1], Tests: 2, Download: model.FigureTotals{Aggregate: model.Aggregate{Sum: 14, Min: 7, Max: 7}, Median: 7, P95: 7}},
+	}, true)
+	if len(totals) == 3 && totals[0].Ping.Median != 3 {
+		t.Errorf("ping median %v, want 3", totals[0].Ping.Median)
+	}
+
+	// Buckets without results are left out
+	totals, err = s.GetSpeedTestTotals(ctx, store.StatsFilter{From: day.AddDate(0, 0, -1), To: day.AddDate(0, 0, 2)}, []time.Time{day, day.AddDate(0, 0, 1)})
+	check(t, err)
+	if len(totals) != 2 || !totals[0].Start.Equal(day) || totals[1].Tests != 1 {
+		t.Errorf("got %d buckets, want the second and third", len(totals))
+	}
+
+	// Rolled up, the results are summed up by day, then by bucket
+	_, err = s.PruneSpeedTests(ctx, store.PruneOptions{Before: day.AddDate(0, 0, 3), Rollup: true})
+	check(t, err)
+	week := store.StatsFilter{From: day.AddDate(0, 0, -7), To: day.AddDate(0, 0, 7)}
+	totals, err = s.GetSpeedTestDayTotals(ctx, week, []time.Time{day})
+	check(t, err)
+	sameTotals(t, "rolled up", totals, []*model.SpeedTestTotals{
+		{Start: day, Tests: 7, Download: model.FigureTotals{Aggregate: model.Aggregate{Sum: 214, Min: 7, Max: 100}}},
+	}, false)
+	totals, err = s.GetSpeedTestDayTotals(ctx, week, []time.Time{day, day.AddDate(0, 0, 1), day.AddDate(0, 0, 2)})
+	check(t, err)
+	if len(totals) != 3 || totals[1].Tests != 1 || totals[2].Download.Min != 7 {
+		t.Errorf("by day, got %d buckets, want 3", len(totals))
+	}
+}
+
+// testStatsZones stores results whose times carry different UTC offsets,
+// as a server's local times do either side of a DST change, and expects
+// them bucketed and pruned by the instants they name.
+func testStatsZones(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	// Central Europe moved from CET to CEST at 01:00 UTC that day
+	cet, cest := time.FixedZone("CET", 3600), time.FixedZone("CEST", 7200)
+	hour := time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC)
+	for i, at := range []time.Time{
+		hour.Add(30 * time.Minute).In(cet),   // 01:30 CET
+		hour.Add(90 * time.Minute).In(cest),  // 03:30 CEST
+		hour.Add(150 * time.Minute).In(cest), // 04:30 CEST
+	} {
+		test := fullSpeedTest(fmt.Sprintf("t%d", i), at)
+		test.ShareCode, test.DownloadMbps = "", float64(10*(i+1))
+		check(t, s.CreateSpeedTest(ctx, test))
+	}
+
+	splits := []time.Time{hour.Add(time.Hour), hour.Add(2 * time.Hour)}
+	totals, err := s.GetSpeedTestTotals(ctx, store.StatsFilter{From: hour, To: hour.Add(3 * time.Hour).In(cest)}, splits)
+	check(t, err)
+	sameTotals(t, "hours", totals, []*model.SpeedTestTotals{
+		{Start: hour, Tests: 1, Download: model.FigureTotals{Aggregate: model.Aggregate{Sum: 10, Min: 10, Max: 10}, Median: 10, P95: 10}},
+		{Start: splits[0], Tests: 1, Download: model.FigureTotals{Aggregate: model.Aggregate{Sum: 20, Min: 20, Max: 20}, Median: 20, P95: 20}},
+		{Start: splits[1], Tests: 1, Download: model.FigureTotals{Aggregate: model.Aggregate{Sum: 30, Min: 30, Max: 30}, Median: 30, P95: 30}},
+	}, true)
+
+	n, err := s.PruneSpeedTests(ctx, store.PruneOptions{Before: hour.Add(2 * time.Hour).In(cet)})
+	check(t, err)
+	if n != 2 {
+		t.Errorf("PruneSpeedTests pruned %d results, want 2", n)
+	}
+}
+
+// sameTotals compares bucket totals by their download figures, and their
+// percentiles if percentiles is set.
+func sameTotals(t *testing.T, name string, got, want []*model.SpeedTestTotals, percentiles bool) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%s: got %d buckets, want %d", name, len(got), len(want))
+	}
+	for i, g := range got {
+		sameTime(t, "Start", g.Start, want[i].Start)
+		d := g.Download
+		if !percentiles {
+			d.Median, d.P95 = 0, 0
+		}
+		if g.Tests != want[i].Tests || math.Abs(d.Sum-want[i].Download.Sum) > 1e-9 || d.Min != want[i].Download.Min ||
+			d.Max != want[i].Download.Max || math.Abs(d.Median-want[i].Download.Median) > 1e-9 || math.Abs(d.P95-want[i].Download.P95) > 1e-9 {
+			t.Errorf("%s: bucket %d = %d tests, %+v, want %d, %+v", name, i, g.Tests, d, want[i].Tests, want[i].Download)
+		}
+	}
+}
+
+// sameDays compares daily aggregates, ignoring upload and jitter.
+func sameDays(t *testing.T, got []*model.SpeedTestDay, want []model.SpeedTestDay) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d days, want %d", len(got), len(want))
+	}
+	for i, g := range got {
+		d := *g
+		d.Upload, d.Jitter = model.Aggregate{}, model.Aggregate{}
+		sameTime(t, "Day", d.Day, want[i].Day)
+		d.Day = want[i].Day
+		if d != want[i] {
+			t.Errorf("day %d = %+v, want %+v", i, d, want[i])
+		}
+	}
+}
+
+func testTestSessions(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	created := now()
+	parent := &model.TestSession{
+		ID:            "p1",
+		Status:        model.TestStatusStarted,
+		DualStack:     true,
+		Streams:       4,
+		Duration:      10,
+		Stages:        "ping,download,upload",
+		PayloadSize:   1048576,
+		LoadedLatency: true,
+		Adaptive:      true,
+		Transport:     "h2",
+		Share:         true,
+		ClientIPHash:  "iphash",
+		CreatedAt:     created,
+		ExpiresAt:     created.Add(time.Hour),
+	}
+	check(t, s.CreateTestSession(ctx, parent))
+	// Children are created IPv6 first to check they come back ordered
+	for _, family := range []string{"ipv6", "ipv4"} {
+		child := *parent
+		child.ID = "p1-" + family
+		child.ParentID = "p1"
+		child.IPFamily = family
+		child.DualStack = false
+		child.Share = false
+		check(t, s.CreateTestSession(ctx, &child))
+	}
+
+	got, err := s.GetTestSession(ctx, "p1")
+	check(t, err)
+	if got == nil {
+		t.Fatal("GetTestSession = nil")
+	}
+	sameTime(t, "CreatedAt", got.CreatedAt, parent.CreatedAt)
+	sameTime(t, "ExpiresAt", got.ExpiresAt, parent.ExpiresAt)
+	g, w := *got, *parent
+	g.CreatedAt, g.ExpiresAt, w.CreatedAt, w.ExpiresAt = time.Time{}, time.Time{}, time.Time{}, time.Time{}
+	if g != w {
+		t.Errorf("GetTestSession = %+v, want %+v", g, w)
+	}
+
+	check(t, s.UpdateTestSessionStatus(ctx, "p1", model.TestStatusComplete))
+	got, err = s.GetTestSession(ctx, "p1")
+	check(t, err)
+	if got.Status != model.TestStatusComplete {
+		t.Errorf("Status = %q after UpdateTestSessionStatus", got.Status)
+	}
+
+	children, err := s.GetChildTestSessions(ctx, "p1")
+	check(t, err)
+	if len(children) != 2 || children[0].IPFamily != "ipv4" || children[1].IPFamily != "ipv6" {
+		t.Fatalf("GetChildTestSessions returned %d sessions, want ipv4 then ipv6", len(children))
+	}
+	if children[0].ParentID != "p1" || children[0].Share {
+		t.Errorf("child = %+v", children[0])
+	}
+
+	children, err = s.GetChildTestSessions(ctx, "nobody")
+	check(t, err)
+	if len(children) != 0 {
+		t.Errorf("GetChildTestSessions(unknown) returned %d sessions", len(children))
+	}
+	got, err = s.GetTestSession(ctx, "missing")
+	mustBeMissing(t, "GetTestSession(unknown)", got, err)
+}
+
+func testAPITokens(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	newUser(t, s, "u1")
+	base := now()
+	check(t, s.CreateAPIToken(ctx, &model.APIToken{ID: "k1", UserID: "u1", Token: "secret-1", Name: "first", CreatedAt: base.Add(-time.Hour)}))
+	check(t, s.CreateAPIToken(ctx, &model.APIToken{ID: "k2", UserID: "u1", Token: "secret-2", Name: "second", CreatedAt: base}))
+
+	got, err := s.GetAPITokenByToken(ctx, "secret-1")
+	check(t, err)
+	if got == nil || got.ID != "k1" || got.Name != "first" || !got.LastUsed.IsZero() {
+		t.Fatalf("GetAPITokenByToken = %+v, want k1 never used", got)
+	}
+
+	used := base.Add(time.Minute)
+	got.Name = "renamed"
+	got.LastUsed = used
+	check(t, s.UpdateAPIToken(ctx, got))
+	got, err = s.GetAPIToken(ctx, "k1")
+	check(t, err)
+	if got == nil || got.Name != "renamed" {
+		t.Fatalf("GetAPIToken after UpdateAPIToken = %+v", got)
+	}
+	sameTime(t, "LastUsed", got.LastUsed, used)
+
+	tokens, err := s.GetUserAPITokens(ctx, "u1")
+	check(t, err)
+	if len(tokens) != 2 || tokens[0].ID != "k2" || tokens[1].ID != "k1" {
+		t.Fatalf("GetUserAPITokens returned %d tokens, want k2, k1", len(tokens))
+	}
+
+	check(t, s.DeleteAPIToken(ctx, "k1"))
+	got, err = s.GetAPIToken(ctx, "k1")
+	mustBeMissing(t, "GetAPIToken after DeleteAPIToken", got, err)
+	got, err = s.GetAPITokenByToken(ctx, "secret-1")
+	mustBeMissing(t, "GetAPITokenByToken after DeleteAPIToken", got, err)
+}
+
+func testSessions(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	newUser(t, s, "u1")
+	base := now()
+	check(t, s.CreateSession(ctx, &model.Session{ID: "live", UserID: "u1", Data: "{}", ExpiresAt: base.Add(time.Hour), CreatedAt: base}))
+	check(t, s.CreateSession(ctx, &model.Session{ID: "expired", UserID: "u1", Data: "{}", ExpiresAt: base.Add(-time.Hour), CreatedAt: base}))
+
+	got, err := s.GetSession(ctx, "live")
+	check(t, err)
+	if got == nil || got.UserID != "u1" || got.Data != "{}" {
+		t.Fatalf("GetSession = %+v", got)
+	}
+	sameTime(t, "ExpiresAt", got.ExpiresAt, base.Add(time.Hour))
+
+	got, err = s.GetSession(ctx, "expired")
+	mustBeMissing(t, "GetSession(expired)", got, err)
+
+	check(t, s.DeleteExpiredSessions(ctx))
+	got, err = s.GetSession(ctx, "live")
+	check(t, err)
+	if got == nil {
+		t.Fatal("DeleteExpiredSessions removed a live session")
+	}
+
+	check(t, s.DeleteSession(ctx, "live"))
+	got, err = s.GetSession(ctx, "live")
+	mustBeMissing(t, "GetSession after DeleteSession", got, err)
+}
+
+func testAdmins(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	admin := &model.Admin{Username: "root", Password: "hash", Email: "root@example.com", Role: "admin", Enabled: true}
+	check(t, s.CreateAdmin(ctx, admin))
+	if admin.ID == 0 {
+		t.Fatal("CreateAdmin didn't set the ID")
+	}
+
+	got, err := s.GetAdminByUsername(ctx, "root")
+	check(t, err)
+	if got == nil || got.ID != admin.ID || got.Password != "hash" || got.Email != "root@example.com" || got.Role != "admin" || !got.Enabled {
+		t.Fatalf("GetAdminByUsername = %+v", got)
+	}
+	if !got.LastLogin.IsZero() || !got.LockedUntil.IsZero() || got.FailedAttempts != 0 {
+		t.Errorf("new admin has login state %+v", got)
+	}
+
+	check(t, s.UpdateAdminFailedAttempts(ctx, admin.ID, 3))
+	until := time.Now().Add(time.Hour).Truncate(time.Second)
+	check(t, s.LockAdmin(ctx, admin.ID, until))
+	got, err = s.GetAdminByUsername(ctx, "root")
+	check(t, err)
+	if got.FailedAttempts != 3 {
+		t.Errorf("FailedAttempts = %d, want 3", got.FailedAttempts)
+	}
+	sameTime(t, "LockedUntil", got.LockedUntil, until)
+
+	check(t, s.UpdateAdminLastLogin(ctx, admin.ID))
+	got, err = s.GetAdminByUsername(ctx, "root")
+	check(t, err)
+	if got.FailedAttempts != 0 || !got.LockedUntil.IsZero() || got.LastLogin.IsZero() {
+		t.Errorf("after UpdateAdminLastLogin: attempts %d, locked until %v, last login %v", got.FailedAttempts, got.LockedUntil, got.LastLogin)
+	}
+
+	if err := s.CreateAdmin(ctx, &model.Admin{Username: "root", Password: "x", Role: "admin"}); err == nil {
+		t.Error("CreateAdmin with a taken username succeeded")
+	}
+	missing, err := s.GetAdminByUsername(ctx, "nobody")
+	mustBeMissing(t, "GetAdminByUsername(unknown)", missing, err)
+}
+
+func testAdminSessions(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	admin := &model.Admin{Username: "root", Password: "hash", Role: "admin", Enabled: true}
+	check(t, s.CreateAdmin(ctx, admin))
+
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+	check(t, s.CreateAdminSession(ctx, &model.AdminSession{ID: "live", AdminID: admin.ID, IPAddress: "192.0.2.1", UserAgent: "browser", ExpiresAt: expires}))
+	check(t, s.CreateAdminSession(ctx, &model.AdminSession{ID: "expired", AdminID: admin.ID, IPAddress: "192.0.2.1", ExpiresAt: time.Now().Add(-time.Hour)}))
+
+	got, err := s.GetAdminSession(ctx, "live")
+	check(t, err)
+	if got == nil || got.AdminID != admin.ID || got.IPAddress != "192.0.2.1" || got.UserAgent != "browser" {
+		t.Fatalf("GetAdminSession = %+v", got)
+	}
+	sameTime(t, "ExpiresAt", got.ExpiresAt, expires)
+	check(t, s.UpdateAdminSessionActivity(ctx, "live"))
+
+	got, err = s.GetAdminSession(ctx, "expired")
+	mustBeMissing(t, "GetAdminSession(expired)", got, err)
+
+	check(t, s.DeleteExpiredAdminSessions(ctx))
+	got, err = s.GetAdminSession(ctx, "live")
+	check(t, err)
+	if got == nil {
+		t.Fatal("DeleteExpiredAdminSessions removed a live session")
+	}
+
+	check(t, s.DeleteAdminSession(ctx, "live"))
+	got, err = s.GetAdminSession(ctx, "live")
+	mustBeMissing(t, "GetAdminSession after DeleteAdminSession", got, err)
+}
+
+func testMigrations(t *testing.T, s store.VersionedStore) {
+	ctx := context.Background()
+
+	applied, err := s.MigrateUp(ctx)
+	check(t, err)
+	if len(applied) != 0 {
+		t.Errorf("MigrateUp on a migrated store applied %d migrations", len(applied))
+	}
+
+	status, err := s.Migrations(ctx)
+	check(t, err)
+	latest := status[len(status)-1]
+	for _, m := range status {
+		if !m.Applied || m.Unknown {
+			t.Errorf("migration %d: %+v, want applied", m.Version, m)
+		}
+	}
+
+	reverted, err := s.MigrateDown(ctx)
+	check(t, err)
+	if reverted.Version != latest.Version {
+		t.Errorf("MigrateDown reverted %d, want %d", reverted.Version, latest.Version)
+	}
+	applied, err = s.MigrateUp(ctx)
+	check(t, err)
+	if len(applied) != 1 || applied[0].Version != latest.Version {
+		t.Errorf("MigrateUp after MigrateDown applied %+v, want migration %d", applied, latest.Version)
+	}
+}
+
+// testNotFound pins what every lookup returns on an empty store: nil and
+// no error for single rows, nothing for lists.
+func testNotFound(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	for _, tt := range []struct {
+		name string
+		get  func() (any, error)
+	}{
+		{"GetUser", func() (any, error) { return s.GetUser(ctx, "missing") }},
+		{"GetUserByUsername", func() (any, error) { return s.GetUserByUsername(ctx, "missing") }},
+		{"GetUserByEmail", func() (any, error) { return s.GetUserByEmail(ctx, "missing@example.com") }},
+		{"GetDevice", func() (any, error) { return s.GetDevice(ctx, "missing") }},
+		{"GetUserDevices", func() (any, error) { return s.GetUserDevices(ctx, "missing") }},
+		{"GetSpeedTest", func() (any, error) { return s.GetSpeedTest(ctx, "missing") }},
+		{"GetSpeedTestByShareCode", func() (any, error) { return s.GetSpeedTestByShareCode(ctx, "missing") }},
+		{"GetUserSpeedTests", func() (any, error) { return s.GetUserSpeedTests(ctx, "missing", 10, 0) }},
+		{"GetDeviceSpeedTests", func() (any, error) { return s.GetDeviceSpeedTests(ctx, "missing", 10, 0) }},
+		{"GetTestSession", func() (any, error) { return s.GetTestSession(ctx, "missing") }},
+		{"GetChildTestSessions", func() (any, error) { return s.GetChildTestSessions(ctx, "missing") }},
+		{"GetAPIToken", func() (any, error) { return s.GetAPIToken(ctx, "missing") }},
+		{"GetAPITokenByToken", func() (any, error) { return s.GetAPITokenByToken(ctx, "missing") }},
+		{"GetUserAPITokens", func() (any, error) { return s.GetUserAPITokens(ctx, "missing") }},
+		{"GetSession", func() (any, error) { return s.GetSession(ctx, "missing") }},
+		{"GetAdminByUsername", func() (any, error) { return s.GetAdminByUsername(ctx, "missing") }},
+		{"GetAdminSession", func() (any, error) { return s.GetAdminSession(ctx, "missing") }},
+	} {
+		got, err := tt.get()
+		if err != nil {
+			t.Errorf("%s: %v, want no error", tt.name, err)
+			continue
+		}
+		if v := reflect.ValueOf(got); !v.IsNil() && !(v.Kind() == reflect.Slice && v.Len() == 0) {
+			t.Errorf("%s = %+v, want nothing", tt.name, got)
+		}
+	}
+}
+
+// testMissingRows checks that updates and deletes of rows that don't
+// exist do nothing and don't fail.
+func testMissingRows(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	for _, tt := range []struct {
+		name  string
+		write func() error
+	}{
+		{"UpdateUser", func() error {
+			return s.UpdateUser(ctx, &model.User{ID: "missing", Username: "missing", Email: "missing@example.com"})
+		}},
+		{"DeleteUser", func() error { return s.DeleteUser(ctx, "missing") }},
+		{"UpdateDevice", func() error { return s.UpdateDevice(ctx, &model.Device{ID: "missing", Name: "missing"}) }},
+		{"DeleteDevice", func() error { return s.DeleteDevice(ctx, "missing") }},
+		{"UpdateSpeedTest", func() error { return s.UpdateSpeedTest(ctx, &model.SpeedTest{ID: "missing", ShareCode: "missing"}) }},
+		{"DeleteSpeedTest", func() error { return s.DeleteSpeedTest(ctx, "missing") }},
+		{"IncrementShareViews", func() error { return s.IncrementShareViews(ctx, "missing") }},
+		{"UpdateTestSessionStatus", func() error { return s.UpdateTestSessionStatus(ctx, "missing", model.TestStatusComplete) }},
+		{"UpdateAPIToken", func() error { return s.UpdateAPIToken(ctx, &model.APIToken{ID: "missing", Name: "missing"}) }},
+		{"DeleteAPIToken", func() error { return s.DeleteAPIToken(ctx, "missing") }},
+		{"DeleteSession", func() error { return s.DeleteSession(ctx, "missing") }},
+		{"DeleteExpiredSessions", func() error { return s.DeleteExpiredSessions(ctx) }},
+		{"UpdateAdminLastLogin", func() error { return s.UpdateAdminLastLogin(ctx, 999) }},
+		{"UpdateAdminFailedAttempts", func() error { return s.UpdateAdminFailedAttempts(ctx, 999, 1) }},
+		{"LockAdmin", func() error { return s.LockAdmin(ctx, 999, time.Now().Add(time.Hour)) }},
+		{"UpdateAdminSessionActivity", func() error { return s.UpdateAdminSessionActivity(ctx, "missing") }},
+		{"DeleteAdminSession", func() error { return s.DeleteAdminSession(ctx, "missing") }},
+		{"DeleteExpiredAdminSessions", func() error { return s.DeleteExpiredAdminSessions(ctx) }},
+	} {
+		if err := tt.write(); err != nil {
+			t.Errorf("%s: %v, want no error", tt.name, err)
+		}
+	}
+
+	// None of them may have created anything
+	user, err := s.GetUser(ctx, "missing")
+	mustBeMissing(t, "GetUser after UpdateUser(missing)", user, err)
+	test, err := s.GetSpeedTestByShareCode(ctx, "missing")
+	mustBeMissing(t, "GetSpeedTestByShareCode after UpdateSpeedTest(missing)", test, err)
+}
+
+// testDuplicates checks that creating a row whose key or unique column is
+// taken fails.
+func testDuplicates(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	newUser(t, s, "u1")
+	newDevice(t, s, "d1", "u1", now())
+	check(t, s.CreateSpeedTest(ctx, fullSpeedTest("t1", now())))
+	check(t, s.CreateTestSession(ctx, &model.TestSession{ID: "s1", Status: model.TestStatusStarted, CreatedAt: now(), ExpiresAt: now().Add(time.Hour)}))
+	check(t, s.CreateAPIToken(ctx, &model.APIToken{ID: "k1", UserID: "u1", Token: "secret", CreatedAt: now()}))
+	check(t, s.CreateSession(ctx, &model.Session{ID: "w1", UserID: "u1", ExpiresAt: now().Add(time.Hour), CreatedAt: now()}))
+	admin := &model.Admin{Username: "root", Password: "hash", Role: "admin", Enabled: true}
+	check(t, s.CreateAdmin(ctx, admin))
+	check(t, s.CreateAdminSession(ctx, &model.AdminSession{ID: "a1", AdminID: admin.ID, ExpiresAt: time.Now().Add(time.Hour)}))
+
+	for _, tt := range []struct {
+		name   string
+		create func() error
+	}{
+		{"user ID", func() error {
+			return s.CreateUser(ctx, &model.User{ID: "u1", Username: "other", Email: "other@example.com", CreatedAt: now()})
+		}},
+		{"user email", func() error {
+			return s.CreateUser(ctx, &model.User{ID: "u2", Username: "other", Email: "u1@example.com", CreatedAt: now()})
+		}},
+		{"device ID", func() error { return s.CreateDevice(ctx, &model.Device{ID: "d1", UserID: "u1", CreatedAt: now()}) }},
+		{"speed test ID", func() error {
+			test := fullSpeedTest("t1", now())
+			test.ShareCode = ""
+			return s.CreateSpeedTest(ctx, test)
+		}},
+		{"test session ID", func() error {
+			return s.CreateTestSession(ctx, &model.TestSession{ID: "s1", Status: model.TestStatusStarted, CreatedAt: now(), ExpiresAt: now()})
+		}},
+		{"API token ID", func() error {
+			return s.CreateAPIToken(ctx, &model.APIToken{ID: "k1", UserID: "u1", Token: "other", CreatedAt: now()})
+		}},
+		{"API token", func() error {
+			return s.CreateAPIToken(ctx, &model.APIToken{ID: "k2", UserID: "u1", Token: "secret", CreatedAt: now()})
+		}},
+		{"session ID", func() error {
+			return s.CreateSession(ctx, &model.Session{ID: "w1", UserID: "u1", ExpiresAt: now(), CreatedAt: now()})
+		}},
+		{"admin session ID", func() error {
+			return s.CreateAdminSession(ctx, &model.AdminSession{ID: "a1", AdminID: admin.ID, ExpiresAt: time.Now()})
+		}},
+	} {
+		if err := tt.create(); err == nil {
+			t.Errorf("creating a duplicate %s succeeded", tt.name)
+		}
+	}
+
+	// A failed create leaves the original alone
+	token, err := s.GetAPIToken(ctx, "k1")
+	check(t, err)
+	if token == nil || token.Token != "secret" {
+		t.Errorf("GetAPIToken after duplicate creates = %+v", token)
+	}
+}
+
+// testCopies checks that a store doesn't share memory with its callers:
+// changing a value after storing it, or one it returned, changes nothing
+// stored.
+func testCopies(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	user := newUser(t, s, "u1")
+	user.Username = "changed"
+
+	test := fullSpeedTest("t1", now())
+	test.UserID = "u1"
+	want := fullSpeedTest("t1", test.Timestamp)
+	want.UserID = "u1"
+	check(t, s.CreateSpeedTest(ctx, test))
+	test.DownloadMbps = 1
+	test.DownloadStats.Series[0] = 1
+	test.DownloadTCP.Streams[0].Retransmits = 1
+
+	got, err := s.GetSpeedTest(ctx, "t1")
+	check(t, err)
+	sameSpeedTest(t, got, want)
+	got.UploadStats.Series[0] = 1
+	got.Setup.DNSMs = 1
+	lists, err := s.GetUserSpeedTests(ctx, "u1", 10, 0)
+	check(t, err)
+	for _, l := range lists {
+		l.DownloadStats.Series[0] = 1
+	}
+
+	got, err = s.GetSpeedTest(ctx, "t1")
+	check(t, err)
+	sameSpeedTest(t, got, want)
+	stored, err := s.GetUser(ctx, "u1")
+	check(t, err)
+	if stored.Username != "user-u1" {
+		t.Errorf("Username = %q, changed through the caller's copy", stored.Username)
+	}
+}
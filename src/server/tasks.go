@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/casapps/casspeed/src/scheduler"
+	"github.com/casapps/casspeed/src/server/store"
+)
+
+// startScheduler starts the scheduled tasks the server has handlers for,
+// when the scheduler is enabled. Tasks are configured under
+// server.scheduler.tasks by ID.
+func (s *Server) startScheduler() error {
+	if !s.Config.Server.Scheduler.Enabled {
+		return nil
+	}
+	sched, err := scheduler.New("Local")
+	if err != nil {
+		return err
+	}
+
+	tasks := []struct {
+		id, name string
+		handler  func(context.Context) error
+	}{
+		{"results_retention", "Results retention", s.pruneResults},
+	}
+	for _, t := range tasks {
+		cfg, ok := s.Config.Server.Scheduler.Tasks[t.id]
+		if !ok {
+			continue
+		}
+		err := sched.AddTask(&scheduler.Task{
+			ID:       t.id,
+			Name:     t.name,
+			Schedule: cfg.Schedule,
+			Handler:  t.handler,
+			Enabled:  cfg.Enabled,
+		})
+		if err != nil {
+			return fmt.Errorf("task %s: %w", t.id, err)
+		}
+	}
+
+	sched.Start()
+	s.Scheduler = sched
+	return nil
+}
+
+// pruneResults deletes the results test.results_retention no longer
+// keeps.
+func (s *Server) pruneResults(ctx context.Context) error {
+	opts, ok := store.RetentionOptions(s.Config.Test, time.Now())
+	if !ok {
+		return nil
+	}
+	n, err := s.Store.PruneSpeedTests(ctx, opts)
+	if err != nil {
+		return fmt.Errorf("pruning results: %w", err)
+	}
+	if n > 0 {
+		fmt.Printf("🧹 Pruned %d results older than %d days\n", n, s.Config.Test.ResultsRetention)
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package ssl
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"net"
 	"os"
@@ -117,11 +118,11 @@ func (m *Manager) validateCertPaths(certPath, keyPath, source string) *CertInfo
 	leaf := cert.Leaf
 	if leaf == nil {
 		// Parse if not already done
-		parsed, err := tls.X509KeyPair(certPath, keyPath)
-		if err != nil || len(parsed.Certificate) == 0 {
+		parsed, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
 			return nil
 		}
-		leaf = parsed.Leaf
+		leaf = parsed
 	}
 
 	if leaf == nil {
@@ -204,6 +205,12 @@ func GetAllDomains() []string {
 	return domains
 }
 
+// GetGlobalIPs returns the first public IPv4 and IPv6 addresses, either
+// empty if the host has none
+func GetGlobalIPs() (ipv4, ipv6 string) {
+	return getGlobalIPv4(), getGlobalIPv6()
+}
+
 // isLoopback checks if host is localhost or loopback IP
 func isLoopback(host string) bool {
 	lower := strings.ToLower(host)
@@ -61,10 +61,89 @@ func SpecHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	],
 	"paths": {
+		"/servers": {
+			"get": {
+				"summary": "List servers",
+				"description": "This server followed by its configured peers, each with id, name, location and url; clients test against the nearest or a chosen one"
+			}
+		},
 		"/speedtest/start": {
 			"post": {
 				"summary": "Start speed test",
-				"description": "Initialize a new speed test",
+				"description": "Open a test session for a WebSocket or client-driven run",
+				"parameters": [
+					{
+						"name": "share",
+						"in": "query",
+						"schema": {
+							"type": "boolean"
+						}
+					},
+					{
+						"name": "duration",
+						"in": "query",
+						"description": "Seconds per download/upload stage",
+						"schema": {
+							"type": "integer"
+						}
+					},
+					{
+						"name": "streams",
+						"in": "query",
+						"description": "Parallel transfers per stage",
+						"schema": {
+							"type": "integer"
+						}
+					},
+					{
+						"name": "stages",
+						"in": "query",
+						"description": "Comma-separated stages: ping, setup, udp, download, upload (default: ping, download, upload)",
+						"schema": {
+							"type": "string"
+						}
+					},
+					{
+						"name": "payload_size",
+						"in": "query",
+						"description": "Bytes per download response and upload request",
+						"schema": {
+							"type": "integer"
+						}
+					},
+					{
+						"name": "loaded_latency",
+						"in": "query",
+						"schema": {
+							"type": "boolean"
+						}
+					},
+					{
+						"name": "adaptive",
+						"in": "query",
+						"description": "Ramp streams and end stages once throughput is stable",
+						"schema": {
+							"type": "boolean"
+						}
+					},
+					{
+						"name": "dual_stack",
+						"in": "query",
+						"description": "Open one run per IP family under a parent test; the response lists them in runs",
+						"schema": {
+							"type": "boolean"
+						}
+					},
+					{
+						"name": "transport",
+						"in": "query",
+						"description": "Pin pings and transfers to one HTTP transport; h2 needs TLS and h3 needs HTTP/3 enabled",
+						"schema": {
+							"type": "string",
+							"enum": ["http1", "h2", "h3"]
+						}
+					}
+				],
 				"responses": {
 					"200": {
 						"description": "Test started",
@@ -78,6 +157,84 @@ func SpecHandler(w http.ResponseWriter, r *http.Request) {
 										},
 										"status": {
 											"type": "string"
+										},
+										"streams": {
+											"type": "integer"
+										},
+										"duration": {
+											"type": "integer"
+										},
+										"stages": {
+											"type": "array",
+											"items": {
+												"type": "string"
+											}
+										},
+										"payload_size": {
+											"type": "integer"
+										},
+										"loaded_latency": {
+											"type": "boolean"
+										},
+										"adaptive": {
+											"type": "boolean"
+										},
+										"transport": {
+											"type": "string",
+											"description": "Pinned transport, empty for any"
+										},
+										"http3_port": {
+											"type": "integer",
+											"description": "UDP port of the HTTP/3 listener, present when it runs"
+										},
+										"expires_at": {
+											"type": "string"
+										},
+										"dual_stack": {
+											"type": "boolean"
+										},
+										"client_family": {
+											"type": "string",
+											"description": "Dual-stack only: ipv4 or ipv6, the family the client started over"
+										},
+										"runs": {
+											"type": "array",
+											"description": "Dual-stack only: one run per IP family",
+											"items": {
+												"type": "object",
+												"properties": {
+													"ip_family": {
+														"type": "string"
+													},
+													"test_id": {
+														"type": "string"
+													},
+													"address": {
+														"type": "string"
+													},
+													"expires_at": {
+														"type": "string"
+													}
+												}
+											}
+										},
+										"udp": {
+											"type": "object",
+											"description": "UDP stage plan, present when the udp stage runs",
+											"properties": {
+												"port": {
+													"type": "integer"
+												},
+												"rate": {
+													"type": "integer"
+												},
+												"packet_size": {
+													"type": "integer"
+												},
+												"count": {
+													"type": "integer"
+												}
+											}
 										}
 									}
 								}
@@ -90,19 +247,176 @@ func SpecHandler(w http.ResponseWriter, r *http.Request) {
 		"/speedtest/ws": {
 			"get": {
 				"summary": "Speed test WebSocket",
-				"description": "WebSocket endpoint for real-time test progress"
+				"description": "WebSocket endpoint for real-time test progress",
+				"parameters": [
+					{
+						"name": "test_id",
+						"in": "query",
+						"description": "Session from /speedtest/start to run",
+						"schema": {
+							"type": "string"
+						}
+					}
+				]
+			}
+		},
+		"/speedtest/events": {
+			"get": {
+				"summary": "Speed test event stream",
+				"description": "Server-Sent Events alternative to the WebSocket: progress updates as message events and latency probes as ping events, answered through /speedtest/pong. Closing the stream cancels the run.",
+				"parameters": [
+					{
+						"name": "test_id",
+						"in": "query",
+						"description": "Session from /speedtest/start to run",
+						"schema": {
+							"type": "string"
+						}
+					}
+				],
+				"responses": {
+					"200": {
+						"description": "Event stream",
+						"content": {
+							"text/event-stream": {}
+						}
+					},
+					"404": {
+						"description": "Test session not found or expired"
+					},
+					"409": {
+						"description": "Test session already in use"
+					}
+				}
+			}
+		},
+		"/speedtest/pong": {
+			"post": {
+				"summary": "Answer an event-stream latency probe",
+				"parameters": [
+					{
+						"name": "test_id",
+						"in": "query",
+						"required": true,
+						"schema": {
+							"type": "string"
+						}
+					},
+					{
+						"name": "seq",
+						"in": "query",
+						"required": true,
+						"description": "Sequence number of the ping event",
+						"schema": {
+							"type": "integer"
+						}
+					}
+				],
+				"responses": {
+					"204": {
+						"description": "Probe answered"
+					},
+					"404": {
+						"description": "No event stream is running for the test"
+					}
+				}
+			}
+		},
+		"/speedtest/setup": {
+			"post": {
+				"summary": "Report connection setup timings",
+				"description": "JSON body of client-measured medians for the setup stage of a server-driven run: dns_ms, connect_ms, tls_ms, ttfb_ms and samples (0 when nothing was measured)",
+				"parameters": [
+					{
+						"name": "test_id",
+						"in": "query",
+						"required": true,
+						"schema": {
+							"type": "string"
+						}
+					}
+				],
+				"responses": {
+					"204": {
+						"description": "Timings accepted"
+					},
+					"400": {
+						"description": "Invalid body, or the session does not run the setup stage"
+					},
+					"404": {
+						"description": "Test session not found or expired"
+					},
+					"409": {
+						"description": "Timings already reported"
+					},
+					"422": {
+						"description": "Timings out of range"
+					}
+				}
+			}
+		},
+		"/speedtest/ping": {
+			"get": {
+				"summary": "Latency probe",
+				"description": "Returns 204 immediately for client-side RTT measurement"
 			}
 		},
 		"/speedtest/download": {
 			"get": {
 				"summary": "Download test endpoint",
-				"description": "Generates random data for download speed testing"
+				"description": "Generates data for download speed testing",
+				"parameters": [
+					{
+						"name": "test_id",
+						"in": "query",
+						"description": "Test session to credit the bytes to",
+						"schema": {
+							"type": "string"
+						}
+					},
+					{
+						"name": "stream",
+						"in": "query",
+						"description": "Keep sending until the client disconnects or the server's stream limit is reached",
+						"schema": {
+							"type": "boolean"
+						}
+					}
+				]
 			}
 		},
 		"/speedtest/upload": {
 			"post": {
 				"summary": "Upload test endpoint",
-				"description": "Consumes uploaded data for upload speed testing"
+				"description": "Consumes uploaded data for upload speed testing, crediting it to the test session",
+				"parameters": [
+					{
+						"name": "test_id",
+						"in": "query",
+						"required": true,
+						"description": "Live test session to credit the bytes to",
+						"schema": {
+							"type": "string"
+						}
+					}
+				],
+				"responses": {
+					"200": {
+						"description": "Bytes received"
+					},
+					"400": {
+						"description": "Missing test_id"
+					},
+					"404": {
+						"description": "Test session not found or expired"
+					},
+					"408": {
+						"description": "Test session deadline passed"
+					},
+					"413": {
+						"description": "Body larger than the session's payload size"
+					}
+				}
 			}
 		},
 		"/speedtest/result/{id}": {
@@ -119,6 +433,34 @@ func SpecHandler(w http.ResponseWriter, r *http.Request) {
 						}
 					}
 				]
+			},
+			"post": {
+				"summary": "Submit client-driven result",
+				"description": "Submit a client-measured result; it is cross-checked against the bytes the server moved for the session",
+				"parameters": [
+					{
+						"name": "id",
+						"in": "path",
+						"required": true,
+						"schema": {
+							"type": "string"
+						}
+					}
+				],
+				"responses": {
+					"200": {
+						"description": "Result accepted and stored"
+					},
+					"404": {
+						"description": "Test session not found or expired"
+					},
+					"409": {
+						"description": "Result already submitted"
+					},
+					"422": {
+						"description": "Result does not match server measurements"
+					}
+				}
 			}
 		},
 		"/speedtest/history": {